@@ -1,12 +1,19 @@
 package main
 
 import (
+	"context"
+	"encoding/base64"
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
 
 	"strings"
+	"time"
 
 	"github.com/cnelson/evaporation/proxy"
 
@@ -23,25 +30,209 @@ func (m *multiValue) Set(value string) error {
 	*m = append(*m, value)
 	return nil
 }
+
+// The subcommands we support, and what they do.
+var commands = map[string]func(args []string){
+	"serve":  serve,
+	"status": status,
+}
+
 func usage() {
-	fmt.Printf("Usage: %s [OPTIONS] url\n", os.Args[0])
-	fmt.Println("   url - A magnet url or http url to a .torrent file.")
+	fmt.Printf("Usage: %s [COMMAND] [OPTIONS] ...\n", os.Args[0])
+	fmt.Println("COMMANDS:")
+	fmt.Println("   serve  - Start the proxy. This is the default if no command is given.")
+	fmt.Println("   status - Show the status of a running proxy.")
+	fmt.Println("   add    - Add a .torrent file to a running proxy.")
+	fmt.Println("   rm     - Remove a torrent from a running proxy.")
+	fmt.Println("   seed   - Create a torrent from a directory, seed it, and serve its files.")
+	fmt.Println("   mount  - Mount a torrent as a read-only filesystem. Linux, macOS and FreeBSD only.")
+	fmt.Println()
+	fmt.Printf("Run %s COMMAND -h for the options of each command.\n", os.Args[0])
+}
+
+// Render a progress bar for the whole torrent, weighting each file by its size, and leaving out
+// those that are skipped.
+func progressBar(s *proxy.TorrentStatus) (bar string, done bool) {
+	const width = 40
+
+	var total, complete float64
+	done = s.Status == "ready"
+	for _, f := range s.Files {
+		// skipped files are never downloaded, so they'd never be done
+		if f.Priority == proxy.PrioritySkip {
+			continue
+		}
+
+		total += float64(f.Length)
+		complete += float64(f.Length) * float64(f.Complete)
+		if f.Complete < 1 {
+			done = false
+		}
+	}
 
-	fmt.Println("OPTIONS:")
-	flag.PrintDefaults()
+	fraction := 0.0
+	if total > 0 {
+		fraction = complete / total
+	}
+
+	filled := int(fraction * width)
+	bar = fmt.Sprintf("[%s%s] %5.1f%% %s", strings.Repeat("#", filled), strings.Repeat(" ", width-filled), fraction*100, s.Name)
+
+	return
+}
+
+// Download the whole torrent, printing progress, and exit once every file is complete, or with
+// an error if the torrent is refused.
+func download(p *proxy.TorrentProxy) {
+	go p.DownloadAll()
+
+	for {
+		s := p.Status()
+		if s.Status == "refused" {
+			p.Close()
+			log.Fatalf("Not downloading %s: %s", s.Name, s.Refused)
+		}
+
+		bar, done := progressBar(s)
+		fmt.Printf("\r%s", bar)
+
+		if done {
+			fmt.Println()
+			p.Close()
+			os.Exit(0)
+		}
+
+		time.Sleep(time.Second)
+	}
+}
+
+// Return the key in the file at path, for -data-key-file, or "" if path is empty.
+func readKeyFile(path string) string {
+	if len(path) == 0 {
+		return ""
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		log.Fatalf("Unable to read -data-key-file: %s", err)
+	}
+
+	return strings.TrimSpace(string(data))
+}
+
+// Return the torrent URLs in args, with "-" replaced by a data: URL holding the .torrent file
+// read from stdin, so one can be piped in.
+func torrentURLs(args []string) (urls []string) {
+	stdin := false
+	for _, arg := range args {
+		if arg == "-" {
+			if stdin {
+				log.Fatal("Only one url can be read from stdin")
+			}
+			stdin = true
+
+			data, err := ioutil.ReadAll(os.Stdin)
+			if err != nil {
+				log.Fatalf("Unable to read torrent file from stdin: %s", err)
+			}
+			if len(data) == 0 {
+				log.Fatal("No torrent file on stdin")
+			}
+			arg = "data:application/x-bittorrent;base64," + base64.StdEncoding.EncodeToString(data)
+		}
+
+		urls = append(urls, arg)
+	}
+
+	return
 }
 
 func main() {
-	var dhtNodes multiValue
+	args := os.Args[1:]
 
-	flag.Usage = usage
-	flag.Var(&dhtNodes, "dht", "host:port to seed DHT. Can be specified more than once.")
+	// for compatibility, anything that isn't a command is an argument to serve
+	command := "serve"
+	if len(args) > 0 {
+		if _, ok := commands[args[0]]; ok {
+			command = args[0]
+			args = args[1:]
+		} else if args[0] == "help" {
+			usage()
+			os.Exit(0)
+		}
+	}
 
-	var httpaddr = flag.String("http", "localhost:0", `host:port for the HTTP server to listen on. Use ":port" to listen on all interfaces. `)
-	flag.Parse()
+	commands[command](args)
+}
 
-	if flag.NArg() < 1 {
-		flag.Usage()
+// Start the proxy and block until it exits.
+func serve(args []string) {
+	var dhtNodes, torrentHeaders, feeds multiValue
+
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Printf("Usage: %s serve [OPTIONS] url...\n", os.Args[0])
+		fmt.Println("   url - A magnet url, an http url to a .torrent file, or a data: url holding one in base64.")
+		fmt.Println("         - reads a .torrent file from stdin. Not required when -bundle is used.")
+		fmt.Println("         With more than one, -watch-dir or -feed, each torrent is served under /{infohash}/ or /{name}/.")
+
+		fmt.Println("OPTIONS:")
+		flags.PrintDefaults()
+
+		fmt.Println("ENVIRONMENT:")
+		fmt.Println("   EVAPORATION_READ_TOKEN - Bearer token required to read the JSON API.")
+		fmt.Println("   EVAPORATION_ADMIN_TOKEN - Bearer token required to change anything through the JSON API.")
+	}
+	flags.Var(&dhtNodes, "dht", "host:port to seed DHT. Can be specified more than once.")
+	flags.Var(&feeds, "feed", "RSS or Atom feed url to serve the torrents of new items from. Can be specified more than once.")
+	flags.Var(&torrentHeaders, "torrent-header", `"Name: value" header to send when fetching an http(s) url, like a Cookie. Can be specified more than once.`)
+
+	var httpaddr = flags.String("http", "localhost:0", `host:port for the HTTP server to listen on. Use ":port" to listen on all interfaces, or unix:///path/to.sock for a unix socket, with -public-url. `)
+	var peeraddr = flags.String("peer-addr", ":0", "host:port for the torrent client to accept peer connections on.")
+	var dhtaddr = flags.String("dht-addr", "", "host:port for DHT traffic. Defaults to sharing the UDP port of -peer-addr.")
+	var transport = flags.String("transport", "both", `Transports to connect to peers over: "tcp", "utp" or "both".`)
+	var peerInterface = flags.String("peer-interface", "", `Network interface, like "tun0", to bind peer and DHT traffic to.`)
+	var ipVersion = flags.String("ip-version", "both", `IP versions to reach peers over: "ipv4", "ipv6" or "both".`)
+	var maxPeers = flags.Int("max-peers", 0, "Most peers to stay connected to. 0 for the torrent client's default.")
+	var maxHalfOpen = flags.Int("max-half-open", 0, "Most peer connections to attempt at once. 0 for the torrent client's default.")
+	var upnp = flags.Bool("upnp", false, "Forward the -peer-addr port on the router with UPnP, so more peers can connect.")
+	var encryption = flags.String("encryption", "preferred", `Whether to encrypt peer connections: "disabled", "preferred" or "required".`)
+	var datadir = flags.String("datadir", ".", "Directory in which torrent data will be stored, in a directory per infohash.")
+	var flatDataDir = flags.Bool("flat-datadir", false, "Store torrent files directly in -datadir, not in a directory per infohash, to find data from older versions.")
+	var bufferSize = flags.Int("buffer-size", 32<<10, "Size in bytes of the buffer used when copying torrent data to HTTP responses.")
+	var downloadOnly = flags.Bool("download-only", false, "Download the torrent to -datadir and exit once complete, without starting the HTTP server.")
+	var drainTimeout = flags.Duration("drain-timeout", 10*time.Second, "How long to wait for active requests to finish when shutting down.")
+	var bundle = flags.String("bundle", "", "Path to a bundle exported from another instance to start from.")
+	var stream = flags.Bool("stream", false, "Download files in order from where they are being read, for faster media playback.")
+	var skipJunk = flags.Bool("skip-junk", false, "Don't download samples, proofs, and other obvious extras.")
+	var serveRate = flags.Int64("serve-rate", 0, "Most bytes per second to send to all clients together, with media streams favored over downloads. 0 for no limit.")
+	var maxStreams = flags.Int("max-streams", 0, "Most files to serve at once. 0 for no limit.")
+	var maxClientStreams = flags.Int("max-client-streams", 0, "Most files to serve at once to one client IP. 0 for no limit.")
+	var dlna = flags.Bool("dlna", false, `Announce the proxy to DLNA players on the LAN. Use with -http ":port" so they can reach it.`)
+	var torrentProxy = flags.String("torrent-proxy", "", "HTTP proxy to fetch an http(s) url through. Defaults to HTTP_PROXY and HTTPS_PROXY.")
+	var torrentRetries = flags.Int("torrent-retries", 3, "How many times to retry fetching an http(s) url after a network error or 5xx response. -1 to not retry.")
+	var torrentTimeout = flags.Duration("torrent-timeout", 0, "How long fetching an http(s) url may take. 0 for no limit.")
+	var noCompression = flags.Bool("no-compression", false, "Don't compress JSON, listings and text files for clients that accept gzip or deflate.")
+	var basePath = flags.String("base-path", "", `Path prefix, like "/torrent", a reverse proxy serves the proxy under.`)
+	var publicURL = flags.String("public-url", "", "Base URL clients reach the proxy at, like https://example.com, without -base-path. Required for a unix socket.")
+	var socketMode = flags.String("socket-mode", "0660", "Permissions, in octal, of the unix socket -http listens on.")
+	var dlnaName = flags.String("dlna-name", "", "Name DLNA players show for the proxy. Defaults to the torrent name.")
+	var watchDir = flags.String("watch-dir", "", "Directory to serve the .torrent and .magnet files dropped into, until they're deleted.")
+	var feedInterval = flags.Duration("feed-interval", 15*time.Minute, "How often to poll each -feed.")
+	var feedInclude = flags.String("feed-include", "", "Regular expression the titles of -feed items must match to be served.")
+	var feedExclude = flags.String("feed-exclude", "", "Regular expression the titles of -feed items must not match to be served.")
+	var completeTTL = flags.Duration("complete-ttl", 0, "How long to keep serving each torrent once it's complete, with more than one. 0 to keep them.")
+	var maxActive = flags.Int("max-active", 0, "Most torrents to download at once, with more than one. Others are queued until one completes or is removed. 0 for no limit.")
+	var deleteData = flags.Bool("delete-data", false, "Delete a torrent's files from -datadir when it's removed by -complete-ttl or -watch-dir.")
+	var ephemeral = flags.Bool("ephemeral", false, "Delete every torrent's files from -datadir when it's removed or the server shuts down.")
+	var dataKeyFile = flags.String("data-key-file", "", "File holding 64 hex digits of a key to encrypt the data in -datadir with.")
+	var preallocate = flags.String("preallocate", proxy.PreallocateSparse, `How to lay out files in -datadir: "sparse", or "full" to allocate them up front.`)
+	var offline = flags.Bool("offline", false, "Serve what's already in -datadir from a torrent file, without talking to peers, trackers or the DHT.")
+	var maxTorrentBytes = flags.Int64("max-torrent-bytes", 0, "Largest torrent in bytes to download. 0 for any that fits in -datadir.")
+	flags.Parse(args)
+
+	if flags.NArg() < 1 && len(*bundle) == 0 && len(*watchDir) == 0 && len(feeds) == 0 {
+		flags.Usage()
 		os.Exit(1)
 	}
 
@@ -52,17 +243,145 @@ func main() {
 		}
 	}
 
-	proxy, err := proxy.NewTorrentProxy(&proxy.Config{
-		DHTNodes:       dhtNodes,
-		TorrentURL:     flag.Arg(0),
-		HTTPListenAddr: *httpaddr,
-	})
+	mode, err := strconv.ParseUint(*socketMode, 8, 32)
+	if err != nil {
+		log.Fatalf("Invalid -socket-mode: %s", *socketMode)
+	}
+
+	headers := make(map[string]string)
+	for _, header := range torrentHeaders {
+		parts := strings.SplitN(header, ":", 2)
+		if len(parts) != 2 {
+			log.Fatal(`Invalid -torrent-header, expected "Name: value"`)
+		}
+		headers[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+
+	urls := torrentURLs(flags.Args())
+	torrentURL := ""
+	if len(urls) > 0 {
+		torrentURL = urls[0]
+	}
+
+	config := &proxy.Config{
+		DHTNodes:            dhtNodes,
+		TorrentURL:          torrentURL,
+		TorrentURLHeaders:   headers,
+		TorrentURLProxy:     *torrentProxy,
+		TorrentURLTimeout:   *torrentTimeout,
+		TorrentURLRetries:   *torrentRetries,
+		HTTPListenAddr:      *httpaddr,
+		SocketMode:          os.FileMode(mode),
+		PublicURL:           *publicURL,
+		BasePath:            *basePath,
+		DisableCompression:  *noCompression,
+		TorrentListenAddr:   *peeraddr,
+		DHTListenAddr:       *dhtaddr,
+		PeerTransport:       *transport,
+		Encryption:          *encryption,
+		PeerInterface:       *peerInterface,
+		PeerIPVersion:       *ipVersion,
+		PortForwarding:      *upnp,
+		MaxPeers:            *maxPeers,
+		MaxHalfOpen:         *maxHalfOpen,
+		DataDir:             *datadir,
+		FlatDataDir:         *flatDataDir,
+		BundlePath:          *bundle,
+		ResponseBufferSize:  *bufferSize,
+		DisableHTTP:         *downloadOnly,
+		Stream:              *stream,
+		SkipJunk:            *skipJunk,
+		ServeRateLimit:      *serveRate,
+		MaxStreams:          *maxStreams,
+		MaxStreamsPerClient: *maxClientStreams,
+		DLNA:                *dlna,
+		DLNAFriendlyName:    *dlnaName,
+		WatchDir:            *watchDir,
+		Feeds:               feeds,
+		FeedInterval:        *feedInterval,
+		FeedInclude:         *feedInclude,
+		FeedExclude:         *feedExclude,
+		CompleteTTL:         *completeTTL,
+		MaxActiveTorrents:   *maxActive,
+		DeleteDataOnRemove:  *deleteData,
+		MaxTorrentBytes:     *maxTorrentBytes,
+		EphemeralData:       *ephemeral,
+		DataKey:             readKeyFile(*dataKeyFile),
+		Preallocate:         *preallocate,
+		Offline:             *offline,
+		ReadToken:           os.Getenv("EVAPORATION_READ_TOKEN"),
+		AdminToken:          os.Getenv("EVAPORATION_ADMIN_TOKEN"),
+	}
+
+	// every problem with the flags at once, rather than one per try
+	err = config.Validate()
+	if err != nil {
+		log.Fatalf("Invalid options: %s", err)
+	}
+
+	if flags.NArg() > 1 || len(*watchDir) > 0 || len(feeds) > 0 {
+		serveMany(config, urls)
+		return
+	}
 
+	proxy, err := proxy.NewTorrentProxy(config)
 	if err != nil {
 		log.Fatalf("Unable to start proxy: %s", err)
 	}
 
+	// close the torrent client cleanly so we don't leave a damaged db or partial pieces behind
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		log.Printf("Received %s, shutting down", sig)
+
+		ctx, cancel := context.WithTimeout(context.Background(), *drainTimeout)
+		defer cancel()
+
+		err := proxy.Shutdown(ctx)
+		if err != nil {
+			log.Printf("Unclean shutdown: %s", err)
+			os.Exit(1)
+		}
+
+		os.Exit(0)
+	}()
+
+	if *downloadOnly {
+		download(proxy)
+	}
+
 	log.Printf("Proxy up at: %s", proxy.URL())
 	proxy.Run()
 
 }
+
+// Serve several torrents, and those in -watch-dir and -feed, from one torrent client and HTTP server,
+// and block until it exits.
+func serveMany(config *proxy.Config, urls []string) {
+	if len(config.BundlePath) > 0 || config.DisableHTTP {
+		log.Fatal("-bundle and -download-only take a single url, and no -watch-dir or -feed")
+	}
+
+	config.TorrentURL = ""
+	config.TorrentURLs = urls
+
+	m, err := proxy.NewProxyManager(config)
+	if err != nil {
+		log.Fatalf("Unable to start proxy: %s", err)
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		log.Printf("Received %s, shutting down", sig)
+
+		m.Close()
+		os.Exit(0)
+	}()
+
+	log.Printf("Proxy up at: %s", m.URL())
+	m.Run()
+}
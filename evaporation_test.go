@@ -0,0 +1,41 @@
+package main
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/cnelson/evaporation/proxy"
+)
+
+var _ = Describe("Download progress", func() {
+	It("is done once every file is complete", func() {
+		s := &proxy.TorrentStatus{Status: "ready", Files: []*proxy.TorrentFile{
+			{Path: "movie.mkv", Length: 300, Complete: 1},
+			{Path: "extra.mkv", Length: 100, Complete: 0.5},
+		}}
+
+		bar, done := progressBar(s)
+		Expect(done).To(BeFalse())
+		Expect(bar).To(ContainSubstring("87.5%"))
+
+		s.Files[1].Complete = 1
+		_, done = progressBar(s)
+		Expect(done).To(BeTrue())
+	})
+
+	It("leaves out skipped files, which are never downloaded", func() {
+		s := &proxy.TorrentStatus{Status: "ready", Files: []*proxy.TorrentFile{
+			{Path: "movie.mkv", Length: 300, Complete: 1, Priority: proxy.PriorityNormal},
+			{Path: "sample.mkv", Length: 100, Complete: 0, Priority: proxy.PrioritySkip},
+		}}
+
+		bar, done := progressBar(s)
+		Expect(done).To(BeTrue())
+		Expect(bar).To(ContainSubstring("100.0%"))
+	})
+
+	It("isn't done before the metadata arrives", func() {
+		_, done := progressBar(&proxy.TorrentStatus{Status: "pending"})
+		Expect(done).To(BeFalse())
+	})
+})
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/cnelson/evaporation/proxy"
+)
+
+func init() {
+	commands["add"] = add
+	commands["rm"] = rm
+}
+
+// Send an admin request to a running proxy, exiting with why if it isn't answered with code.
+func adminRequest(method string, url string, body []byte, token string, code int) *http.Response {
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		log.Fatalf("Invalid url: %s", err)
+	}
+	if len(token) > 0 {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/x-bittorrent")
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		log.Fatalf("Unable to reach proxy: %s", err)
+	}
+
+	if resp.StatusCode != code {
+		defer resp.Body.Close()
+
+		var e proxy.ErrorResponse
+		if json.NewDecoder(resp.Body).Decode(&e) == nil && len(e.Message) > 0 {
+			log.Fatalf("Unexpected response from proxy: %s: %s", resp.Status, e.Message)
+		}
+		log.Fatalf("Unexpected response from proxy: %s", resp.Status)
+	}
+
+	return resp
+}
+
+// Add a .torrent file to a running proxy, and print where it's served.
+func add(args []string) {
+	flags := flag.NewFlagSet("add", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Printf("Usage: %s add [OPTIONS] url file\n", os.Args[0])
+		fmt.Println("   url  - The URL of a proxy running more than one torrent, e.g. http://localhost:8080")
+		fmt.Println("   file - A .torrent file to add. - reads it from stdin.")
+
+		fmt.Println("OPTIONS:")
+		flags.PrintDefaults()
+	}
+	var token = flags.String("token", os.Getenv("EVAPORATION_ADMIN_TOKEN"), "Bearer token to change the proxy with. Defaults to $EVAPORATION_ADMIN_TOKEN.")
+	flags.Parse(args)
+
+	if flags.NArg() != 2 {
+		flags.Usage()
+		os.Exit(1)
+	}
+
+	var data []byte
+	var err error
+	if flags.Arg(1) == "-" {
+		data, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		data, err = ioutil.ReadFile(flags.Arg(1))
+	}
+	if err != nil {
+		log.Fatalf("Unable to read torrent file: %s", err)
+	}
+	if len(data) == 0 {
+		log.Fatal("Empty torrent file")
+	}
+
+	resp := adminRequest("POST", strings.TrimSuffix(flags.Arg(0), "/")+"/torrents", data, *token, 201)
+	defer resp.Body.Close()
+
+	var s proxy.TorrentStatus
+	err = json.NewDecoder(resp.Body).Decode(&s)
+	if err != nil {
+		log.Fatalf("Unable to decode status: %s", err)
+	}
+
+	fmt.Printf("Name:   %s\n", s.Name)
+	fmt.Printf("ID:     %s\n", s.Hash)
+	fmt.Printf("URL:    %s\n", resp.Header.Get("Location"))
+}
+
+// Remove a torrent from a running proxy.
+func rm(args []string) {
+	flags := flag.NewFlagSet("rm", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Printf("Usage: %s rm [OPTIONS] url id\n", os.Args[0])
+		fmt.Println("   url - The URL of a proxy running more than one torrent, e.g. http://localhost:8080")
+		fmt.Println("   id  - The infohash or name of the torrent to remove.")
+
+		fmt.Println("OPTIONS:")
+		flags.PrintDefaults()
+	}
+	var token = flags.String("token", os.Getenv("EVAPORATION_ADMIN_TOKEN"), "Bearer token to change the proxy with. Defaults to $EVAPORATION_ADMIN_TOKEN.")
+	var purge = flags.Bool("purge", false, "Delete the torrent's data from the proxy's -datadir too.")
+	flags.Parse(args)
+
+	if flags.NArg() != 2 {
+		flags.Usage()
+		os.Exit(1)
+	}
+
+	u := strings.TrimSuffix(flags.Arg(0), "/") + "/" + flags.Arg(1)
+	if *purge {
+		u += "?purge=true"
+	}
+
+	resp := adminRequest("DELETE", u, nil, *token, 204)
+	resp.Body.Close()
+
+	fmt.Printf("Removed %s\n", flags.Arg(1))
+}
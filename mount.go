@@ -0,0 +1,93 @@
+// +build linux darwin freebsd
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/cnelson/evaporation/proxy"
+
+	"github.com/anacrolix/dht"
+)
+
+func init() {
+	commands["mount"] = mount
+}
+
+// Mount the torrent as a read-only filesystem and block until it's unmounted.
+func mount(args []string) {
+	var dhtNodes multiValue
+
+	flags := flag.NewFlagSet("mount", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Printf("Usage: %s mount [OPTIONS] url mountpoint\n", os.Args[0])
+		fmt.Println("   url        - A magnet url or http url to a .torrent file, or - to read one from stdin.")
+		fmt.Println("   mountpoint - An empty directory to mount the torrent on.")
+
+		fmt.Println("OPTIONS:")
+		flags.PrintDefaults()
+	}
+	flags.Var(&dhtNodes, "dht", "host:port to seed DHT. Can be specified more than once.")
+
+	var peeraddr = flags.String("peer-addr", ":0", "host:port for the torrent client to accept peer connections on.")
+	var dhtaddr = flags.String("dht-addr", "", "host:port for DHT traffic. Defaults to sharing the UDP port of -peer-addr.")
+	var datadir = flags.String("datadir", ".", "Directory in which torrent data will be stored, in a directory per infohash.")
+	var flatDataDir = flags.Bool("flat-datadir", false, "Store torrent files directly in -datadir, not in a directory per infohash, to find data from older versions.")
+	var dataKeyFile = flags.String("data-key-file", "", "File holding 64 hex digits of the key the data in -datadir is encrypted with.")
+	var offline = flags.Bool("offline", false, "Serve what's already in -datadir from a torrent file, without talking to peers, trackers or the DHT.")
+	var stream = flags.Bool("stream", false, "Download files in order from where they are being read, for faster media playback.")
+	var skipJunk = flags.Bool("skip-junk", false, "Don't download samples, proofs, and other obvious extras.")
+	flags.Parse(args)
+
+	if flags.NArg() < 2 {
+		flags.Usage()
+		os.Exit(1)
+	}
+
+	if len(dhtNodes) == 0 {
+		nodes, _ := dht.GlobalBootstrapAddrs()
+		for _, node := range nodes {
+			dhtNodes = append(dhtNodes, node.String())
+		}
+	}
+
+	p, err := proxy.NewTorrentProxy(&proxy.Config{
+		DHTNodes:          dhtNodes,
+		TorrentURL:        torrentURLs(flags.Args()[:1])[0],
+		TorrentListenAddr: *peeraddr,
+		DHTListenAddr:     *dhtaddr,
+		DataDir:           *datadir,
+		FlatDataDir:       *flatDataDir,
+		DataKey:           readKeyFile(*dataKeyFile),
+		Offline:           *offline,
+		DisableHTTP:       true,
+		Stream:            *stream,
+		SkipJunk:          *skipJunk,
+	})
+	if err != nil {
+		log.Fatalf("Unable to start proxy: %s", err)
+	}
+	defer p.Close()
+
+	// unmount on a signal, so the mountpoint isn't left dangling
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		log.Printf("Received %s, unmounting", sig)
+		cancel()
+	}()
+
+	log.Printf("Mounting at: %s", flags.Arg(1))
+	err = p.Mount(ctx, flags.Arg(1))
+	if err != nil {
+		log.Fatalf("Unable to mount: %s", err)
+	}
+}
@@ -0,0 +1,313 @@
+package proxy
+
+import (
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/pprof"
+	"reflect"
+	"strings"
+)
+
+// Replaces the value of Config fields tagged `redact:"true"` in /admin/config responses.
+const redactedValue = "REDACTED"
+
+// Fields of Config that can be changed while the proxy is running.
+// Everything else only takes effect at startup.  FFProbePath is left out as it's run, which
+// would let an admin token run anything on the host.
+var runtimeConfigFields = map[string]bool{
+	"ResponseBufferSize": true,
+	"CoalesceWindow":     true,
+	"MemoryLimit":        true,
+	"Stream":             true,
+}
+
+// Return a copy of config with any secrets replaced by redactedValue.
+func redactConfig(config Config) Config {
+	v := reflect.ValueOf(&config).Elem()
+	t := v.Type()
+
+	for i := 0; i < t.NumField(); i++ {
+		field := v.Field(i)
+		if t.Field(i).Tag.Get("redact") != "true" {
+			continue
+		}
+
+		switch field.Kind() {
+		case reflect.String:
+			if field.Len() > 0 {
+				field.SetString(redactedValue)
+			}
+		case reflect.Map:
+			if field.IsNil() {
+				continue
+			}
+
+			// the keys, like header names, aren't secret, but the map is shared with the original
+			redacted := reflect.MakeMap(field.Type())
+			for _, key := range field.MapKeys() {
+				redacted.SetMapIndex(key, reflect.ValueOf(redactedValue))
+			}
+			field.Set(redacted)
+		case reflect.Slice:
+			if field.IsNil() {
+				continue
+			}
+
+			// how many there are isn't secret, but the slice is shared with the original
+			redacted := reflect.MakeSlice(field.Type(), field.Len(), field.Len())
+			for i := 0; i < field.Len(); i++ {
+				redacted.Index(i).SetString(redactedValue)
+			}
+			field.Set(redacted)
+		}
+	}
+
+	return config
+}
+
+// Return a copy of v that shares no slices or maps with it, so decoding JSON into the copy
+// can't change the original.  Pointers, funcs and interfaces are shared, as JSON leaves them be.
+func deepCopy(v reflect.Value) reflect.Value {
+	switch v.Kind() {
+	case reflect.Slice:
+		if v.IsNil() {
+			return v
+		}
+
+		c := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			c.Index(i).Set(deepCopy(v.Index(i)))
+		}
+		return c
+	case reflect.Map:
+		if v.IsNil() {
+			return v
+		}
+
+		c := reflect.MakeMapWithSize(v.Type(), v.Len())
+		for _, key := range v.MapKeys() {
+			c.SetMapIndex(key, deepCopy(v.MapIndex(key)))
+		}
+		return c
+	case reflect.Struct:
+		c := reflect.New(v.Type()).Elem()
+		c.Set(v)
+		for i := 0; i < v.NumField(); i++ {
+			if c.Field(i).CanSet() {
+				c.Field(i).Set(deepCopy(v.Field(i)))
+			}
+		}
+		return c
+	default:
+		return v
+	}
+}
+
+// Put back secrets in updated that were sent as redactedValue, since they came from a GET.
+func unredactConfig(updated *Config, current *Config) {
+	uv := reflect.ValueOf(updated).Elem()
+	cv := reflect.ValueOf(current).Elem()
+	t := uv.Type()
+
+	for i := 0; i < t.NumField(); i++ {
+		field := uv.Field(i)
+		if t.Field(i).Tag.Get("redact") != "true" {
+			continue
+		}
+
+		switch field.Kind() {
+		case reflect.String:
+			if field.String() == redactedValue {
+				field.Set(cv.Field(i))
+			}
+		case reflect.Map:
+			for _, key := range field.MapKeys() {
+				if field.MapIndex(key).String() == redactedValue {
+					field.SetMapIndex(key, cv.Field(i).MapIndex(key))
+				}
+			}
+		case reflect.Slice:
+			for j := 0; j < field.Len() && j < cv.Field(i).Len(); j++ {
+				if field.Index(j).String() == redactedValue {
+					field.Index(j).Set(cv.Field(i).Index(j))
+				}
+			}
+		}
+	}
+}
+
+// Return the names of the fields that differ between two configs.
+func changedConfigFields(a Config, b Config) (changed []string) {
+	av := reflect.ValueOf(a)
+	bv := reflect.ValueOf(b)
+	t := av.Type()
+
+	for i := 0; i < t.NumField(); i++ {
+		// callbacks can't be compared, and neither they nor anything else left out of JSON can be
+		// set over HTTP
+		if t.Field(i).Type.Kind() == reflect.Func || t.Field(i).Tag.Get("json") == "-" {
+			continue
+		}
+
+		if !reflect.DeepEqual(av.Field(i).Interface(), bv.Field(i).Interface()) {
+			changed = append(changed, t.Field(i).Name)
+		}
+	}
+
+	return
+}
+
+// Serve the effective configuration as JSON.
+//
+// GET returns the configuration, with secrets redacted.
+// PUT accepts a full or partial configuration, and applies it if only runtime configurable fields changed.
+func (p *TorrentProxy) serveAdminConfig(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		p.configLock.RLock()
+		config := redactConfig(*p.config)
+		p.configLock.RUnlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(config)
+
+		log.Printf("%d %s %s", 200, r.Method, r.URL.Path)
+
+	case "PUT":
+		p.configLock.Lock()
+		defer p.configLock.Unlock()
+
+		// start from the current config, so fields that aren't specified are left alone.  JSON
+		// decodes into the slices and maps it finds, so they mustn't be the running config's.
+		updated := deepCopy(reflect.ValueOf(*p.config)).Interface().(Config)
+		err := json.NewDecoder(r.Body).Decode(&updated)
+		if err != nil {
+			p.errlog.Printf("%d %s %s: %s", 400, r.Method, r.URL.Path, err)
+
+			writeError(w, r, 400, fmt.Sprintf("Invalid configuration: %s", err), nil)
+			return
+		}
+
+		unredactConfig(&updated, p.config)
+		applyConfigDefaults(&updated)
+
+		if updated.MemoryLimit < updated.Readahead {
+			p.errlog.Printf("%d %s %s", 400, r.Method, r.URL.Path)
+
+			writeError(w, r, 400, fmt.Sprintf("Invalid configuration: MemoryLimit must be at least Readahead, %d bytes", updated.Readahead), nil)
+			return
+		}
+
+		var fixed []string
+		for _, name := range changedConfigFields(*p.config, updated) {
+			if !runtimeConfigFields[name] {
+				fixed = append(fixed, name)
+			}
+		}
+
+		if len(fixed) > 0 {
+			p.errlog.Printf("%d %s %s", 409, r.Method, r.URL.Path)
+
+			writeError(w, r, 409, fmt.Sprintf("Can not be changed while running: %s", strings.Join(fixed, ", ")), nil)
+			return
+		}
+
+		if updated.CoalesceWindow != p.config.CoalesceWindow {
+			p.coalescer = nil
+			if updated.CoalesceWindow > 0 {
+				p.coalescer = newRangeCoalescer(updated.CoalesceWindow)
+			}
+		}
+
+		if updated.MemoryLimit != p.config.MemoryLimit {
+			p.storage.SetLimit(updated.MemoryLimit)
+		}
+
+		p.config.ResponseBufferSize = updated.ResponseBufferSize
+		p.config.CoalesceWindow = updated.CoalesceWindow
+		p.config.MemoryLimit = updated.MemoryLimit
+		p.config.Stream = updated.Stream
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(redactConfig(*p.config))
+
+		log.Printf("%d %s %s", 200, r.Method, r.URL.Path)
+
+	default:
+		p.errlog.Printf("%d %s %s", 405, r.Method, r.URL.Path)
+
+		w.Header().Set("Allow", "GET, PUT")
+		writeError(w, r, 405, "Method Not Allowed", nil)
+	}
+}
+
+// Serve the admin and debugging endpoints that are enabled, see Config.AdminAPI and Config.Profiling.
+//
+// Returns false if the path isn't one of them.
+func (p *TorrentProxy) serveAdmin(w http.ResponseWriter, r *http.Request) bool {
+	switch {
+	case r.URL.Path == "/admin/config" && p.config.AdminAPI:
+		p.serveAdminConfig(w, r)
+	case r.URL.Path == "/admin/sign" && p.config.AdminAPI:
+		p.serveSign(w, r)
+	case r.URL.Path == "/admin/dht/bootstrap" && p.config.AdminAPI:
+		p.serveDHTBootstrap(w, r)
+	case r.URL.Path == "/debug/readers" && p.config.AdminAPI:
+		p.serveReaders(w, r)
+	case r.URL.Path == "/debug/vars" && p.config.Profiling:
+		expvar.Handler().ServeHTTP(w, r)
+	case strings.HasPrefix(r.URL.Path, "/debug/pprof/") && p.config.Profiling:
+		switch r.URL.Path[len("/debug/pprof/"):] {
+		case "cmdline":
+			pprof.Cmdline(w, r)
+		case "profile":
+			pprof.Profile(w, r)
+		case "symbol":
+			pprof.Symbol(w, r)
+		case "trace":
+			pprof.Trace(w, r)
+		default:
+			// the index, and named profiles like heap and goroutine
+			pprof.Index(w, r)
+		}
+	default:
+		return false
+	}
+
+	return true
+}
+
+// Start a separate HTTP server for the admin and debugging endpoints on Config.AdminListenAddr.
+func (p *TorrentProxy) startAdminServer() (err error) {
+	listener, addr, err := listenHTTP(p.config.AdminListenAddr, p.config.SocketMode)
+	if err != nil {
+		return fmt.Errorf("Unable to listen for admin requests: %s", err)
+	}
+	p.config.AdminListenAddr = addr
+
+	p.adminServer = &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.URL.Path = strings.TrimPrefix(r.URL.Path, apiPrefix)
+
+		if p.serveUnauthorized(w, r, r.URL.Path) {
+			return
+		}
+
+		if !p.serveAdmin(w, r) {
+			p.errlog.Printf("%d %s", 404, r.URL.Path)
+
+			writeError(w, r, 404, "Not Found", nil)
+		}
+	})}
+
+	go func() {
+		err := p.adminServer.Serve(listener)
+		if err != nil && err != http.ErrServerClosed {
+			log.Printf("Admin server stopped: %s", err)
+		}
+	}()
+
+	return
+}
@@ -0,0 +1,49 @@
+package proxy
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Return the path of the file a short alias refers to:
+//
+//   stream - the largest file in the torrent, usually the main video
+//
+//   files/N - the Nth file in the torrent, counting from 0
+//
+//   f/ID - the file with the short link ID, see ShortLink
+//
+// ok is false if path isn't an alias, or refers to a file that doesn't exist.
+func (p *TorrentProxy) aliasPath(path string) (file string, ok bool) {
+	files := p.torrent.Files()
+
+	if path == "stream" {
+		largest := -1
+		for i, f := range files {
+			if largest < 0 || f.Length() > files[largest].Length() {
+				largest = i
+			}
+		}
+
+		if largest < 0 {
+			return
+		}
+
+		return filePath(files[largest]), true
+	}
+
+	if strings.HasPrefix(path, "files/") {
+		i, err := strconv.Atoi(path[len("files/"):])
+		if err != nil || i < 0 || i >= len(files) {
+			return
+		}
+
+		return filePath(files[i]), true
+	}
+
+	if strings.HasPrefix(path, "f/") {
+		return p.shortLinkPath(path[len("f/"):])
+	}
+
+	return
+}
@@ -0,0 +1,92 @@
+package proxy
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// What a request to the JSON API needs to be allowed, see Config.ReadToken and Config.AdminToken.
+const (
+	// File contents and everything else outside the JSON API, which tokens don't apply to.
+	roleNone = iota
+	// Observing: the status, listings of ETags and links, media info and metrics.
+	roleRead
+	// Changing anything, and the admin and debug endpoints, which expose settings and clients.
+	roleAdmin
+)
+
+// Return the role a request for path, as served at its original path, needs.
+func apiRole(r *http.Request, path string) int {
+	if strings.HasPrefix(path, "/admin/") || strings.HasPrefix(path, "/debug/") {
+		return roleAdmin
+	}
+
+	api := path == "/" || path == "/metrics" || path == "/etags" || path == "/files" || path == "/f" || path == "/verify" ||
+		path == "/trackers" || path == "/pieces" || path == "/stats/history" || path == "/metainfo" ||
+		(strings.HasPrefix(path, "/files/") && strings.HasSuffix(path, "/mediainfo"))
+	if !api {
+		return roleNone
+	}
+
+	if r.Method == "GET" || r.Method == "HEAD" {
+		return roleRead
+	}
+
+	return roleAdmin
+}
+
+// Return the bearer token a request was sent with, or "".
+func bearerToken(r *http.Request) string {
+	auth := r.Header.Get("Authorization")
+	if len(auth) < len("Bearer ") || !strings.EqualFold(auth[:len("Bearer ")], "Bearer ") {
+		return ""
+	}
+
+	return strings.TrimSpace(auth[len("Bearer "):])
+}
+
+// Returns true if token is want, taking the same time however much of it matches.
+func tokenMatches(token, want string) bool {
+	return len(want) > 0 && subtle.ConstantTimeCompare([]byte(token), []byte(want)) == 1
+}
+
+// Serve a 401 or 403 unless the request has a token for role.  readToken and adminToken
+// are the tokens the roles need, and a role with no token is open to everyone.
+// Returns true if the request was handled.
+func serveUnauthorized(w http.ResponseWriter, r *http.Request, role int, readToken, adminToken string, logf func(string, ...interface{})) bool {
+	token := bearerToken(r)
+
+	switch role {
+	case roleNone:
+		return false
+	case roleRead:
+		// the admin token can do everything the read token can
+		if len(readToken) == 0 || tokenMatches(token, readToken) || tokenMatches(token, adminToken) {
+			return false
+		}
+	case roleAdmin:
+		if len(adminToken) == 0 || tokenMatches(token, adminToken) {
+			return false
+		}
+
+		if tokenMatches(token, readToken) {
+			logf("%d %s %s: read only token", 403, r.Method, r.URL.Path)
+
+			writeError(w, r, 403, "This token is read only", nil)
+			return true
+		}
+	}
+
+	logf("%d %s %s: missing or invalid token", 401, r.Method, r.URL.Path)
+
+	w.Header().Set("WWW-Authenticate", `Bearer realm="evaporation"`)
+	writeError(w, r, 401, "A valid token is required", nil)
+	return true
+}
+
+// Serve a 401 or 403 unless the request has the token the endpoint at path needs.
+// Returns true if the request was handled.
+func (p *TorrentProxy) serveUnauthorized(w http.ResponseWriter, r *http.Request, path string) bool {
+	return serveUnauthorized(w, r, apiRole(r, path), p.config.ReadToken, p.config.AdminToken, p.errlog.Printf)
+}
@@ -0,0 +1,104 @@
+package proxy
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/anacrolix/torrent"
+)
+
+// Stops file requests from waiting on a swarm that isn't delivering.
+//
+// After threshold reads in a row time out, the breaker opens and requests that need the swarm
+// fail fast for cooldown.  Once that passes, requests try again, and the first to time out opens
+// it again, while the first to succeed closes it.
+type circuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	lock      sync.Mutex
+	failures  int
+	openUntil time.Time
+}
+
+// Create a breaker that opens after threshold timeouts in a row, for cooldown.
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+	}
+}
+
+// Return how long until requests may try the swarm again, or 0 if they may now.
+func (b *circuitBreaker) Open() time.Duration {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	remaining := time.Until(b.openUntil)
+	if remaining < 0 {
+		return 0
+	}
+
+	return remaining
+}
+
+// Record a read that got data from the swarm in time.
+func (b *circuitBreaker) Success() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.failures >= b.threshold {
+		log.Printf("The swarm is responding again, closing the circuit breaker")
+	}
+	b.failures = 0
+}
+
+// Record a read that timed out waiting for the swarm.
+func (b *circuitBreaker) Failure() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.failures++
+	if b.failures < b.threshold {
+		return
+	}
+
+	if b.failures == b.threshold {
+		log.Printf("ALERT: %d reads in a row timed out waiting for the swarm, failing file requests for %s", b.failures, b.cooldown)
+	}
+	b.openUntil = time.Now().Add(b.cooldown)
+}
+
+// Serve a 503 if the breaker is open and file can't be served without the swarm.
+// Returns true if the request was handled.
+func (p *TorrentProxy) serveBreakerOpen(w http.ResponseWriter, r *http.Request, file torrent.File) bool {
+	if p.breaker == nil || r.Method == "HEAD" || p.fileComplete(file) {
+		return false
+	}
+
+	remaining := p.breaker.Open()
+	if remaining == 0 {
+		return false
+	}
+
+	w.Header().Set("Retry-After", fmt.Sprintf("%d", int(math.Ceil(remaining.Seconds()))))
+	p.errlog.Printf("%d %s: circuit breaker open", 503, r.URL.Path)
+
+	writeError(w, r, 503, "The swarm is not responding, try again later", nil)
+	return true
+}
+
+// Returns true if every piece of file has been downloaded.
+func (p *TorrentProxy) fileComplete(file torrent.File) bool {
+	for i, f := range p.torrent.Files() {
+		if filePath(f) == filePath(file) {
+			return p.completion.Fraction(i) == 1
+		}
+	}
+
+	return false
+}
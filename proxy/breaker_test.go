@@ -0,0 +1,59 @@
+package proxy
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("circuitBreaker", func() {
+	var b *circuitBreaker
+
+	BeforeEach(func() {
+		b = newCircuitBreaker(3, time.Minute)
+	})
+
+	It("stays closed until enough reads in a row time out", func() {
+		b.Failure()
+		b.Failure()
+		Expect(b.Open()).To(BeZero())
+
+		b.Failure()
+		Expect(b.Open()).To(BeNumerically("~", time.Minute, time.Second))
+	})
+
+	It("only counts timeouts in a row", func() {
+		b.Failure()
+		b.Failure()
+		b.Success()
+		b.Failure()
+
+		Expect(b.Open()).To(BeZero())
+	})
+
+	It("opens again on the first timeout after cooling down", func() {
+		b.cooldown = 10 * time.Millisecond
+		b.Failure()
+		b.Failure()
+		b.Failure()
+
+		Eventually(b.Open).Should(BeZero())
+
+		b.Failure()
+		Expect(b.Open()).NotTo(BeZero())
+	})
+
+	It("closes once a read succeeds", func() {
+		b.cooldown = 10 * time.Millisecond
+		b.Failure()
+		b.Failure()
+		b.Failure()
+
+		Eventually(b.Open).Should(BeZero())
+
+		b.Success()
+		b.Failure()
+		Expect(b.Open()).To(BeZero())
+	})
+})
@@ -0,0 +1,218 @@
+package proxy
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/metainfo"
+	"github.com/anacrolix/torrent/storage"
+)
+
+// Names of the entries in a bundle archive.
+const (
+	bundleMetainfoName = "metainfo.torrent"
+	bundlePiecesName   = "pieces.json"
+	bundleDataPrefix   = "data/"
+)
+
+// Write a self-contained bundle of the torrent to w.
+//
+// A bundle is a gzipped tar archive containing the metainfo, the list of verified pieces,
+// and the data for every file in the torrent as it currently exists in DataDir.
+// Use Config.BundlePath to start a new instance from it.
+//
+// Returns an error if the torrent metadata has not been loaded yet.
+func (p *TorrentProxy) ExportBundle(w io.Writer) (err error) {
+	if !p.hasStarted() || p.torrent.Info() == nil {
+		return fmt.Errorf("Torrent metadata is not available yet")
+	}
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+
+	// metainfo first so importers can fail fast on a bad bundle
+	var buf bytes.Buffer
+	mi := p.torrent.Metainfo()
+	err = mi.Write(&buf)
+	if err != nil {
+		return
+	}
+	err = writeBundleEntry(tw, bundleMetainfoName, int64(buf.Len()), &buf)
+	if err != nil {
+		return
+	}
+
+	pieces := make([]int, 0)
+	for i := 0; i < p.torrent.NumPieces(); i++ {
+		if p.torrent.PieceState(i).Complete {
+			pieces = append(pieces, i)
+		}
+	}
+
+	buf.Reset()
+	err = json.NewEncoder(&buf).Encode(pieces)
+	if err != nil {
+		return
+	}
+	err = writeBundleEntry(tw, bundlePiecesName, int64(buf.Len()), &buf)
+	if err != nil {
+		return
+	}
+
+	for _, file := range p.torrent.Files() {
+		fh, err := os.Open(filepath.Join(p.dataDir(), file.Path()))
+		if os.IsNotExist(err) {
+			// nothing downloaded for this file yet
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		fi, err := fh.Stat()
+		if err == nil {
+			err = writeBundleEntry(tw, bundleDataPrefix+file.Path(), fi.Size(), fh)
+		}
+		fh.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	err = tw.Close()
+	if err != nil {
+		return
+	}
+
+	return gz.Close()
+}
+
+// Add a single entry of the given size to a tar archive.
+func writeBundleEntry(tw *tar.Writer, name string, size int64, r io.Reader) (err error) {
+	err = tw.WriteHeader(&tar.Header{
+		Name: name,
+		Mode: 0644,
+		Size: size,
+	})
+	if err != nil {
+		return
+	}
+
+	_, err = io.CopyN(tw, r, size)
+	return
+}
+
+// Unpack a bundle created by ExportBundle into dataDir, in the torrent's subdirectory unless flat,
+// and return a TorrentSpec for it.
+//
+// The pieces listed as verified in the bundle are marked complete in the piece completion
+// database so the torrent is ready to serve without being re-hashed.
+// created is the creation date from the bundled torrent file, or the zero time if it has none.
+func torrentSpecFromBundle(bundlePath string, dataDir string, flat bool) (spec *torrent.TorrentSpec, created time.Time, err error) {
+	fh, err := os.Open(bundlePath)
+	if err != nil {
+		return
+	}
+	defer fh.Close()
+
+	gz, err := gzip.NewReader(fh)
+	if err != nil {
+		return spec, created, fmt.Errorf("Not a valid bundle: %s", err)
+	}
+
+	var mi *metainfo.MetaInfo
+	var pieces []int
+
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return spec, created, fmt.Errorf("Not a valid bundle: %s", err)
+		}
+
+		switch {
+		case hdr.Name == bundleMetainfoName:
+			mi, err = metainfo.Load(tr)
+			if err != nil {
+				return spec, created, fmt.Errorf("Not a valid torrent file: %s", err)
+			}
+
+		case hdr.Name == bundlePiecesName:
+			err = json.NewDecoder(tr).Decode(&pieces)
+			if err != nil {
+				return spec, created, fmt.Errorf("Invalid pieces list: %s", err)
+			}
+
+		case strings.HasPrefix(hdr.Name, bundleDataPrefix):
+			// ExportBundle writes the metainfo first, so we know where the torrent's files go
+			if mi == nil {
+				return spec, created, fmt.Errorf("Bundle does not start with %s", bundleMetainfoName)
+			}
+			err = extractBundleData(tr, torrentDataDir(dataDir, flat, mi.HashInfoBytes()), hdr.Name[len(bundleDataPrefix):])
+			if err != nil {
+				return spec, created, err
+			}
+		}
+	}
+
+	if mi == nil {
+		return spec, created, fmt.Errorf("Bundle does not contain %s", bundleMetainfoName)
+	}
+
+	spec = torrent.TorrentSpecFromMetaInfo(mi)
+	if mi.CreationDate > 0 {
+		created = time.Unix(mi.CreationDate, 0)
+	}
+
+	// the client isn't running yet, so we can safely open its completion db
+	pc, err := storage.NewBoltPieceCompletion(dataDir)
+	if err != nil {
+		return
+	}
+	defer pc.Close()
+
+	for _, index := range pieces {
+		err = pc.Set(metainfo.PieceKey{InfoHash: spec.InfoHash, Index: index}, true)
+		if err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// Write a data file from a bundle into dataDir, refusing paths that would escape it.
+func extractBundleData(r io.Reader, dataDir string, name string) (err error) {
+	clean := path.Clean("/" + name)[1:]
+	if len(clean) == 0 || clean != name {
+		return fmt.Errorf("Invalid path in bundle: %s", name)
+	}
+
+	dest := filepath.Join(dataDir, filepath.FromSlash(clean))
+	err = os.MkdirAll(filepath.Dir(dest), 0755)
+	if err != nil {
+		return
+	}
+
+	out, err := os.Create(dest)
+	if err != nil {
+		return
+	}
+	defer out.Close()
+
+	_, err = io.Copy(out, r)
+	return
+}
@@ -0,0 +1,102 @@
+package proxy
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Bundle", func() {
+	var (
+		err     error
+		p       *TorrentProxy
+		tempDir string
+	)
+
+	BeforeEach(func() {
+		os.RemoveAll("testdata/.torrent.bolt.db")
+
+		tempDir, err = ioutil.TempDir("", "evaporation-bundle-test")
+		Expect(err).To(Succeed())
+
+		http.DefaultServeMux = new(http.ServeMux)
+
+		http.HandleFunc("/a-torrent", func(w http.ResponseWriter, r *http.Request) {
+			http.ServeFile(w, r, "testdata/sample.torrent")
+		})
+
+		listener, _ := net.Listen("tcp", "localhost:0")
+		torrentURL := "http://" + listener.Addr().String() + "/a-torrent"
+		go http.Serve(listener, nil)
+
+		p, err = NewTorrentProxy(&Config{
+			TorrentURL:        torrentURL,
+			TorrentListenAddr: "localhost:0",
+			DataDir:           "testdata",
+			FlatDataDir:       true,
+		})
+
+		Expect(err).To(Succeed())
+	})
+
+	AfterEach(func() {
+		if p != nil {
+			p.Close()
+		}
+		os.RemoveAll(tempDir)
+	})
+
+	It("starts a new instance from an exported bundle", func() {
+		// wait for the fixture to be hashed so the pieces are verified
+		Eventually(func() float32 {
+			return p.Status().Files[0].Complete
+		}, 10*time.Second, time.Second).Should(Equal(float32(1)))
+
+		bundlePath := filepath.Join(tempDir, "sample.tar.gz")
+		fh, err := os.Create(bundlePath)
+		Expect(err).To(Succeed())
+
+		err = p.ExportBundle(fh)
+		fh.Close()
+		Expect(err).To(Succeed())
+
+		want := p.Status().Files[0]
+		p.Close()
+
+		dataDir := filepath.Join(tempDir, "data")
+		p, err = NewTorrentProxy(&Config{
+			BundlePath:        bundlePath,
+			TorrentListenAddr: "localhost:0",
+			DataDir:           dataDir,
+		})
+		Expect(err).To(Succeed())
+		Eventually(p.Ready()).Should(BeClosed())
+
+		s := p.Status()
+		Expect(s.Status).To(Equal("ready"))
+		Expect(s.Files[0].Path).To(Equal(want.Path))
+		Expect(s.Files[0].Complete).To(Equal(float32(1)))
+
+		source, _ := ioutil.ReadFile("testdata/" + want.Path)
+		copied, _ := ioutil.ReadFile(filepath.Join(dataDir, s.Hash, want.Path))
+		Expect(copied).To(Equal(source))
+	})
+
+	It("returns an error for a file that is not a bundle", func() {
+		p.Close()
+
+		p, err = NewTorrentProxy(&Config{
+			BundlePath:        "testdata/not-a-torrent.txt",
+			TorrentListenAddr: "localhost:0",
+			DataDir:           tempDir,
+		})
+
+		Expect(err).To(MatchError(ContainSubstring("Invalid bundle")))
+	})
+})
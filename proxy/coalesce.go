@@ -0,0 +1,162 @@
+package proxy
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// How long a client session is remembered after its last read.
+const coalesceSessionTTL = time.Minute
+
+// Tracks the region of a file already prioritized for each client session.
+//
+// Players like ExoPlayer issue many small sequential range requests, each of which gets its own
+// reader.  Without coalescing, every request reprioritizes a tiny region and the scheduler thrashes.
+// Instead we prioritize a window ahead of each session, and only extend it when reads reach its end.
+type rangeCoalescer struct {
+	// How far ahead of a read to prioritize.
+	window int64
+
+	lock     sync.Mutex
+	sessions map[string]*coalescedRange
+}
+
+// A prioritized region of a file.
+type coalescedRange struct {
+	start int64
+	end   int64
+	seen  time.Time
+}
+
+// Create a coalescer that prioritizes window bytes ahead of each read.
+func newRangeCoalescer(window int64) *rangeCoalescer {
+	return &rangeCoalescer{
+		window:   window,
+		sessions: make(map[string]*coalescedRange),
+	}
+}
+
+// Return the session key for a request to a given file.
+//
+// Range requests from the same player frequently arrive on different connections,
+// so we key on the client host rather than the full remote address.
+func coalesceSession(r *http.Request, path string) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	return host + " " + path
+}
+
+// Prioritize the region [off, off+length) of a file of size max for a session.
+//
+// prioritize is only called for the parts of the region not already covered by the session's window.
+func (c *rangeCoalescer) Prioritize(session string, off int64, length int64, max int64, prioritize func(off int64, length int64)) {
+	end := off + length
+	if length < c.window {
+		end = off + c.window
+	}
+	if end > max {
+		end = max
+	}
+
+	now := time.Now()
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	// forget sessions that have gone away
+	for key, r := range c.sessions {
+		if now.Sub(r.seen) > coalesceSessionTTL {
+			delete(c.sessions, key)
+		}
+	}
+
+	r, ok := c.sessions[session]
+	if !ok || off < r.start || off > r.end {
+		// a new session, or a seek outside the current window
+		c.sessions[session] = &coalescedRange{start: off, end: end, seen: now}
+		prioritize(off, end-off)
+		return
+	}
+
+	r.seen = now
+
+	// already covered
+	if off+length <= r.end {
+		return
+	}
+
+	// a sequential read that reached the end of the window, so extend it
+	if end > r.end {
+		prioritize(r.end, end-r.end)
+		r.end = end
+	}
+}
+
+// Shares identical reads that are in flight at the same time, so clients asking for the same
+// range of the same file, like everyone following a shared link, are served by one read of the
+// torrent and prioritize its pieces once.
+type readGroup struct {
+	lock    sync.Mutex
+	flights map[readKey]*readFlight
+}
+
+// Identifies a read by the region of the torrent it covers.
+type readKey struct {
+	offset int64
+	length int64
+}
+
+// A read in flight.  data and err are set before done is closed.
+type readFlight struct {
+	done    chan struct{}
+	waiters int
+	data    []byte
+	err     error
+}
+
+func newReadGroup() *readGroup {
+	return &readGroup{flights: make(map[readKey]*readFlight)}
+}
+
+// Call read for the region key covers, unless the same region is already being read, in which
+// case wait for that read until ctx is done.
+//
+// shared is true if the data came from another read, and is a copy that can be kept.
+func (g *readGroup) Do(ctx context.Context, key readKey, read func() ([]byte, error)) (data []byte, shared bool, err error) {
+	g.lock.Lock()
+	if f, ok := g.flights[key]; ok {
+		f.waiters++
+		g.lock.Unlock()
+
+		select {
+		case <-f.done:
+			return f.data, true, f.err
+		case <-ctx.Done():
+			return nil, true, ctx.Err()
+		}
+	}
+
+	f := &readFlight{done: make(chan struct{})}
+	g.flights[key] = f
+	g.lock.Unlock()
+
+	data, err = read()
+
+	// the caller may reuse its buffer as soon as we return, so waiters get their own copy
+	g.lock.Lock()
+	delete(g.flights, key)
+	if f.waiters > 0 {
+		f.data = append([]byte(nil), data...)
+		f.err = err
+	}
+	g.lock.Unlock()
+	close(f.done)
+
+	return
+}
@@ -0,0 +1,159 @@
+package proxy
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("rangeCoalescer", func() {
+	var (
+		c       *rangeCoalescer
+		regions [][2]int64
+	)
+
+	prioritize := func(off int64, length int64) {
+		regions = append(regions, [2]int64{off, length})
+	}
+
+	BeforeEach(func() {
+		c = newRangeCoalescer(1000)
+		regions = nil
+	})
+
+	It("prioritizes a full window for a new session", func() {
+		c.Prioritize("client", 0, 100, 10000, prioritize)
+
+		Expect(regions).To(Equal([][2]int64{{0, 1000}}))
+	})
+
+	It("does not reprioritize reads inside the window", func() {
+		c.Prioritize("client", 0, 100, 10000, prioritize)
+		c.Prioritize("client", 100, 100, 10000, prioritize)
+		c.Prioritize("client", 200, 100, 10000, prioritize)
+
+		Expect(regions).To(HaveLen(1))
+	})
+
+	It("extends the window when sequential reads reach its end", func() {
+		c.Prioritize("client", 0, 100, 10000, prioritize)
+		c.Prioritize("client", 950, 100, 10000, prioritize)
+
+		Expect(regions).To(Equal([][2]int64{{0, 1000}, {1000, 950}}))
+	})
+
+	It("starts a new window on a seek", func() {
+		c.Prioritize("client", 0, 100, 10000, prioritize)
+		c.Prioritize("client", 5000, 100, 10000, prioritize)
+
+		Expect(regions).To(Equal([][2]int64{{0, 1000}, {5000, 1000}}))
+	})
+
+	It("keeps sessions independent", func() {
+		c.Prioritize("client-a", 0, 100, 10000, prioritize)
+		c.Prioritize("client-b", 0, 100, 10000, prioritize)
+
+		Expect(regions).To(HaveLen(2))
+	})
+
+	It("does not prioritize past the end of the file", func() {
+		c.Prioritize("client", 9900, 50, 10000, prioritize)
+
+		Expect(regions).To(Equal([][2]int64{{9900, 100}}))
+	})
+})
+
+var _ = Describe("readGroup", func() {
+	var (
+		g   *readGroup
+		key readKey
+	)
+
+	BeforeEach(func() {
+		g = newReadGroup()
+		key = readKey{offset: 0, length: 5}
+	})
+
+	// start a read that blocks until release is closed, and wait for it to be in flight
+	lead := func(release chan struct{}, buf []byte) chan []byte {
+		result := make(chan []byte, 1)
+		started := make(chan struct{})
+
+		go func() {
+			data, _, _ := g.Do(context.Background(), key, func() ([]byte, error) {
+				close(started)
+				<-release
+				return buf[:copy(buf, "hello")], nil
+			})
+			result <- data
+		}()
+
+		<-started
+		return result
+	}
+
+	It("shares an identical read that's in flight", func() {
+		release := make(chan struct{})
+		buf := make([]byte, 5)
+		leader := lead(release, buf)
+
+		reads := 0
+		shared := make(chan []byte, 1)
+		go func() {
+			defer GinkgoRecover()
+
+			data, ok, err := g.Do(context.Background(), key, func() ([]byte, error) {
+				reads++
+				return nil, nil
+			})
+			Expect(ok).To(BeTrue())
+			Expect(err).To(Succeed())
+			shared <- data
+		}()
+
+		// wait for the second read to join
+		Eventually(func() int {
+			g.lock.Lock()
+			defer g.lock.Unlock()
+			return g.flights[key].waiters
+		}).Should(Equal(1))
+		close(release)
+
+		Expect(string(<-leader)).To(Equal("hello"))
+		data := <-shared
+		Expect(string(data)).To(Equal("hello"))
+		Expect(reads).To(BeZero())
+
+		// the leader's buffer can be reused without changing what was shared
+		copy(buf, "world")
+		Expect(string(data)).To(Equal("hello"))
+	})
+
+	It("reads again once the read is done", func() {
+		release := make(chan struct{})
+		close(release)
+		<-lead(release, make([]byte, 5))
+
+		_, shared, _ := g.Do(context.Background(), key, func() ([]byte, error) {
+			return nil, nil
+		})
+		Expect(shared).To(BeFalse())
+	})
+
+	It("stops waiting when the context is done", func() {
+		release := make(chan struct{})
+		defer close(release)
+		lead(release, make([]byte, 5))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		data, shared, err := g.Do(ctx, key, func() ([]byte, error) {
+			return nil, nil
+		})
+		Expect(shared).To(BeTrue())
+		Expect(data).To(BeEmpty())
+		Expect(err).To(Equal(context.Canceled))
+	})
+})
@@ -0,0 +1,157 @@
+package proxy
+
+import (
+	"sync"
+
+	"github.com/anacrolix/torrent"
+)
+
+// Tracks how many pieces of each file are complete, so polling Status doesn't have to look at
+// every piece in the torrent.
+type completionCache struct {
+	lock   sync.RWMutex
+	pieces []bool
+	// how many pieces are complete
+	done int
+	// closed and replaced whenever a piece changes, to wake anyone waiting on completion
+	changed chan struct{}
+
+	// the range of pieces, inclusive, each file in the torrent covers.
+	// These never change, so don't need the lock.
+	first []int
+	last  []int
+	// how many of those pieces are complete
+	complete []int
+}
+
+// Create a cache for a torrent that has its metadata, with every piece incomplete.
+func newCompletionCache(t *torrent.Torrent) *completionCache {
+	pieceLength := t.Info().PieceLength
+	files := t.Files()
+
+	c := &completionCache{
+		pieces:   make([]bool, t.NumPieces()),
+		changed:  make(chan struct{}),
+		first:    make([]int, len(files)),
+		last:     make([]int, len(files)),
+		complete: make([]int, len(files)),
+	}
+
+	for i, file := range files {
+		c.first[i] = int(file.Offset() / pieceLength)
+		c.last[i] = int((file.Offset() + file.Length() - 1) / pieceLength)
+
+		// empty files don't cover any pieces
+		if file.Length() == 0 {
+			c.last[i] = c.first[i] - 1
+		}
+	}
+
+	return c
+}
+
+// Record whether a piece is complete.
+func (c *completionCache) Set(piece int, complete bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if piece < 0 || piece >= len(c.pieces) || c.pieces[piece] == complete {
+		return
+	}
+	c.pieces[piece] = complete
+
+	delta := -1
+	if complete {
+		delta = 1
+	}
+	c.done += delta
+
+	for i := range c.complete {
+		if c.covers(i, piece) {
+			c.complete[i] += delta
+		}
+	}
+
+	if c.changed != nil {
+		close(c.changed)
+		c.changed = make(chan struct{})
+	}
+}
+
+// Returns true if every piece is complete, and a channel that's closed when that may have changed.
+func (c *completionCache) Complete() (complete bool, changed <-chan struct{}) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	return c.done == len(c.pieces), c.changed
+}
+
+// Returns true if a piece holds data for a file.
+func (c *completionCache) covers(file int, piece int) bool {
+	return piece >= c.first[file] && piece <= c.last[file]
+}
+
+// Return the fraction of a file's pieces that are complete.
+func (c *completionCache) Fraction(file int) float32 {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	total := c.last[file] - c.first[file] + 1
+	if total <= 0 {
+		return 1
+	}
+
+	return float32(c.complete[file]) / float32(total)
+}
+
+// Return which pieces are complete as a bitfield, the first piece in the high bit of the
+// first byte as in the BitTorrent protocol, and how many are complete.
+func (c *completionCache) Bitfield() (bits []byte, complete int) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	bits = make([]byte, (len(c.pieces)+7)/8)
+	for i, done := range c.pieces {
+		if done {
+			bits[i/8] |= 0x80 >> uint(i%8)
+			complete++
+		}
+	}
+
+	return
+}
+
+// Start keeping a completion cache up to date for a torrent that has its metadata.
+//
+// The cache is updated from piece state notifications until the proxy is closed.
+func (p *TorrentProxy) trackCompletion(t *torrent.Torrent) *completionCache {
+	c := newCompletionCache(t)
+
+	// subscribe before the initial scan, so nothing that changes during it is missed
+	sub := t.SubscribePieceStateChanges()
+
+	for i := range c.pieces {
+		c.Set(i, t.PieceState(i).Complete)
+	}
+
+	go func() {
+		defer sub.Close()
+
+		for {
+			select {
+			case v, ok := <-sub.Values:
+				if !ok {
+					return
+				}
+				change := v.(torrent.PieceStateChange)
+				c.Set(change.Index, change.Complete)
+			case <-t.Closed():
+				return
+			case <-p.closed:
+				return
+			}
+		}
+	}()
+
+	return c
+}
@@ -0,0 +1,61 @@
+package proxy
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Completion cache", func() {
+	var c *completionCache
+
+	BeforeEach(func() {
+		// three files over four pieces, the middle one sharing pieces with both neighbours,
+		// and an empty one at the end
+		c = &completionCache{
+			pieces:   make([]bool, 4),
+			first:    []int{0, 1, 2, 3},
+			last:     []int{1, 2, 3, 2},
+			complete: make([]int, 4),
+		}
+	})
+
+	It("starts with nothing complete", func() {
+		Expect(c.Fraction(0)).To(Equal(float32(0)))
+		Expect(c.Fraction(1)).To(Equal(float32(0)))
+	})
+
+	It("counts a piece towards every file it covers", func() {
+		c.Set(1, true)
+
+		Expect(c.Fraction(0)).To(Equal(float32(0.5)))
+		Expect(c.Fraction(1)).To(Equal(float32(0.5)))
+		Expect(c.Fraction(2)).To(Equal(float32(0)))
+	})
+
+	It("ignores repeated notifications", func() {
+		c.Set(0, true)
+		c.Set(0, true)
+
+		Expect(c.Fraction(0)).To(Equal(float32(0.5)))
+	})
+
+	It("uncounts pieces that become incomplete", func() {
+		c.Set(0, true)
+		c.Set(0, false)
+
+		Expect(c.Fraction(0)).To(Equal(float32(0)))
+	})
+
+	It("reports empty files as complete", func() {
+		Expect(c.Fraction(3)).To(Equal(float32(1)))
+	})
+
+	It("packs complete pieces into a bitfield, first piece first", func() {
+		c.Set(0, true)
+		c.Set(3, true)
+
+		bits, complete := c.Bitfield()
+		Expect(bits).To(Equal([]byte{0x90}))
+		Expect(complete).To(Equal(2))
+	})
+})
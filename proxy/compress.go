@@ -0,0 +1,169 @@
+package proxy
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Responses with a Content-Length under this aren't worth the overhead of compressing.
+const compressMinSize = 1024
+
+// Return true if content of the given Content-Type shrinks when compressed.  Media and archives
+// are compressed already.
+func compressible(contentType string) bool {
+	mediaType := strings.ToLower(strings.TrimSpace(strings.SplitN(contentType, ";", 2)[0]))
+
+	switch {
+	case strings.HasPrefix(mediaType, "text/"):
+		return true
+	case strings.HasSuffix(mediaType, "+json"), strings.HasSuffix(mediaType, "+xml"):
+		return true
+	}
+
+	switch mediaType {
+	case "application/json", "application/javascript", "application/xml", "application/vnd.apple.mpegurl", "application/x-subrip":
+		return true
+	}
+
+	return false
+}
+
+// Return the encoding to compress with for an Accept-Encoding header, gzip or deflate, or "" if
+// the client accepts neither.
+func acceptedEncoding(header string) string {
+	accepted := make(map[string]bool)
+	for _, part := range strings.Split(header, ",") {
+		params := strings.Split(part, ";")
+		coding := strings.ToLower(strings.TrimSpace(params[0]))
+		if len(coding) == 0 {
+			continue
+		}
+
+		q := 1.0
+		for _, param := range params[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				q, _ = strconv.ParseFloat(param[2:], 64)
+			}
+		}
+		accepted[coding] = q > 0
+	}
+
+	for _, coding := range []string{"gzip", "deflate"} {
+		ok, listed := accepted[coding]
+		if !listed {
+			ok = accepted["*"]
+		}
+		if ok {
+			return coding
+		}
+	}
+
+	return ""
+}
+
+// Compresses the body of a response with encoding, if its status and Content-Type make it
+// worthwhile.  Close must be called once the response is written.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+
+	wroteHeader bool
+	// nil unless the response is being compressed
+	compressor interface {
+		io.WriteCloser
+		Flush() error
+	}
+}
+
+func (w *compressWriter) WriteHeader(code int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+
+	h := w.Header()
+	if !compressible(h.Get("Content-Type")) {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+
+	// caches must not hand the compressed body to clients that didn't ask for it
+	h.Add("Vary", "Accept-Encoding")
+
+	// ranges are of the uncompressed content, so only whole responses are compressed
+	length, err := strconv.ParseInt(h.Get("Content-Length"), 10, 64)
+	if code != 200 || len(h.Get("Content-Encoding")) > 0 || (err == nil && length < compressMinSize) {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+
+	h.Set("Content-Encoding", w.encoding)
+	h.Del("Content-Length")
+	// the bytes differ from the uncompressed file's, so the ETag can only be weak
+	if etag := h.Get("ETag"); len(etag) > 0 && !strings.HasPrefix(etag, "W/") {
+		h.Set("ETag", "W/"+etag)
+	}
+
+	if w.encoding == "gzip" {
+		w.compressor = gzip.NewWriter(w.ResponseWriter)
+	} else {
+		w.compressor, _ = flate.NewWriter(w.ResponseWriter, flate.DefaultCompression)
+	}
+
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *compressWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		// like net/http, so we know whether to compress
+		if len(w.Header().Get("Content-Type")) == 0 {
+			w.Header().Set("Content-Type", http.DetectContentType(b))
+		}
+		w.WriteHeader(200)
+	}
+
+	if w.compressor != nil {
+		return w.compressor.Write(b)
+	}
+
+	return w.ResponseWriter.Write(b)
+}
+
+// Send what has been compressed so far.
+func (w *compressWriter) Flush() {
+	if w.compressor != nil {
+		w.compressor.Flush()
+	}
+
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Finish the compressed body, if there is one.
+func (w *compressWriter) Close() error {
+	if w.compressor == nil {
+		return nil
+	}
+
+	return w.compressor.Close()
+}
+
+// Return w wrapped to compress the response, if the client accepts it and it isn't disabled, and
+// a func to call once the response is written.
+func (p *TorrentProxy) compressResponse(w http.ResponseWriter, r *http.Request) (http.ResponseWriter, func() error) {
+	encoding := acceptedEncoding(r.Header.Get("Accept-Encoding"))
+
+	// clients send HEAD for the Content-Length, which compressing would take away
+	if p.config.DisableCompression || r.Method == "HEAD" || len(encoding) == 0 {
+		return w, func() error { return nil }
+	}
+
+	cw := &compressWriter{ResponseWriter: w, encoding: encoding}
+	return cw, cw.Close
+}
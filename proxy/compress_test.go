@@ -0,0 +1,80 @@
+package proxy
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Compression", func() {
+	It("picks gzip, then deflate, from Accept-Encoding", func() {
+		Expect(acceptedEncoding("gzip, deflate, br")).To(Equal("gzip"))
+		Expect(acceptedEncoding("deflate")).To(Equal("deflate"))
+		Expect(acceptedEncoding("gzip;q=0, deflate;q=0.5")).To(Equal("deflate"))
+		Expect(acceptedEncoding("*")).To(Equal("gzip"))
+		Expect(acceptedEncoding("*, gzip;q=0")).To(Equal("deflate"))
+		Expect(acceptedEncoding("identity")).To(BeEmpty())
+		Expect(acceptedEncoding("")).To(BeEmpty())
+	})
+
+	It("only compresses text and JSON", func() {
+		Expect(compressible("application/json")).To(BeTrue())
+		Expect(compressible("text/html; charset=utf-8")).To(BeTrue())
+		Expect(compressible("application/vnd.apple.mpegurl")).To(BeTrue())
+		Expect(compressible("application/problem+json")).To(BeTrue())
+		Expect(compressible("video/mp4")).To(BeFalse())
+		Expect(compressible("image/jpeg")).To(BeFalse())
+		Expect(compressible("application/zip")).To(BeFalse())
+	})
+
+	It("compresses whole responses and weakens their ETag", func() {
+		rec := httptest.NewRecorder()
+		w := &compressWriter{ResponseWriter: rec, encoding: "gzip"}
+
+		body := strings.Repeat("All work and no play. ", 100)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("Content-Length", "2200")
+		w.Header().Set("ETag", `"abc"`)
+		w.WriteHeader(200)
+		w.Write([]byte(body))
+		Expect(w.Close()).To(Succeed())
+
+		Expect(rec.Header().Get("Content-Encoding")).To(Equal("gzip"))
+		Expect(rec.Header().Get("Content-Length")).To(BeEmpty())
+		Expect(rec.Header().Get("ETag")).To(Equal(`W/"abc"`))
+		Expect(rec.Header().Get("Vary")).To(Equal("Accept-Encoding"))
+
+		zr, err := gzip.NewReader(rec.Body)
+		Expect(err).To(Succeed())
+		data, _ := ioutil.ReadAll(zr)
+		Expect(string(data)).To(Equal(body))
+	})
+
+	It("leaves ranges, small responses and media alone", func() {
+		for _, c := range []struct {
+			contentType string
+			length      string
+			code        int
+		}{
+			{"text/plain", "2200", 206},
+			{"text/plain", "100", 200},
+			{"video/mp4", "2200", 200},
+		} {
+			rec := httptest.NewRecorder()
+			w := &compressWriter{ResponseWriter: rec, encoding: "gzip"}
+
+			w.Header().Set("Content-Type", c.contentType)
+			w.Header().Set("Content-Length", c.length)
+			w.WriteHeader(c.code)
+			w.Write([]byte("data"))
+			w.Close()
+
+			Expect(rec.Header().Get("Content-Encoding")).To(BeEmpty())
+			Expect(rec.Body.String()).To(Equal("data"))
+		}
+	})
+})
@@ -0,0 +1,53 @@
+package proxy
+
+import (
+	"mime"
+	"path"
+	"strings"
+)
+
+// Types for extensions the mime package doesn't reliably know, since it depends on the
+// system's mime.types.  Sniffing isn't an option, it would block on the swarm.
+var contentTypes = map[string]string{
+	".avi":  "video/x-msvideo",
+	".flac": "audio/flac",
+	".m4a":  "audio/mp4",
+	".m4v":  "video/x-m4v",
+	".mkv":  "video/x-matroska",
+	".mov":  "video/quicktime",
+	".mp3":  "audio/mpeg",
+	".mp4":  "video/mp4",
+	".mpg":  "video/mpeg",
+	".nfo":  "text/plain; charset=utf-8",
+	".ogg":  "audio/ogg",
+	".srt":  "application/x-subrip",
+	".ts":   "video/mp2t",
+	".vtt":  "text/vtt; charset=utf-8",
+	".webm": "video/webm",
+	".wmv":  "video/x-ms-wmv",
+}
+
+// Return the Content-Type for a file, from Config.ContentTypes, then our own list, then
+// the mime package.  Anything unknown is application/octet-stream.
+func (p *TorrentProxy) contentType(name string) string {
+	ext := strings.ToLower(path.Ext(name))
+
+	if t, ok := p.config.ContentTypes[ext]; ok {
+		return t
+	}
+
+	if t, ok := contentTypes[ext]; ok {
+		return t
+	}
+
+	if t := mime.TypeByExtension(ext); len(t) > 0 {
+		return t
+	}
+
+	return "application/octet-stream"
+}
+
+// Return a Content-Disposition that makes browsers save the file under its own name.
+func attachmentDisposition(name string) string {
+	return mime.FormatMediaType("attachment", map[string]string{"filename": path.Base(name)})
+}
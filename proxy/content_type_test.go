@@ -0,0 +1,37 @@
+package proxy
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Content types", func() {
+	var p *TorrentProxy
+
+	BeforeEach(func() {
+		p = &TorrentProxy{config: &Config{}}
+	})
+
+	It("knows common media types", func() {
+		Expect(p.contentType("Some Show/episode.MKV")).To(Equal("video/x-matroska"))
+		Expect(p.contentType("movie.mp4")).To(Equal("video/mp4"))
+	})
+
+	It("falls back to the mime package", func() {
+		Expect(p.contentType("cover.png")).To(Equal("image/png"))
+	})
+
+	It("doesn't guess unknown types", func() {
+		Expect(p.contentType("archive.r00")).To(Equal("application/octet-stream"))
+	})
+
+	It("prefers configured types", func() {
+		p.config.ContentTypes = map[string]string{".mkv": "video/webm"}
+
+		Expect(p.contentType("episode.mkv")).To(Equal("video/webm"))
+	})
+
+	It("builds attachment dispositions from the file name", func() {
+		Expect(attachmentDisposition("Some Show/episode 1.mkv")).To(Equal(`attachment; filename="episode 1.mkv"`))
+	})
+})
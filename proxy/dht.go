@@ -0,0 +1,285 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/anacrolix/dht"
+	"github.com/anacrolix/dht/krpc"
+)
+
+// Where the DHT routing table is kept in DataDir, so restarts bootstrap from nodes we know
+// answer rather than the global bootstrap servers.
+const dhtNodesFile = ".dht-nodes.json"
+
+// How often the routing table is saved while running, in case we don't get to on Close.
+const dhtNodesSaveInterval = 5 * time.Minute
+
+// The nodes a torrent client's DHT bootstraps from, which can be replaced while it's running.
+type dhtNodeList struct {
+	lock  sync.RWMutex
+	addrs []dht.Addr
+	// the result of the last BootstrapDHT, if there has been one
+	bootstrap *DHTBootstrap
+}
+
+// Create a list of the given nodes.
+func newDHTNodeList(addrs []dht.Addr) *dhtNodeList {
+	return &dhtNodeList{addrs: addrs}
+}
+
+// Return the nodes to bootstrap from.
+func (l *dhtNodeList) Get() []dht.Addr {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+
+	return l.addrs
+}
+
+// Replace the nodes to bootstrap from.
+func (l *dhtNodeList) Set(addrs []dht.Addr) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.addrs = addrs
+}
+
+// Record the result of a bootstrap.
+func (l *dhtNodeList) SetBootstrap(result *DHTBootstrap) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.bootstrap = result
+}
+
+// Return the result of the last bootstrap, or nil if there hasn't been one.
+func (l *dhtNodeList) Bootstrap() *DHTBootstrap {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+
+	if l.bootstrap == nil {
+		return nil
+	}
+
+	copied := *l.bootstrap
+	return &copied
+}
+
+// The health of the DHT, see TorrentStatus.
+type DHTStats struct {
+	// Nodes in the routing table
+	Nodes int `json:"nodes"`
+	// Nodes that have answered recently
+	GoodNodes int `json:"goodNodes"`
+	// Nodes that have stopped answering
+	BadNodes int `json:"badNodes"`
+	// Queries waiting for an answer
+	OutstandingTransactions int `json:"outstandingTransactions"`
+	// Announces of our torrents that nodes have accepted
+	ConfirmedAnnounces int `json:"confirmedAnnounces"`
+	// The last bootstrap through POST /admin/dht/bootstrap, if there has been one
+	LastBootstrap *DHTBootstrap `json:"lastBootstrap,omitempty"`
+}
+
+// Return the health of the DHT, or nil if it's disabled.
+func (p *TorrentProxy) dhtStats() *DHTStats {
+	s := p.client.DHT()
+	if s == nil {
+		return nil
+	}
+
+	stats := s.Stats()
+	result := &DHTStats{
+		Nodes:                   stats.Nodes,
+		GoodNodes:               stats.GoodNodes,
+		BadNodes:                stats.BadNodes,
+		OutstandingTransactions: stats.OutstandingTransactions,
+		ConfirmedAnnounces:      stats.ConfirmedAnnounces,
+	}
+	if p.dhtNodes != nil {
+		result.LastBootstrap = p.dhtNodes.Bootstrap()
+	}
+
+	return result
+}
+
+// Return the nodes saved in dataDir by saveDHTNodes, or none if there aren't any.
+func loadDHTNodes(dataDir string) (addrs []dht.Addr, err error) {
+	path := filepath.Join(dataDir, dhtNodesFile)
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return
+	}
+
+	var nodes []string
+	err = json.Unmarshal(data, &nodes)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid DHT nodes in %s: %s", path, err)
+	}
+
+	for _, node := range nodes {
+		// saved as IPs, so there's nothing to look up
+		addr, err := net.ResolveUDPAddr("udp", node)
+		if err != nil {
+			continue
+		}
+		addrs = append(addrs, dht.NewAddr(addr))
+	}
+
+	return
+}
+
+// Write the nodes of the routing table to dataDir, replacing the old file only once the new one
+// is complete.  An empty table is not saved, so a spell offline doesn't forget every node.
+func saveDHTNodes(s *dht.Server, dataDir string) (err error) {
+	nodes := make([]string, 0)
+	for _, node := range s.Nodes() {
+		nodes = append(nodes, node.Addr.String())
+	}
+
+	if len(nodes) == 0 {
+		return
+	}
+
+	data, err := json.Marshal(nodes)
+	if err != nil {
+		return
+	}
+
+	path := filepath.Join(dataDir, dhtNodesFile)
+	tmp := path + ".tmp"
+	err = ioutil.WriteFile(tmp, data, 0644)
+	if err != nil {
+		return
+	}
+
+	return os.Rename(tmp, path)
+}
+
+// Save the routing table to dataDir every dhtNodesSaveInterval until closed is closed.
+func runDHTNodeSaver(s *dht.Server, dataDir string, closed <-chan struct{}) {
+	ticker := time.NewTicker(dhtNodesSaveInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			err := saveDHTNodes(s, dataDir)
+			if err != nil {
+				log.Printf("Unable to save DHT nodes: %s", err)
+			}
+		case <-closed:
+			return
+		}
+	}
+}
+
+// The result of bootstrapping the DHT again, see POST /admin/dht/bootstrap.
+type DHTBootstrap struct {
+	// How many nodes were contacted
+	AddrsTried int `json:"addrsTried"`
+	// How many of them answered
+	Responses int `json:"responses"`
+	// How many nodes are in the routing table now
+	Nodes int `json:"nodes"`
+	// When the bootstrap finished
+	Finished time.Time `json:"finished"`
+}
+
+// Bootstrap the DHT again, after adding nodes, which are host:port like Config.DHTNodes.
+// The nodes replace the ones bootstrapped from when the routing table is empty.
+//
+// Blocks until the bootstrap is done.
+func (p *TorrentProxy) BootstrapDHT(nodes []string) (result *DHTBootstrap, err error) {
+	if !p.hasStarted() {
+		return nil, fmt.Errorf("Torrent client is still starting")
+	}
+
+	s := p.client.DHT()
+	if s == nil || p.dhtNodes == nil {
+		return nil, fmt.Errorf("DHT is disabled")
+	}
+
+	if len(nodes) > 0 {
+		addrs, err := resolveDHTNodes(nodes, p.config.DNSResolver)
+		if err != nil {
+			return nil, fmt.Errorf("Error resolving DHT node: %s", err)
+		}
+		p.dhtNodes.Set(addrs)
+
+		// pinged, so they join the routing table once they answer
+		for _, addr := range addrs {
+			udp := addr.UDPAddr()
+			err = s.AddNode(krpc.NodeInfo{Addr: krpc.NodeAddr{IP: udp.IP, Port: udp.Port}})
+			if err != nil {
+				log.Printf("Unable to add DHT node %s: %s", addr, err)
+			}
+		}
+	}
+
+	stats, err := s.Bootstrap()
+	if err != nil {
+		return nil, fmt.Errorf("DHT bootstrap failed: %s", err)
+	}
+
+	log.Printf("Bootstrapped DHT: %d of %d nodes answered", stats.NumResponses, stats.NumAddrsTried)
+
+	result = &DHTBootstrap{
+		AddrsTried: int(stats.NumAddrsTried),
+		Responses:  int(stats.NumResponses),
+		Nodes:      s.NumNodes(),
+		Finished:   time.Now().UTC(),
+	}
+	p.dhtNodes.SetBootstrap(result)
+
+	return
+}
+
+// Bootstrap the DHT on POST, with an optional JSON body of {"nodes": ["host:port"]} to add.
+func (p *TorrentProxy) serveDHTBootstrap(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		p.errlog.Printf("%d %s %s", 405, r.Method, r.URL.Path)
+
+		w.Header().Set("Allow", "POST")
+		writeError(w, r, 405, "Method Not Allowed", nil)
+		return
+	}
+
+	var body struct {
+		Nodes []string `json:"nodes"`
+	}
+	if r.ContentLength != 0 {
+		err := json.NewDecoder(r.Body).Decode(&body)
+		if err != nil {
+			p.errlog.Printf("%d %s %s: %s", 400, r.Method, r.URL.Path, err)
+
+			writeError(w, r, 400, fmt.Sprintf("Invalid JSON: %s", err), nil)
+			return
+		}
+	}
+
+	result, err := p.BootstrapDHT(body.Nodes)
+	if err != nil {
+		p.errlog.Printf("%d %s %s: %s", 409, r.Method, r.URL.Path, err)
+
+		writeError(w, r, 409, err.Error(), nil)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+
+	log.Printf("%d %s %s", 200, r.Method, r.URL.Path)
+}
@@ -0,0 +1,98 @@
+package proxy
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+
+	"github.com/anacrolix/torrent"
+)
+
+// Caches SHA-256 digests of fully downloaded files.
+type digestCache struct {
+	lock    sync.Mutex
+	digests map[string][]byte
+	// files we're currently hashing
+	pending map[string]bool
+}
+
+func newDigestCache() *digestCache {
+	return &digestCache{
+		digests: make(map[string][]byte),
+		pending: make(map[string]bool),
+	}
+}
+
+// Returns true if every piece of the file has been downloaded.
+func fileComplete(file torrent.File) bool {
+	for _, state := range file.State() {
+		if !state.PieceState.Complete {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Return the SHA-256 digest of a file, or nil if it isn't known yet.
+//
+// Digests are only computed for complete files, in the background, so the first response
+// for a file never waits on hashing it.
+func (p *TorrentProxy) fileDigest(file torrent.File) []byte {
+	p.digests.lock.Lock()
+	defer p.digests.lock.Unlock()
+
+	if digest, ok := p.digests.digests[file.Path()]; ok {
+		return digest
+	}
+
+	if p.digests.pending[file.Path()] || !fileComplete(file) {
+		return nil
+	}
+
+	// the goroutine mustn't race Close for the torrent
+	t := p.currentTorrent()
+	if t == nil {
+		return nil
+	}
+
+	p.digests.pending[file.Path()] = true
+
+	go func() {
+		trs := newTorrentReadSeeker(t, &file, p.config.Readahead)
+		defer trs.Close()
+
+		h := sha256.New()
+		_, err := io.Copy(h, trs)
+
+		p.digests.lock.Lock()
+		defer p.digests.lock.Unlock()
+
+		delete(p.digests.pending, file.Path())
+		if err != nil {
+			log.Printf("Unable to compute digest of %s: %s", file.Path(), err)
+			return
+		}
+
+		p.digests.digests[file.Path()] = h.Sum(nil)
+	}()
+
+	return nil
+}
+
+// Set the digest headers for a file, if we have its digest.
+func (p *TorrentProxy) setDigestHeaders(w http.ResponseWriter, file torrent.File) {
+	digest := p.fileDigest(file)
+	if digest == nil {
+		return
+	}
+
+	encoded := base64.StdEncoding.EncodeToString(digest)
+
+	// RFC 9530, and the older RFC 3230 header for clients that haven't caught up
+	w.Header().Set("Repr-Digest", "sha-256=:"+encoded+":")
+	w.Header().Set("Digest", "sha-256="+encoded)
+}
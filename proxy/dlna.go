@@ -0,0 +1,631 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/xml"
+	"fmt"
+	"html/template"
+	"log"
+	"net"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Where SSDP searches and announcements are multicast.
+const ssdpAddr = "239.255.255.250:1900"
+
+// How long our announcements are good for.  We announce again at half this.
+const ssdpMaxAge = 30 * time.Minute
+
+// What we call ourselves in SSDP messages.
+const ssdpServer = "evaporation UPnP/1.0 DLNADOC/1.50"
+
+// Sent to players that ask what they can do with a file: byte range seeks, streaming.
+const dlnaContentFeatures = "DLNA.ORG_OP=01;DLNA.ORG_CI=0;DLNA.ORG_FLAGS=01700000000000000000000000000000"
+
+// The device and service types we announce, besides our own uuid.
+var ssdpTypes = []string{
+	"upnp:rootdevice",
+	"urn:schemas-upnp-org:device:MediaServer:1",
+	"urn:schemas-upnp-org:service:ContentDirectory:1",
+	"urn:schemas-upnp-org:service:ConnectionManager:1",
+}
+
+// Derive a UUID for the media server from the torrent, so players see the same device across restarts.
+func dlnaUUID(infohash string) string {
+	h := sha1.Sum([]byte("evaporation " + infohash))
+	return fmt.Sprintf("%x-%x-%x-%x-%x", h[0:4], h[4:6], h[6:8], h[8:10], h[10:16])
+}
+
+// Return the UPnP class of a file with the given Content-Type, or "" if it isn't media.
+func dlnaClass(contentType string) string {
+	switch {
+	case strings.HasPrefix(contentType, "video/"):
+		return "object.item.videoItem"
+	case strings.HasPrefix(contentType, "audio/"):
+		return "object.item.audioItem.musicTrack"
+	case strings.HasPrefix(contentType, "image/"):
+		return "object.item.imageItem.photo"
+	}
+
+	return ""
+}
+
+// Return the ST of an SSDP search, or false if data isn't one.
+func parseSSDPSearch(data []byte) (st string, ok bool) {
+	req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(data)))
+	if err != nil {
+		return
+	}
+
+	if req.Method != "M-SEARCH" || req.Header.Get("Man") != `"ssdp:discover"` {
+		return
+	}
+
+	st = req.Header.Get("St")
+	return st, len(st) > 0
+}
+
+// Return the name players show for the media server.
+func (p *TorrentProxy) dlnaName() string {
+	if len(p.config.DLNAFriendlyName) > 0 {
+		return p.config.DLNAFriendlyName
+	}
+
+	return p.torrent.Name()
+}
+
+// Return the URL of our device description, as reached from local.
+//
+// If the HTTP server listens on every interface, local is the address of the interface the
+// player is on.
+func (p *TorrentProxy) dlnaLocation(local net.IP) string {
+	host, port, _ := net.SplitHostPort(p.config.HTTPListenAddr)
+	if ip := net.ParseIP(host); len(host) == 0 || (ip != nil && ip.IsUnspecified()) {
+		host = local.String()
+	}
+
+	return "http://" + net.JoinHostPort(host, port) + "/dlna/device.xml"
+}
+
+// Send an SSDP message to addr, filling in LOCATION for the interface it goes out on.
+func (p *TorrentProxy) sendSSDP(addr *net.UDPAddr, format string, args ...interface{}) (err error) {
+	conn, err := net.DialUDP("udp4", nil, addr)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	location := p.dlnaLocation(conn.LocalAddr().(*net.UDPAddr).IP)
+	msg := strings.Replace(fmt.Sprintf(format, args...), "{location}", location, 1)
+
+	_, err = conn.Write([]byte(msg))
+	return
+}
+
+// Return the targets we answer to, mapped to the USN we announce for each.
+func (p *TorrentProxy) ssdpTargets() map[string]string {
+	uuid := "uuid:" + dlnaUUID(p.torrent.InfoHash().HexString())
+
+	targets := map[string]string{uuid: uuid}
+	for _, t := range ssdpTypes {
+		targets[t] = uuid + "::" + t
+	}
+
+	return targets
+}
+
+// Multicast that we're alive, or with byebye that we're going away, for each of targets.
+func (p *TorrentProxy) notifySSDP(group *net.UDPAddr, targets map[string]string, nts string) {
+	for nt, usn := range targets {
+		err := p.sendSSDP(group, "NOTIFY * HTTP/1.1\r\nHOST: %s\r\nCACHE-CONTROL: max-age=%d\r\nLOCATION: {location}\r\n"+
+			"NT: %s\r\nNTS: %s\r\nSERVER: %s\r\nUSN: %s\r\n\r\n", ssdpAddr, int(ssdpMaxAge.Seconds()), nt, nts, ssdpServer, usn)
+		if err != nil {
+			p.errlog.Printf("Unable to send SSDP %s: %s", nts, err)
+			return
+		}
+	}
+}
+
+// Announce the media server on the LAN and answer searches for it, until the proxy is closed.
+//
+// Blocks until the torrent metadata is available, since that's what there is to browse.
+func (p *TorrentProxy) runDLNA() {
+	select {
+	case <-p.Ready():
+	case <-p.closed:
+		return
+	}
+
+	if host, _, _ := net.SplitHostPort(p.config.HTTPListenAddr); net.ParseIP(host) != nil && net.ParseIP(host).IsLoopback() {
+		log.Printf("DLNA is enabled, but players on the LAN can't reach the HTTP server on %s", p.config.HTTPListenAddr)
+	}
+
+	// Close clears the torrent, and the byebye is sent after it does
+	p.startLock.Lock()
+	if p.torrent == nil {
+		p.startLock.Unlock()
+		return
+	}
+	targets := p.ssdpTargets()
+	p.startLock.Unlock()
+
+	group, _ := net.ResolveUDPAddr("udp4", ssdpAddr)
+	conn, err := net.ListenMulticastUDP("udp4", nil, group)
+	if err != nil {
+		p.errlog.Printf("Unable to listen for SSDP searches: %s", err)
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(ssdpMaxAge / 2)
+		defer ticker.Stop()
+
+		p.notifySSDP(group, targets, "ssdp:alive")
+		for {
+			select {
+			case <-ticker.C:
+				p.notifySSDP(group, targets, "ssdp:alive")
+			case <-p.closed:
+				p.notifySSDP(group, targets, "ssdp:byebye")
+				conn.Close()
+				return
+			}
+		}
+	}()
+
+	buf := make([]byte, 2048)
+	for {
+		n, from, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			// closed
+			return
+		}
+
+		st, ok := parseSSDPSearch(buf[:n])
+		if !ok {
+			continue
+		}
+
+		for target, usn := range targets {
+			if st != "ssdp:all" && st != target {
+				continue
+			}
+
+			err := p.sendSSDP(from, "HTTP/1.1 200 OK\r\nCACHE-CONTROL: max-age=%d\r\nDATE: %s\r\nEXT:\r\nLOCATION: {location}\r\n"+
+				"SERVER: %s\r\nST: %s\r\nUSN: %s\r\n\r\n", int(ssdpMaxAge.Seconds()), time.Now().UTC().Format(http.TimeFormat), ssdpServer, target, usn)
+			if err != nil {
+				p.errlog.Printf("Unable to answer SSDP search from %s: %s", from, err)
+			}
+		}
+	}
+}
+
+// The UPnP device description.
+var dlnaDeviceTemplate = template.Must(template.New("device").Parse(`<?xml version="1.0" encoding="utf-8"?>
+<root xmlns="urn:schemas-upnp-org:device-1-0" xmlns:dlna="urn:schemas-dlna-org:device-1-0">
+<specVersion><major>1</major><minor>0</minor></specVersion>
+<device>
+<deviceType>urn:schemas-upnp-org:device:MediaServer:1</deviceType>
+<friendlyName>{{.Name}}</friendlyName>
+<manufacturer>evaporation</manufacturer>
+<modelName>evaporation</modelName>
+<UDN>uuid:{{.UUID}}</UDN>
+<dlna:X_DLNADOC>DMS-1.50</dlna:X_DLNADOC>
+<serviceList>
+<service>
+<serviceType>urn:schemas-upnp-org:service:ContentDirectory:1</serviceType>
+<serviceId>urn:upnp-org:serviceId:ContentDirectory</serviceId>
+<SCPDURL>{{.Base}}/dlna/ContentDirectory.xml</SCPDURL>
+<controlURL>{{.Base}}/dlna/control/ContentDirectory</controlURL>
+<eventSubURL>{{.Base}}/dlna/events/ContentDirectory</eventSubURL>
+</service>
+<service>
+<serviceType>urn:schemas-upnp-org:service:ConnectionManager:1</serviceType>
+<serviceId>urn:upnp-org:serviceId:ConnectionManager</serviceId>
+<SCPDURL>{{.Base}}/dlna/ConnectionManager.xml</SCPDURL>
+<controlURL>{{.Base}}/dlna/control/ConnectionManager</controlURL>
+<eventSubURL>{{.Base}}/dlna/events/ConnectionManager</eventSubURL>
+</service>
+</serviceList>
+</device>
+</root>
+`))
+
+// The parts of the ContentDirectory service description players look at.
+const dlnaContentDirectorySCPD = `<?xml version="1.0" encoding="utf-8"?>
+<scpd xmlns="urn:schemas-upnp-org:service-1-0">
+<specVersion><major>1</major><minor>0</minor></specVersion>
+<actionList>
+<action><name>Browse</name><argumentList>
+<argument><name>ObjectID</name><direction>in</direction><relatedStateVariable>A_ARG_TYPE_ObjectID</relatedStateVariable></argument>
+<argument><name>BrowseFlag</name><direction>in</direction><relatedStateVariable>A_ARG_TYPE_BrowseFlag</relatedStateVariable></argument>
+<argument><name>Filter</name><direction>in</direction><relatedStateVariable>A_ARG_TYPE_Filter</relatedStateVariable></argument>
+<argument><name>StartingIndex</name><direction>in</direction><relatedStateVariable>A_ARG_TYPE_Index</relatedStateVariable></argument>
+<argument><name>RequestedCount</name><direction>in</direction><relatedStateVariable>A_ARG_TYPE_Count</relatedStateVariable></argument>
+<argument><name>SortCriteria</name><direction>in</direction><relatedStateVariable>A_ARG_TYPE_SortCriteria</relatedStateVariable></argument>
+<argument><name>Result</name><direction>out</direction><relatedStateVariable>A_ARG_TYPE_Result</relatedStateVariable></argument>
+<argument><name>NumberReturned</name><direction>out</direction><relatedStateVariable>A_ARG_TYPE_Count</relatedStateVariable></argument>
+<argument><name>TotalMatches</name><direction>out</direction><relatedStateVariable>A_ARG_TYPE_Count</relatedStateVariable></argument>
+<argument><name>UpdateID</name><direction>out</direction><relatedStateVariable>A_ARG_TYPE_UpdateID</relatedStateVariable></argument>
+</argumentList></action>
+<action><name>GetSearchCapabilities</name><argumentList>
+<argument><name>SearchCaps</name><direction>out</direction><relatedStateVariable>SearchCapabilities</relatedStateVariable></argument>
+</argumentList></action>
+<action><name>GetSortCapabilities</name><argumentList>
+<argument><name>SortCaps</name><direction>out</direction><relatedStateVariable>SortCapabilities</relatedStateVariable></argument>
+</argumentList></action>
+<action><name>GetSystemUpdateID</name><argumentList>
+<argument><name>Id</name><direction>out</direction><relatedStateVariable>SystemUpdateID</relatedStateVariable></argument>
+</argumentList></action>
+</actionList>
+<serviceStateTable>
+<stateVariable sendEvents="no"><name>A_ARG_TYPE_ObjectID</name><dataType>string</dataType></stateVariable>
+<stateVariable sendEvents="no"><name>A_ARG_TYPE_Result</name><dataType>string</dataType></stateVariable>
+<stateVariable sendEvents="no"><name>A_ARG_TYPE_BrowseFlag</name><dataType>string</dataType>
+<allowedValueList><allowedValue>BrowseMetadata</allowedValue><allowedValue>BrowseDirectChildren</allowedValue></allowedValueList></stateVariable>
+<stateVariable sendEvents="no"><name>A_ARG_TYPE_Filter</name><dataType>string</dataType></stateVariable>
+<stateVariable sendEvents="no"><name>A_ARG_TYPE_SortCriteria</name><dataType>string</dataType></stateVariable>
+<stateVariable sendEvents="no"><name>A_ARG_TYPE_Index</name><dataType>ui4</dataType></stateVariable>
+<stateVariable sendEvents="no"><name>A_ARG_TYPE_Count</name><dataType>ui4</dataType></stateVariable>
+<stateVariable sendEvents="no"><name>A_ARG_TYPE_UpdateID</name><dataType>ui4</dataType></stateVariable>
+<stateVariable sendEvents="no"><name>SearchCapabilities</name><dataType>string</dataType></stateVariable>
+<stateVariable sendEvents="no"><name>SortCapabilities</name><dataType>string</dataType></stateVariable>
+<stateVariable sendEvents="yes"><name>SystemUpdateID</name><dataType>ui4</dataType></stateVariable>
+</serviceStateTable>
+</scpd>
+`
+
+// The parts of the ConnectionManager service description players look at.
+const dlnaConnectionManagerSCPD = `<?xml version="1.0" encoding="utf-8"?>
+<scpd xmlns="urn:schemas-upnp-org:service-1-0">
+<specVersion><major>1</major><minor>0</minor></specVersion>
+<actionList>
+<action><name>GetProtocolInfo</name><argumentList>
+<argument><name>Source</name><direction>out</direction><relatedStateVariable>SourceProtocolInfo</relatedStateVariable></argument>
+<argument><name>Sink</name><direction>out</direction><relatedStateVariable>SinkProtocolInfo</relatedStateVariable></argument>
+</argumentList></action>
+<action><name>GetCurrentConnectionIDs</name><argumentList>
+<argument><name>ConnectionIDs</name><direction>out</direction><relatedStateVariable>CurrentConnectionIDs</relatedStateVariable></argument>
+</argumentList></action>
+</actionList>
+<serviceStateTable>
+<stateVariable sendEvents="yes"><name>SourceProtocolInfo</name><dataType>string</dataType></stateVariable>
+<stateVariable sendEvents="yes"><name>SinkProtocolInfo</name><dataType>string</dataType></stateVariable>
+<stateVariable sendEvents="yes"><name>CurrentConnectionIDs</name><dataType>string</dataType></stateVariable>
+</serviceStateTable>
+</scpd>
+`
+
+// A DIDL-Lite document, the listing returned by Browse.
+type didlLite struct {
+	XMLName    xml.Name        `xml:"urn:schemas-upnp-org:metadata-1-0/DIDL-Lite/ DIDL-Lite"`
+	DC         string          `xml:"xmlns:dc,attr"`
+	UPnP       string          `xml:"xmlns:upnp,attr"`
+	Containers []didlContainer `xml:"container"`
+	Items      []didlItem      `xml:"item"`
+}
+
+// A directory in a DIDL-Lite listing.
+type didlContainer struct {
+	ID         string `xml:"id,attr"`
+	ParentID   string `xml:"parentID,attr"`
+	Restricted int    `xml:"restricted,attr"`
+	ChildCount int    `xml:"childCount,attr"`
+	Title      string `xml:"dc:title"`
+	Class      string `xml:"upnp:class"`
+}
+
+// A file in a DIDL-Lite listing.
+type didlItem struct {
+	ID         string  `xml:"id,attr"`
+	ParentID   string  `xml:"parentID,attr"`
+	Restricted int     `xml:"restricted,attr"`
+	Title      string  `xml:"dc:title"`
+	Class      string  `xml:"upnp:class"`
+	Res        didlRes `xml:"res"`
+}
+
+// Where and how a player can fetch a file in a DIDL-Lite listing.
+type didlRes struct {
+	ProtocolInfo string `xml:"protocolInfo,attr"`
+	Size         int64  `xml:"size,attr"`
+	URL          string `xml:",chardata"`
+}
+
+// An error for a SOAP action, as a UPnP error code.
+type upnpError struct {
+	Code        int
+	Description string
+}
+
+func (e *upnpError) Error() string {
+	return fmt.Sprintf("UPnP error %d: %s", e.Code, e.Description)
+}
+
+// The object ID of the root of the content directory.  Everything else is identified by its path.
+const dlnaRootID = "0"
+
+// Return the ID of the directory holding the object with id.
+func dlnaParentID(id string) string {
+	if id == dlnaRootID {
+		return "-1"
+	}
+
+	if dir := path.Dir(id); dir != "." {
+		return dir
+	}
+
+	return dlnaRootID
+}
+
+// Return the paths of the files in the torrent players can do something with.
+func (p *TorrentProxy) dlnaPaths() (paths []string) {
+	for _, file := range p.torrent.Files() {
+		if dlnaClass(p.contentType(filePath(file))) != "" {
+			paths = append(paths, filePath(file))
+		}
+	}
+
+	return
+}
+
+// Return the query string players need to fetch the file at name, if URLs are signed.
+func (p *TorrentProxy) dlnaQuery(name string) string {
+	query := p.signedQuery(name, time.Now().Add(dlnaSignedLinkTTL))
+	if len(query) == 0 {
+		return ""
+	}
+
+	return "?" + query
+}
+
+// Describe the file at name for a DIDL-Lite listing.  base is the URL players reach us at.
+func (p *TorrentProxy) dlnaItem(base string, name string, length int64) didlItem {
+	contentType := p.contentType(name)
+
+	return didlItem{
+		ID:       name,
+		ParentID: dlnaParentID(name),
+		Title:    path.Base(name),
+		Class:    dlnaClass(contentType),
+		Res: didlRes{
+			ProtocolInfo: "http-get:*:" + strings.SplitN(contentType, ";", 2)[0] + ":" + dlnaContentFeatures,
+			Size:         length,
+			URL:          base + fileLink(name) + p.dlnaQuery(name),
+		},
+	}
+}
+
+// Describe the directory id, whose contents are entries, for a DIDL-Lite listing.
+func (p *TorrentProxy) dlnaContainer(id string, entries map[string]bool) didlContainer {
+	title := path.Base(id)
+	if id == dlnaRootID {
+		title = p.dlnaName()
+	}
+
+	return didlContainer{
+		ID:         id,
+		ParentID:   dlnaParentID(id),
+		ChildCount: len(entries),
+		Title:      title,
+		Class:      "object.container.storageFolder",
+	}
+}
+
+// Implement the ContentDirectory Browse action for the object id.
+//
+// With BrowseDirectChildren, up to count of its children starting at start are listed, or
+// all of them if count is 0.  Returns how many were listed and how many there are.
+func (p *TorrentProxy) dlnaBrowse(base string, id string, flag string, start int, count int) (didl *didlLite, returned int, total int, err error) {
+	didl = &didlLite{
+		DC:   "http://purl.org/dc/elements/1.1/",
+		UPnP: "urn:schemas-upnp-org:metadata-1-0/upnp/",
+	}
+
+	paths := p.dlnaPaths()
+
+	dir := ""
+	if id != dlnaRootID {
+		if file, ok := p.findFile(id); ok && dlnaClass(p.contentType(id)) != "" {
+			if flag != "BrowseMetadata" {
+				return didl, 0, 0, &upnpError{710, "No such container"}
+			}
+
+			didl.Items = append(didl.Items, p.dlnaItem(base, id, file.Length()))
+			return didl, 1, 1, nil
+		}
+
+		dir = id + "/"
+	}
+
+	entries := dirEntries(paths, dir)
+	if len(entries) == 0 && id != dlnaRootID {
+		return didl, 0, 0, &upnpError{701, "No such object"}
+	}
+
+	switch flag {
+	case "BrowseMetadata":
+		didl.Containers = append(didl.Containers, p.dlnaContainer(id, entries))
+		return didl, 1, 1, nil
+	case "BrowseDirectChildren":
+	default:
+		return didl, 0, 0, &upnpError{402, "Invalid args"}
+	}
+
+	names := make([]string, 0, len(entries))
+	for name := range entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	total = len(names)
+	if start > total {
+		start = total
+	}
+	names = names[start:]
+	if count > 0 && count < len(names) {
+		names = names[:count]
+	}
+
+	for _, name := range names {
+		if entries[name] {
+			didl.Containers = append(didl.Containers, p.dlnaContainer(dir+name, dirEntries(paths, dir+name+"/")))
+			continue
+		}
+
+		file, _ := p.findFile(dir + name)
+		didl.Items = append(didl.Items, p.dlnaItem(base, dir+name, file.Length()))
+	}
+
+	return didl, len(names), total, nil
+}
+
+// A SOAP request, with the arguments of whatever action it holds.
+type soapEnvelope struct {
+	Body struct {
+		Action struct {
+			Args []struct {
+				XMLName xml.Name
+				Value   string `xml:",chardata"`
+			} `xml:",any"`
+		} `xml:",any"`
+	} `xml:"Body"`
+}
+
+// Respond to a SOAP action.  args are name, value pairs, and values are escaped here.
+func writeSOAP(w http.ResponseWriter, service string, action string, args ...string) {
+	var body bytes.Buffer
+	for i := 0; i+1 < len(args); i += 2 {
+		fmt.Fprintf(&body, "<%s>", args[i])
+		xml.EscapeText(&body, []byte(args[i+1]))
+		fmt.Fprintf(&body, "</%s>", args[i])
+	}
+
+	w.Header().Set("Content-Type", `text/xml; charset="utf-8"`)
+	fmt.Fprintf(w, `<?xml version="1.0" encoding="utf-8"?>`+"\n"+
+		`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">`+
+		`<s:Body><u:%sResponse xmlns:u="%s">%s</u:%sResponse></s:Body></s:Envelope>`, action, service, body.String(), action)
+}
+
+// Respond to a SOAP action that failed.
+func writeSOAPFault(w http.ResponseWriter, err *upnpError) {
+	w.Header().Set("Content-Type", `text/xml; charset="utf-8"`)
+	w.WriteHeader(500)
+
+	var description bytes.Buffer
+	xml.EscapeText(&description, []byte(err.Description))
+
+	fmt.Fprintf(w, `<?xml version="1.0" encoding="utf-8"?>`+"\n"+
+		`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">`+
+		`<s:Body><s:Fault><faultcode>s:Client</faultcode><faultstring>UPnPError</faultstring><detail>`+
+		`<UPnPError xmlns="urn:schemas-upnp-org:control-1-0"><errorCode>%d</errorCode><errorDescription>%s</errorDescription></UPnPError>`+
+		`</detail></s:Fault></s:Body></s:Envelope>`, err.Code, description.String())
+}
+
+// Handle a SOAP action for the ContentDirectory or ConnectionManager service.
+func (p *TorrentProxy) serveDLNAControl(w http.ResponseWriter, r *http.Request, base string) {
+	// e.g. "urn:schemas-upnp-org:service:ContentDirectory:1#Browse", quotes included
+	soapAction := strings.SplitN(strings.Trim(r.Header.Get("SOAPAction"), `"`), "#", 2)
+	if len(soapAction) != 2 {
+		writeSOAPFault(w, &upnpError{401, "Invalid action"})
+		p.errlog.Printf("%d %s", 500, r.URL.Path)
+		return
+	}
+	service, action := soapAction[0], soapAction[1]
+
+	envelope := &soapEnvelope{}
+	err := xml.NewDecoder(r.Body).Decode(envelope)
+	if err != nil {
+		writeSOAPFault(w, &upnpError{402, "Invalid args"})
+		p.errlog.Printf("%d %s: %s", 500, r.URL.Path, err)
+		return
+	}
+
+	args := make(map[string]string)
+	for _, arg := range envelope.Body.Action.Args {
+		args[arg.XMLName.Local] = arg.Value
+	}
+
+	switch service + "#" + action {
+	case "urn:schemas-upnp-org:service:ContentDirectory:1#Browse":
+		start, _ := strconv.Atoi(args["StartingIndex"])
+		count, _ := strconv.Atoi(args["RequestedCount"])
+
+		didl, returned, total, err := p.dlnaBrowse(base, args["ObjectID"], args["BrowseFlag"], start, count)
+		if err != nil {
+			writeSOAPFault(w, err.(*upnpError))
+			p.errlog.Printf("%d %s: %s", 500, r.URL.Path, err)
+			return
+		}
+
+		result, _ := xml.Marshal(didl)
+		writeSOAP(w, service, action, "Result", string(result), "NumberReturned", strconv.Itoa(returned),
+			"TotalMatches", strconv.Itoa(total), "UpdateID", "0")
+	case "urn:schemas-upnp-org:service:ContentDirectory:1#GetSystemUpdateID":
+		// the torrent never changes
+		writeSOAP(w, service, action, "Id", "0")
+	case "urn:schemas-upnp-org:service:ContentDirectory:1#GetSearchCapabilities":
+		writeSOAP(w, service, action, "SearchCaps", "")
+	case "urn:schemas-upnp-org:service:ContentDirectory:1#GetSortCapabilities":
+		writeSOAP(w, service, action, "SortCaps", "")
+	case "urn:schemas-upnp-org:service:ConnectionManager:1#GetProtocolInfo":
+		types := make(map[string]bool)
+		for _, name := range p.dlnaPaths() {
+			types["http-get:*:"+strings.SplitN(p.contentType(name), ";", 2)[0]+":*"] = true
+		}
+		source := make([]string, 0, len(types))
+		for t := range types {
+			source = append(source, t)
+		}
+		sort.Strings(source)
+
+		writeSOAP(w, service, action, "Source", strings.Join(source, ","), "Sink", "")
+	case "urn:schemas-upnp-org:service:ConnectionManager:1#GetCurrentConnectionIDs":
+		writeSOAP(w, service, action, "ConnectionIDs", "0")
+	default:
+		writeSOAPFault(w, &upnpError{401, "Invalid action"})
+		p.errlog.Printf("%d %s: %s", 500, r.URL.Path, action)
+		return
+	}
+
+	log.Printf("%d %s %s", 200, r.URL.Path, action)
+}
+
+// Serve the UPnP device description, service descriptions, and control URLs under /dlna/.
+//
+// Returns false if the path isn't one of ours.
+func (p *TorrentProxy) serveDLNA(w http.ResponseWriter, r *http.Request) bool {
+	// behind a ProxyManager our prefix has been stripped from the path, but not from RequestURI
+	base := "http://" + r.Host + strings.TrimSuffix(strings.SplitN(r.RequestURI, "?", 2)[0], r.URL.Path)
+
+	switch r.URL.Path {
+	case "/dlna/device.xml":
+		w.Header().Set("Content-Type", `text/xml; charset="utf-8"`)
+		dlnaDeviceTemplate.Execute(w, map[string]string{
+			"Name": p.dlnaName(),
+			"UUID": dlnaUUID(p.torrent.InfoHash().HexString()),
+			"Base": base,
+		})
+	case "/dlna/ContentDirectory.xml":
+		w.Header().Set("Content-Type", `text/xml; charset="utf-8"`)
+		fmt.Fprint(w, dlnaContentDirectorySCPD)
+	case "/dlna/ConnectionManager.xml":
+		w.Header().Set("Content-Type", `text/xml; charset="utf-8"`)
+		fmt.Fprint(w, dlnaConnectionManagerSCPD)
+	case "/dlna/control/ContentDirectory", "/dlna/control/ConnectionManager":
+		p.serveDLNAControl(w, r, base)
+		return true
+	default:
+		return false
+	}
+
+	log.Printf("%d %s", 200, r.URL.Path)
+	return true
+}
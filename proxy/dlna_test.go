@@ -0,0 +1,49 @@
+package proxy
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("DLNA", func() {
+	It("derives a stable UUID from the infohash", func() {
+		uuid := dlnaUUID("adecafcafeadecafcafeadecafcafeadecafcafe")
+
+		Expect(uuid).To(MatchRegexp(`^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`))
+		Expect(dlnaUUID("adecafcafeadecafcafeadecafcafeadecafcafe")).To(Equal(uuid))
+		Expect(dlnaUUID("cafecafecafecafecafecafecafecafecafecafe")).NotTo(Equal(uuid))
+	})
+
+	It("classifies media by Content-Type", func() {
+		Expect(dlnaClass("video/x-matroska")).To(Equal("object.item.videoItem"))
+		Expect(dlnaClass("audio/flac")).To(Equal("object.item.audioItem.musicTrack"))
+		Expect(dlnaClass("image/jpeg")).To(Equal("object.item.imageItem.photo"))
+		Expect(dlnaClass("text/plain; charset=utf-8")).To(BeEmpty())
+	})
+
+	It("finds the parent of objects", func() {
+		Expect(dlnaParentID(dlnaRootID)).To(Equal("-1"))
+		Expect(dlnaParentID("movie.mkv")).To(Equal(dlnaRootID))
+		Expect(dlnaParentID("Show/Season 1/ep1.mkv")).To(Equal("Show/Season 1"))
+	})
+
+	Describe("Parsing SSDP searches", func() {
+		It("returns the search target", func() {
+			st, ok := parseSSDPSearch([]byte("M-SEARCH * HTTP/1.1\r\nHOST: 239.255.255.250:1900\r\nMAN: \"ssdp:discover\"\r\nMX: 2\r\nST: urn:schemas-upnp-org:device:MediaServer:1\r\n\r\n"))
+
+			Expect(ok).To(BeTrue())
+			Expect(st).To(Equal("urn:schemas-upnp-org:device:MediaServer:1"))
+		})
+
+		It("ignores announcements and garbage", func() {
+			for _, msg := range []string{
+				"NOTIFY * HTTP/1.1\r\nHOST: 239.255.255.250:1900\r\nNT: upnp:rootdevice\r\nNTS: ssdp:alive\r\n\r\n",
+				"M-SEARCH * HTTP/1.1\r\nHOST: 239.255.255.250:1900\r\nST: ssdp:all\r\n\r\n",
+				"not http at all",
+			} {
+				_, ok := parseSSDPSearch([]byte(msg))
+				Expect(ok).To(BeFalse(), msg)
+			}
+		})
+	})
+})
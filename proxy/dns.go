@@ -0,0 +1,99 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+// How long to wait for the resolver when looking up a DHT node.
+const dnsTimeout = 10 * time.Second
+
+// Resolve a host:port to a UDP address with resolver, like net.ResolveUDPAddr does with the system's.
+func resolveUDPAddr(resolver *net.Resolver, hostport string) (addr *net.UDPAddr, err error) {
+	host, port, err := net.SplitHostPort(hostport)
+	if err != nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dnsTimeout)
+	defer cancel()
+
+	portnum, err := resolver.LookupPort(ctx, "udp", port)
+	if err != nil {
+		return
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		return &net.UDPAddr{IP: ip, Port: portnum}, nil
+	}
+
+	ips, err := resolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return
+	}
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("No addresses for %s", host)
+	}
+
+	return &net.UDPAddr{IP: ips[0].IP, Port: portnum, Zone: ips[0].Zone}, nil
+}
+
+// Return a dial function that looks up hostnames with resolver, and tries each address in turn.
+func resolvingDialer(resolver *net.Resolver) func(ctx context.Context, network string, addr string) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+
+	return func(ctx context.Context, network string, addr string) (conn net.Conn, err error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return
+		}
+
+		if net.ParseIP(host) != nil {
+			return dialer.DialContext(ctx, network, addr)
+		}
+
+		ips, err := resolver.LookupIPAddr(ctx, host)
+		if err != nil {
+			return
+		}
+
+		err = fmt.Errorf("No addresses for %s", host)
+		for _, ip := range ips {
+			conn, err = dialer.DialContext(ctx, network, net.JoinHostPort(ip.String(), port))
+			if err == nil {
+				return
+			}
+		}
+
+		return
+	}
+}
+
+// Return a transport like http.DefaultTransport, that looks up hostnames with resolver if it's set.
+func newHTTPTransport(resolver *net.Resolver) *http.Transport {
+	dial := (&net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}).DialContext
+	if resolver != nil {
+		dial = resolvingDialer(resolver)
+	}
+
+	return &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dial,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+}
+
+// Return an HTTP client that looks up hostnames with resolver, or the default client if it's nil.
+func newHTTPClient(resolver *net.Resolver) *http.Client {
+	if resolver == nil {
+		return http.DefaultClient
+	}
+
+	return &http.Client{Transport: newHTTPTransport(resolver)}
+}
@@ -0,0 +1,162 @@
+package proxy
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/anacrolix/torrent/metainfo"
+	"github.com/anacrolix/torrent/storage"
+)
+
+// Where a digest of Config.DataKey is kept in DataDir, so starting with a different key fails
+// rather than serving garbage from pieces recorded as complete.
+const dataKeyCheckFile = ".data-key-check"
+
+// Parse Config.DataKey, 64 hex digits for an AES-256 key.
+func parseDataKey(dataKey string) (key []byte, err error) {
+	key, err = hex.DecodeString(dataKey)
+	if err != nil || len(key) != 32 {
+		return nil, fmt.Errorf("Invalid DataKey, expected 64 hex digits")
+	}
+
+	return
+}
+
+// Return a keyed digest of key, which can be stored without giving the key away.
+func dataKeyDigest(key []byte) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("evaporation data key check"))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Make sure dataDir was encrypted with key, if it was encrypted before, and record key's
+// digest if it wasn't.
+func checkDataKey(dataDir string, key []byte) (err error) {
+	path := filepath.Join(dataDir, dataKeyCheckFile)
+	digest := dataKeyDigest(key)
+
+	saved, err := ioutil.ReadFile(path)
+	if err == nil {
+		if !hmac.Equal(saved, []byte(digest)) {
+			return fmt.Errorf("DataKey doesn't match the key DataDir was encrypted with")
+		}
+		return nil
+	}
+	if !os.IsNotExist(err) {
+		return
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return
+	}
+
+	return ioutil.WriteFile(path, []byte(digest), 0600)
+}
+
+// Make sure dataDir wasn't encrypted, for starting without a DataKey, which would serve the
+// encrypted pieces as they are.
+func checkNoDataKey(dataDir string) error {
+	_, err := os.Stat(filepath.Join(dataDir, dataKeyCheckFile))
+	if err == nil {
+		return fmt.Errorf("DataDir is encrypted, so DataKey must be specified")
+	}
+	if !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+// Wraps disk storage so piece data is encrypted with AES-256 in CTR mode before it's written,
+// and decrypted as it's read.  CTR keeps every byte where it was, so the files are the size
+// the torrent says and any range can be read on its own.
+//
+// Each torrent gets a key of its own, derived from the DataKey and its infohash, and the
+// counter is the block's offset in the torrent, so no two torrents or blocks share a keystream.
+type encryptedStorage struct {
+	storage.ClientImpl
+	key []byte
+}
+
+// Create a storage that encrypts what it writes to disk with key.
+func newEncryptedStorage(disk storage.ClientImpl, key []byte) *encryptedStorage {
+	return &encryptedStorage{
+		ClientImpl: disk,
+		key:        key,
+	}
+}
+
+func (s *encryptedStorage) OpenTorrent(info *metainfo.Info, infoHash metainfo.Hash) (storage.TorrentImpl, error) {
+	t, err := s.ClientImpl.OpenTorrent(info, infoHash)
+	if err != nil {
+		return nil, err
+	}
+
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write(infoHash[:])
+	block, err := aes.NewCipher(mac.Sum(nil))
+	if err != nil {
+		t.Close()
+		return nil, err
+	}
+
+	return &encryptedTorrent{TorrentImpl: t, block: block}, nil
+}
+
+// A torrent in encrypted storage.
+type encryptedTorrent struct {
+	storage.TorrentImpl
+	block cipher.Block
+}
+
+func (t *encryptedTorrent) Piece(p metainfo.Piece) storage.PieceImpl {
+	return &encryptedPiece{
+		PieceImpl: t.TorrentImpl.Piece(p),
+		block:     t.block,
+		offset:    p.Offset(),
+	}
+}
+
+// A piece whose data is encrypted on disk.
+type encryptedPiece struct {
+	storage.PieceImpl
+	block cipher.Block
+	// where the piece starts in the torrent
+	offset int64
+}
+
+// XOR b with the keystream for the bytes at offset in the torrent, which both encrypts and decrypts.
+func xorKeyStream(block cipher.Block, b []byte, offset int64) {
+	iv := make([]byte, aes.BlockSize)
+	binary.BigEndian.PutUint64(iv[8:], uint64(offset/aes.BlockSize))
+
+	stream := cipher.NewCTR(block, iv)
+	// skip to where offset is in its block
+	skip := make([]byte, offset%aes.BlockSize)
+	stream.XORKeyStream(skip, skip)
+	stream.XORKeyStream(b, b)
+}
+
+func (p *encryptedPiece) ReadAt(b []byte, off int64) (n int, err error) {
+	n, err = p.PieceImpl.ReadAt(b, off)
+	xorKeyStream(p.block, b[:n], p.offset+off)
+	return
+}
+
+func (p *encryptedPiece) WriteAt(b []byte, off int64) (n int, err error) {
+	// b belongs to the caller, so it mustn't be changed
+	encrypted := make([]byte, len(b))
+	copy(encrypted, b)
+	xorKeyStream(p.block, encrypted, p.offset+off)
+
+	return p.PieceImpl.WriteAt(encrypted, off)
+}
@@ -0,0 +1,105 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/aes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/anacrolix/torrent/metainfo"
+	"github.com/anacrolix/torrent/storage"
+)
+
+var _ = Describe("Encryption", func() {
+	var (
+		dir string
+		key []byte
+	)
+
+	BeforeEach(func() {
+		dir, _ = ioutil.TempDir("", "evaporation-encryption")
+
+		var err error
+		key, err = parseDataKey(strings.Repeat("ab", 32))
+		Expect(err).To(Succeed())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("decrypts any range of what it encrypted", func() {
+		block, _ := aes.NewCipher(key)
+		plain := []byte("the quick brown fox jumps over the lazy dog, twice over")
+
+		encrypted := append([]byte(nil), plain...)
+		xorKeyStream(block, encrypted, 1000)
+		Expect(encrypted).NotTo(Equal(plain))
+
+		part := append([]byte(nil), encrypted[7:30]...)
+		xorKeyStream(block, part, 1007)
+		Expect(part).To(Equal(plain[7:30]))
+	})
+
+	It("stores pieces encrypted, and reads them back decrypted", func() {
+		mi, err := metainfo.LoadFromFile("testdata/sample.torrent")
+		Expect(err).To(Succeed())
+		info, err := mi.UnmarshalInfo()
+		Expect(err).To(Succeed())
+
+		s := newEncryptedStorage(storage.NewFile(dir), key)
+		t, err := s.OpenTorrent(&info, mi.HashInfoBytes())
+		Expect(err).To(Succeed())
+		defer t.Close()
+
+		data := bytes.Repeat([]byte("x"), 100)
+		piece := t.Piece(info.Piece(0))
+		_, err = piece.WriteAt(data, 10)
+		Expect(err).To(Succeed())
+
+		read := make([]byte, len(data))
+		_, err = piece.ReadAt(read, 10)
+		Expect(err).To(Succeed())
+		Expect(read).To(Equal(data))
+
+		written := 0
+		filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+			if err == nil && fi.Mode().IsRegular() && !strings.HasPrefix(fi.Name(), ".") {
+				raw, _ := ioutil.ReadFile(path)
+				Expect(raw).NotTo(ContainSubstring(string(data)))
+				written++
+			}
+			return nil
+		})
+		Expect(written).NotTo(BeZero())
+	})
+
+	It("refuses a DataDir encrypted with another key", func() {
+		Expect(checkDataKey(dir, key)).To(Succeed())
+		Expect(checkDataKey(dir, key)).To(Succeed())
+
+		other, _ := parseDataKey(strings.Repeat("cd", 32))
+		Expect(checkDataKey(dir, other)).To(MatchError(ContainSubstring("doesn't match")))
+	})
+
+	It("refuses an encrypted DataDir without a key", func() {
+		Expect(checkNoDataKey(dir)).To(Succeed())
+		Expect(checkDataKey(dir, key)).To(Succeed())
+
+		_, err := newDiskStorage(&Config{DataDir: dir})
+		Expect(err).To(MatchError(ContainSubstring("DataKey must be specified")))
+
+		_, err = newDiskStorage(&Config{DataDir: dir, DataKey: strings.Repeat("ab", 32)})
+		Expect(err).To(Succeed())
+	})
+
+	It("rejects keys that aren't 64 hex digits", func() {
+		_, err := parseDataKey("secret")
+		Expect(err).To(MatchError(ContainSubstring("Invalid DataKey")))
+	})
+})
@@ -0,0 +1,41 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// The body of every error response, unless the client is a browser.
+type ErrorResponse struct {
+	// The HTTP status code
+	Code int `json:"code"`
+	// What went wrong
+	Message string `json:"message"`
+	// More about what went wrong, if there's anything useful to say, e.g. the TorrentStatus
+	// while the metadata is pending
+	Details interface{} `json:"details,omitempty"`
+}
+
+// Return true if the client would rather read errors than parse them, i.e. it's a browser.
+func prefersText(r *http.Request) bool {
+	accept := r.Header.Get("Accept")
+	return strings.Contains(accept, "text/html") && !strings.Contains(accept, "application/json")
+}
+
+// Respond with an ErrorResponse, or as plain text if the client prefers it.
+func writeError(w http.ResponseWriter, r *http.Request, code int, message string, details interface{}) {
+	if prefersText(r) {
+		http.Error(w, message, code)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(&ErrorResponse{
+		Code:    code,
+		Message: message,
+		Details: details,
+	})
+}
@@ -0,0 +1,105 @@
+package proxy
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/anacrolix/torrent"
+)
+
+// A file's public URL and ETag, for CDN pre-warm and purge tooling.
+type FileETag struct {
+	// The path to the file
+	Path string `json:"path"`
+	// The URL the file is served from
+	URL string `json:"url"`
+	// The ETag sent with the file
+	ETag string `json:"etag"`
+	// True if every piece of the file has been downloaded
+	Complete bool `json:"complete"`
+}
+
+// Return the ETag for a file.
+//
+// A torrent's content can never change without its infohash changing, so a hash of the
+// infohash and path is a strong validator that every proxy for the torrent agrees on.
+func (p *TorrentProxy) fileETag(file torrent.File) string {
+	h := sha1.New()
+	h.Write(p.torrent.InfoHash().Bytes())
+	h.Write([]byte(filePath(file)))
+
+	return `"` + hex.EncodeToString(h.Sum(nil)) + `"`
+}
+
+// Return the Last-Modified time for files in the torrent.
+//
+// This is the torrent's creation date, or the zero time, which ServeContent ignores, if the
+// torrent doesn't have one, as with magnet links.
+func (p *TorrentProxy) modTime() time.Time {
+	return p.created
+}
+
+// Return the URL of the HTTP server serving the proxy, which is a ProxyManager's if it has one.
+func (p *TorrentProxy) serverURL() string {
+	return strings.TrimSuffix(p.URL(), p.prefix)
+}
+
+// Return the base URL clients reach the HTTP server serving the proxy at, without a trailing /.
+func (p *TorrentProxy) publicURL() string {
+	base := p.config.PublicURL
+	if len(base) == 0 {
+		base = p.serverURL()
+	}
+
+	return strings.TrimSuffix(base, "/")
+}
+
+// Return the full URL clients reach route at, an escaped path like "/f/id", under basePath.
+// Every absolute link the proxy hands out is built here.
+func (p *TorrentProxy) absoluteURL(route string) string {
+	return p.publicURL() + p.basePath() + route
+}
+
+// Return the public URL for a file.
+func (p *TorrentProxy) fileURL(file torrent.File) string {
+	return p.absoluteURL(fileLink(filePath(file)))
+}
+
+// Serve the URL and ETag of every file as JSON.
+//
+// ?prefix= limits the list to files whose path starts with it, and ?complete=true to files
+// that are fully downloaded.
+func (p *TorrentProxy) serveETags(w http.ResponseWriter, r *http.Request) {
+	prefix := r.URL.Query().Get("prefix")
+	completeOnly := r.URL.Query().Get("complete") == "true"
+
+	etags := make([]*FileETag, 0)
+
+	for _, file := range p.torrent.Files() {
+		if !strings.HasPrefix(filePath(file), prefix) {
+			continue
+		}
+
+		complete := fileComplete(file)
+		if completeOnly && !complete {
+			continue
+		}
+
+		etags = append(etags, &FileETag{
+			Path:     filePath(file),
+			URL:      p.fileURL(file),
+			ETag:     p.fileETag(file),
+			Complete: complete,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(etags)
+
+	log.Printf("%d %s", 200, r.URL.Path)
+}
@@ -20,3 +20,35 @@ func ExampleNewTorrentProxy() {
 	// Blocks forever
 	p.Run()
 }
+
+func ExampleNewTorrentProxyWithOptions() {
+	p, err := proxy.NewTorrentProxyWithOptions(
+		"magnet:?xt=urn:btih:adecafcafeadecafcafeadecafcafeadecafcafe",
+		proxy.WithDataDir("/var/lib/evaporation"),
+		proxy.WithHTTPListenAddr(":8080"),
+		proxy.WithStream(),
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer p.Close()
+
+	// Blocks forever
+	p.Run()
+}
+
+func ExampleConfig_Validate() {
+	config := &proxy.Config{
+		TorrentURL:     "magnet:?xt=urn:btih:adecafcafeadecafcafeadecafcafeadecafcafe",
+		HTTPListenAddr: "localhost:99999",
+		PeerTransport:  "carrier-pigeon",
+	}
+
+	// reports both problems at once
+	err := config.Validate()
+	if errs, ok := err.(proxy.ConfigErrors); ok {
+		for _, e := range errs {
+			log.Printf("%s: %s", e.Field, e.Err)
+		}
+	}
+}
@@ -0,0 +1,18 @@
+// +build linux
+
+package proxy
+
+import (
+	"os"
+	"syscall"
+)
+
+// Allocate disk for the first length bytes of f, growing it to length if it's shorter.
+// What's already in f is kept.
+func allocate(f *os.File, length int64) error {
+	if length == 0 {
+		return nil
+	}
+
+	return syscall.Fallocate(int(f.Fd()), 0, 0, length)
+}
@@ -0,0 +1,45 @@
+// +build !linux
+
+package proxy
+
+import (
+	"io"
+	"os"
+)
+
+// Allocate disk for the first length bytes of f, growing it to length if it's shorter.
+// What's already in f is kept.
+//
+// There's no fallocate on this platform, so the end of the file is filled with zeros, which
+// is slower but leaves the same file behind.
+func allocate(f *os.File, length int64) (err error) {
+	info, err := f.Stat()
+	if err != nil {
+		return
+	}
+
+	if info.Size() >= length {
+		return
+	}
+
+	_, err = f.Seek(info.Size(), io.SeekStart)
+	if err != nil {
+		return
+	}
+
+	zeros := make([]byte, 1<<20)
+	for remaining := length - info.Size(); remaining > 0; {
+		n := int64(len(zeros))
+		if remaining < n {
+			n = remaining
+		}
+
+		_, err = f.Write(zeros[:n])
+		if err != nil {
+			return
+		}
+		remaining -= n
+	}
+
+	return
+}
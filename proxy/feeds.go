@@ -0,0 +1,308 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Where the items already added from Feeds are kept in DataDir, so restarts don't add them again.
+const feedsSeenFile = ".feeds-seen.json"
+
+// The largest feed, in bytes, that will be read.
+const maxFeedSize = 8 << 20
+
+// The most fetching a feed can take, if TorrentURLTimeout doesn't say.
+const feedTimeout = 30 * time.Second
+
+// An item of a feed that links to a torrent.
+type feedItem struct {
+	// the guid or id of the item, or its torrent URL if it has neither
+	id    string
+	title string
+	// an http(s) URL to a .torrent file, or a magnet URL
+	url string
+}
+
+// The parts of RSS 2.0 and Atom feeds we need.  Neither's root element is named, so both decode.
+type feedDocument struct {
+	Items []struct {
+		Title      string `xml:"title"`
+		Link       string `xml:"link"`
+		GUID       string `xml:"guid"`
+		Enclosures []struct {
+			URL  string `xml:"url,attr"`
+			Type string `xml:"type,attr"`
+		} `xml:"enclosure"`
+	} `xml:"channel>item"`
+	Entries []struct {
+		Title string `xml:"title"`
+		ID    string `xml:"id"`
+		Links []struct {
+			Href string `xml:"href,attr"`
+			Rel  string `xml:"rel,attr"`
+			Type string `xml:"type,attr"`
+		} `xml:"link"`
+	} `xml:"entry"`
+}
+
+// Returns true if a link from a feed is to a torrent, by its type or the look of it.
+func isTorrentLink(link string, contentType string) bool {
+	if contentType == "application/x-bittorrent" || strings.HasPrefix(link, "magnet:") {
+		return true
+	}
+
+	u, err := url.Parse(link)
+	return err == nil && strings.HasSuffix(strings.ToLower(u.Path), ".torrent")
+}
+
+// Return the items of an RSS or Atom feed that link to torrents, resolving relative links against base.
+func parseFeed(r io.Reader, base *url.URL) (items []feedItem, err error) {
+	var doc feedDocument
+	err = xml.NewDecoder(r).Decode(&doc)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid feed: %s", err)
+	}
+
+	add := func(id string, title string, link string) {
+		u, err := base.Parse(strings.TrimSpace(link))
+		// a feed has no business pointing us at local files
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "magnet") {
+			return
+		}
+
+		if len(id) == 0 {
+			id = u.String()
+		}
+		items = append(items, feedItem{id: strings.TrimSpace(id), title: strings.TrimSpace(title), url: u.String()})
+	}
+
+	for _, item := range doc.Items {
+		link := ""
+		for _, enclosure := range item.Enclosures {
+			if isTorrentLink(enclosure.URL, enclosure.Type) {
+				link = enclosure.URL
+				break
+			}
+		}
+		if len(link) == 0 && isTorrentLink(item.Link, "") {
+			link = item.Link
+		}
+
+		if len(link) > 0 {
+			add(item.GUID, item.Title, link)
+		}
+	}
+
+	for _, entry := range doc.Entries {
+		link := ""
+		for _, l := range entry.Links {
+			if isTorrentLink(l.Href, l.Type) {
+				link = l.Href
+				// an enclosure is what's meant to be downloaded, so it beats any other link
+				if l.Rel == "enclosure" {
+					break
+				}
+			}
+		}
+
+		if len(link) > 0 {
+			add(entry.ID, entry.Title, link)
+		}
+	}
+
+	return
+}
+
+// Returns true if an item's title passes Config.FeedInclude and Config.FeedExclude.
+func feedFilter(title string, include *regexp.Regexp, exclude *regexp.Regexp) bool {
+	if include != nil && !include.MatchString(title) {
+		return false
+	}
+
+	return exclude == nil || !exclude.MatchString(title)
+}
+
+// Compile Config.FeedInclude and Config.FeedExclude, either of which may be empty for no filter.
+func compileFeedFilters(config *Config) (include *regexp.Regexp, exclude *regexp.Regexp, err error) {
+	if len(config.FeedInclude) > 0 {
+		include, err = regexp.Compile(config.FeedInclude)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Invalid FeedInclude: %s", err)
+		}
+	}
+
+	if len(config.FeedExclude) > 0 {
+		exclude, err = regexp.Compile(config.FeedExclude)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Invalid FeedExclude: %s", err)
+		}
+	}
+
+	return
+}
+
+// Fetch a feed and return the items that link to torrents.
+func fetchFeed(feedURL string, client *http.Client, header http.Header) (items []feedItem, err error) {
+	base, err := url.Parse(feedURL)
+	if err != nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), feedTimeout)
+	defer cancel()
+
+	req, err := http.NewRequest("GET", feedURL, nil)
+	if err != nil {
+		return
+	}
+	for name := range header {
+		req.Header.Set(name, header.Get(name))
+	}
+
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("Feed responded %d", resp.StatusCode)
+	}
+
+	return parseFeed(io.LimitReader(resp.Body, maxFeedSize), base)
+}
+
+// Return the ids of the feed items added before, from dataDir.
+func loadFeedsSeen(dataDir string) (seen map[string]bool, err error) {
+	seen = make(map[string]bool)
+
+	data, err := ioutil.ReadFile(filepath.Join(dataDir, feedsSeenFile))
+	if os.IsNotExist(err) {
+		return seen, nil
+	}
+	if err != nil {
+		return
+	}
+
+	var ids []string
+	err = json.Unmarshal(data, &ids)
+	for _, id := range ids {
+		seen[id] = true
+	}
+
+	return
+}
+
+// Write the ids of the feed items added to dataDir, replacing the old file only once the new one is complete.
+func saveFeedsSeen(seen map[string]bool, dataDir string) (err error) {
+	ids := make([]string, 0, len(seen))
+	for id := range seen {
+		ids = append(ids, id)
+	}
+
+	data, err := json.Marshal(ids)
+	if err != nil {
+		return
+	}
+
+	path := filepath.Join(dataDir, feedsSeenFile)
+	tmp := path + ".tmp"
+	err = ioutil.WriteFile(tmp, data, 0644)
+	if err != nil {
+		return
+	}
+
+	return os.Rename(tmp, path)
+}
+
+// Poll Config.Feeds every FeedInterval, adding the torrents of new items that pass the filters,
+// until the manager is closed.
+func (m *ProxyManager) runFeeds(include *regexp.Regexp, exclude *regexp.Regexp) {
+	seen, err := loadFeedsSeen(m.config.DataDir)
+	if err != nil {
+		log.Printf("Ignoring the feed items added before: %s", err)
+	}
+
+	ticker := time.NewTicker(m.config.FeedInterval)
+	defer ticker.Stop()
+
+	for {
+		seen = m.pollFeeds(seen, include, exclude)
+
+		select {
+		case <-ticker.C:
+		case <-m.closed:
+			return
+		}
+	}
+}
+
+// Fetch every feed once, adding the torrents of items that haven't been seen and pass the
+// filters.  Returns the items seen now, which drops those that are gone from the feeds.
+func (m *ProxyManager) pollFeeds(seen map[string]bool, include *regexp.Regexp, exclude *regexp.Regexp) map[string]bool {
+	client, header, err := torrentURLClient(m.config)
+	if err != nil {
+		log.Printf("Unable to fetch feeds: %s", err)
+		return seen
+	}
+
+	current := make(map[string]bool)
+	complete := true
+
+	for _, feedURL := range m.config.Feeds {
+		items, err := fetchFeed(feedURL, client, header)
+		if err != nil {
+			// the feed's items aren't known, so keep remembering them
+			log.Printf("Unable to fetch feed %s: %s", torrentSource(feedURL), err)
+			complete = false
+			continue
+		}
+
+		for _, item := range items {
+			if seen[item.id] || current[item.id] {
+				current[item.id] = true
+				continue
+			}
+
+			if !feedFilter(item.title, include, exclude) {
+				continue
+			}
+
+			_, err := m.addURL(item.url)
+			if err == nil {
+				log.Printf("Added %q from feed", item.title)
+			} else if !strings.HasPrefix(err.Error(), "Already added") {
+				// not marked as seen, so it's tried again next time
+				log.Printf("Unable to add %q from feed: %s", item.title, err)
+				continue
+			}
+
+			current[item.id] = true
+		}
+	}
+
+	if !complete {
+		for id := range seen {
+			current[id] = true
+		}
+	}
+
+	err = saveFeedsSeen(current, m.config.DataDir)
+	if err != nil {
+		log.Printf("Unable to save the feed items added: %s", err)
+	}
+
+	return current
+}
@@ -0,0 +1,110 @@
+package proxy
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Feeds", func() {
+	const (
+		hashA = "adecafcafeadecafcafeadecafcafeadecafcafe"
+		hashB = "0123456789abcdef0123456789abcdef01234567"
+	)
+
+	base, _ := url.Parse("http://example.com/feeds/rss.xml")
+
+	It("finds the torrents of RSS items", func() {
+		items, err := parseFeed(strings.NewReader(`<?xml version="1.0"?>
+<rss version="2.0"><channel>
+  <item><title>Episode 1</title><guid>ep1</guid><link>http://example.com/ep1</link>
+    <enclosure url="/torrents/ep1" type="application/x-bittorrent"/></item>
+  <item><title>Episode 2</title><link>magnet:?xt=urn:btih:`+hashA+`</link></item>
+  <item><title>Just a post</title><link>http://example.com/post</link></item>
+</channel></rss>`), base)
+
+		Expect(err).To(Succeed())
+		Expect(items).To(Equal([]feedItem{
+			{id: "ep1", title: "Episode 1", url: "http://example.com/torrents/ep1"},
+			{id: "magnet:?xt=urn:btih:" + hashA, title: "Episode 2", url: "magnet:?xt=urn:btih:" + hashA},
+		}))
+	})
+
+	It("finds the torrents of Atom entries, preferring enclosures", func() {
+		items, err := parseFeed(strings.NewReader(`<?xml version="1.0"?>
+<feed xmlns="http://www.w3.org/2005/Atom">
+  <entry><title>Release</title><id>urn:release</id>
+    <link href="http://example.com/release.torrent"/>
+    <link rel="enclosure" href="http://example.com/release-hd.torrent"/>
+  </entry>
+  <entry><title>Local</title><id>urn:local</id><link href="file:///etc/some.torrent"/></entry>
+</feed>`), base)
+
+		Expect(err).To(Succeed())
+		Expect(items).To(Equal([]feedItem{
+			{id: "urn:release", title: "Release", url: "http://example.com/release-hd.torrent"},
+		}))
+	})
+
+	It("filters titles", func() {
+		include := regexp.MustCompile(`(?i)1080p`)
+		exclude := regexp.MustCompile(`(?i)cam`)
+
+		Expect(feedFilter("Show S01E01 1080p", include, exclude)).To(BeTrue())
+		Expect(feedFilter("Show S01E01 720p", include, exclude)).To(BeFalse())
+		Expect(feedFilter("Movie 1080p CAM", include, exclude)).To(BeFalse())
+		Expect(feedFilter("Anything", nil, nil)).To(BeTrue())
+	})
+
+	It("adds new items once, and remembers them", func() {
+		dir, _ := ioutil.TempDir("", "evaporation-feeds")
+		defer os.RemoveAll(dir)
+
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintf(w, `<rss><channel>
+<item><title>Wanted</title><link>magnet:?xt=urn:btih:%s</link></item>
+<item><title>Unwanted</title><link>magnet:?xt=urn:btih:%s</link></item>
+</channel></rss>`, hashA, hashB)
+		}))
+		defer server.Close()
+
+		m, err := NewProxyManager(&Config{
+			TorrentListenAddr: "localhost:0",
+			DataDir:           dir,
+		})
+		Expect(err).To(Succeed())
+		defer m.Close()
+
+		m.config.Feeds = []string{server.URL}
+		seen := m.pollFeeds(map[string]bool{}, nil, regexp.MustCompile("Unwanted"))
+		Expect(m.List()).To(HaveLen(1))
+		_, ok := m.Get(hashA)
+		Expect(ok).To(BeTrue())
+
+		// removed since, but not added again
+		m.Remove(hashA)
+		m.pollFeeds(seen, nil, regexp.MustCompile("Unwanted"))
+		Expect(m.List()).To(BeEmpty())
+
+		saved, err := loadFeedsSeen(dir)
+		Expect(err).To(Succeed())
+		Expect(saved).To(Equal(seen))
+	})
+
+	It("rejects invalid filters", func() {
+		_, err := NewProxyManager(&Config{
+			TorrentListenAddr: "localhost:0",
+			Feeds:             []string{"http://example.com/rss.xml"},
+			FeedInclude:       "(",
+		})
+		Expect(err).To(MatchError(ContainSubstring("Invalid FeedInclude")))
+	})
+})
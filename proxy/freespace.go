@@ -0,0 +1,37 @@
+// +build linux darwin freebsd
+
+package proxy
+
+import (
+	"os"
+	"syscall"
+)
+
+// Return how many bytes are free for us to use on the filesystem holding dir.
+//
+// ok is false if that can't be found out.
+func freeSpace(dir string) (free int64, ok bool) {
+	if len(dir) == 0 {
+		dir = "."
+	}
+
+	var stat syscall.Statfs_t
+	err := syscall.Statfs(dir, &stat)
+	if err != nil {
+		return 0, false
+	}
+
+	return int64(stat.Bavail) * int64(stat.Bsize), true
+}
+
+// Return how many bytes of disk the file described by info takes up, which is less than its
+// size if it's sparse.
+func allocatedSize(info os.FileInfo) int64 {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return info.Size()
+	}
+
+	// st_blocks is always in 512 byte units, whatever the filesystem's block size
+	return int64(stat.Blocks) * 512
+}
@@ -0,0 +1,20 @@
+// +build !linux,!darwin,!freebsd
+
+package proxy
+
+import (
+	"os"
+)
+
+// Return how many bytes are free for us to use on the filesystem holding dir.
+//
+// ok is false if that can't be found out, which it can't on this platform.
+func freeSpace(dir string) (free int64, ok bool) {
+	return 0, false
+}
+
+// Return how many bytes of disk the file described by info takes up, which is its size on
+// this platform, since there's no telling if it's sparse.
+func allocatedSize(info os.FileInfo) int64 {
+	return info.Size()
+}
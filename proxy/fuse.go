@@ -0,0 +1,192 @@
+// +build linux darwin freebsd
+
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+	"github.com/anacrolix/torrent"
+)
+
+// Expose the torrent as a read-only filesystem at mountpoint, and block until ctx is done or
+// the filesystem is unmounted.
+//
+// Blocks until the torrent metadata is available before mounting.  Files are read through the
+// same readers and prioritization as HTTP requests, so pieces are fetched as they're read.
+func (p *TorrentProxy) Mount(ctx context.Context, mountpoint string) (err error) {
+	select {
+	case <-p.Ready():
+	case <-p.closed:
+		return fmt.Errorf("Proxy closed before the torrent metadata was available")
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	conn, err := fuse.Mount(mountpoint, fuse.ReadOnly(), fuse.FSName("evaporation"), fuse.Subtype("evaporation"))
+	if err != nil {
+		return fmt.Errorf("Unable to mount %s: %s", mountpoint, err)
+	}
+	defer conn.Close()
+
+	// unmounting makes Serve return
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			fuse.Unmount(mountpoint)
+		case <-done:
+		}
+	}()
+
+	err = fs.Serve(conn, &fuseFS{p: p})
+	if err != nil {
+		return
+	}
+
+	<-conn.Ready
+	return conn.MountError
+}
+
+// The filesystem served by Mount.
+type fuseFS struct {
+	p *TorrentProxy
+}
+
+func (f *fuseFS) Root() (fs.Node, error) {
+	return &fuseDir{p: f.p}, nil
+}
+
+// A directory in the torrent.
+type fuseDir struct {
+	p *TorrentProxy
+	// empty for the root, otherwise ending in a /
+	dir string
+}
+
+func (d *fuseDir) entries() map[string]bool {
+	files := d.p.torrent.Files()
+	paths := make([]string, 0, len(files))
+	for _, file := range files {
+		paths = append(paths, filePath(file))
+	}
+
+	return dirEntries(paths, d.dir)
+}
+
+func (d *fuseDir) Attr(ctx context.Context, a *fuse.Attr) error {
+	a.Mode = os.ModeDir | 0555
+	a.Mtime = d.p.modTime()
+	return nil
+}
+
+func (d *fuseDir) Lookup(ctx context.Context, name string) (fs.Node, error) {
+	isDir, ok := d.entries()[name]
+	if !ok {
+		return nil, fuse.ENOENT
+	}
+
+	if isDir {
+		return &fuseDir{p: d.p, dir: d.dir + name + "/"}, nil
+	}
+
+	file, ok := d.p.findFile(d.dir + name)
+	if !ok {
+		return nil, fuse.ENOENT
+	}
+
+	return &fuseFile{p: d.p, file: file}, nil
+}
+
+func (d *fuseDir) ReadDirAll(ctx context.Context) (dirents []fuse.Dirent, err error) {
+	entries := d.entries()
+
+	names := make([]string, 0, len(entries))
+	for name := range entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		dirent := fuse.Dirent{Name: name, Type: fuse.DT_File}
+		if entries[name] {
+			dirent.Type = fuse.DT_Dir
+		}
+		dirents = append(dirents, dirent)
+	}
+
+	return
+}
+
+// A file in the torrent.
+type fuseFile struct {
+	p    *TorrentProxy
+	file torrent.File
+}
+
+func (f *fuseFile) Attr(ctx context.Context, a *fuse.Attr) error {
+	a.Mode = 0444
+	a.Size = uint64(f.file.Length())
+	a.Mtime = f.p.modTime()
+	return nil
+}
+
+// Each open gets its own reader, just like each HTTP request.
+func (f *fuseFile) Open(ctx context.Context, req *fuse.OpenRequest, resp *fuse.OpenResponse) (fs.Handle, error) {
+	if !req.Flags.IsReadOnly() {
+		return nil, fuse.Errno(fuse.EPERM)
+	}
+
+	trs := f.p.openFile(f.file, fmt.Sprintf("fuse %d %s", req.Pid, filePath(f.file)))
+	handle := &fuseHandle{
+		trs:     trs,
+		untrack: f.p.trackReader(trs, fmt.Sprintf("fuse pid %d", req.Pid)),
+	}
+
+	// the torrent never changes, so let the kernel cache what it has read
+	resp.Flags |= fuse.OpenKeepCache
+
+	return handle, nil
+}
+
+// An open file, reading from the torrent.
+type fuseHandle struct {
+	// reads may arrive concurrently, but the reader has one position
+	lock    sync.Mutex
+	trs     *torrentReadSeeker
+	untrack func()
+}
+
+func (h *fuseHandle) Read(ctx context.Context, req *fuse.ReadRequest, resp *fuse.ReadResponse) (err error) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	// if the read is interrupted, stop waiting on pieces for it
+	h.trs.Context = ctx
+
+	_, err = h.trs.Seek(req.Offset, io.SeekStart)
+	if err != nil {
+		return
+	}
+
+	buf := make([]byte, req.Size)
+	n, err := io.ReadFull(h.trs, buf)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = nil
+	}
+	resp.Data = buf[:n]
+
+	return
+}
+
+func (h *fuseHandle) Release(ctx context.Context, req *fuse.ReleaseRequest) error {
+	h.untrack()
+	return h.trs.Close()
+}
@@ -0,0 +1,57 @@
+package proxy
+
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Return a handler that serves the status of the torrent as JSON, as ServeHTTP does at /, for
+// mounting on a mux of your own, at any path and behind any middleware.
+func (p *TorrentProxy) StatusHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w, r, span := p.traceRequest(w, r)
+		defer span.finish()
+
+		if p.serveUnauthorized(w, r, "/") {
+			return
+		}
+
+		w, done := p.compressResponse(w, r)
+		defer done()
+
+		p.serveStatus(w, r)
+	})
+}
+
+// Return a handler that serves the files of the torrent, and listings of its directories, as
+// ServeHTTP does, taking the request path as the path of the file.  Use http.StripPrefix to
+// mount it under a path of your own, and set Config.BasePath to that path so the links in
+// the status and listings point there.
+//
+// Files are answered with a 503 until the torrent metadata is available.
+func (p *TorrentProxy) FilesHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		w, r, span := p.traceRequest(w, r)
+		defer span.finish()
+
+		// http.StripPrefix with a trailing / leaves the path relative
+		if !strings.HasPrefix(r.URL.Path, "/") {
+			u := *r.URL
+			u.Path, u.RawPath = "/"+r.URL.Path, ""
+			r = r.WithContext(r.Context())
+			r.URL = &u
+		}
+
+		w, done := p.compressResponse(w, r)
+		defer done()
+
+		if p.servePending(w, r) {
+			return
+		}
+
+		p.serveFile(w, r, start, span)
+	})
+}
@@ -0,0 +1,36 @@
+package proxy
+
+import (
+	"net/http"
+	"path"
+)
+
+// Extra response headers for the files matching Pattern.
+type HeaderRule struct {
+	// A pattern as understood by path.Match, matched against the path of each file, e.g. "French/*"
+	Pattern string
+	// The headers to send, e.g. "Content-Language": "fr"
+	Headers map[string]string
+}
+
+// Set the headers of every Config.Headers rule that matches the file at name, in order, so
+// later rules win.  Returns the names of the headers set.
+func (p *TorrentProxy) setFileHeaders(w http.ResponseWriter, name string) (set []string) {
+	for _, rule := range p.config.Headers {
+		ok, err := path.Match(rule.Pattern, name)
+		if err != nil {
+			p.errlog.Printf("Invalid header pattern %q: %s", rule.Pattern, err)
+			continue
+		}
+		if !ok {
+			continue
+		}
+
+		for header, value := range rule.Headers {
+			w.Header().Set(header, value)
+			set = append(set, header)
+		}
+	}
+
+	return
+}
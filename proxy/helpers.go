@@ -1,10 +1,19 @@
 package proxy
 
 import (
+	"bytes"
+	"encoding/base64"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
 	"net"
 	"net/http"
 	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/anacrolix/dht"
 	"github.com/anacrolix/torrent"
@@ -18,9 +27,17 @@ import (
 //
 //   - http/https: A GET request will be made to this URL.
 //     The response to the request must include he torrent file with a 200 OK status code.
-func torrentSpecFromURL(input string) (output *torrent.TorrentSpec, err error) {
+//
+//   - file: The torrent file at the URL's path is read.
+//
+//   - data: The torrent file is the URL's base64 data, like data:application/x-bittorrent;base64,ZDg6...
+//
+// Torrent files are fetched with client, sending header with each request, and retried
+// after transient failures as retry allows.  Those bigger than maxSize bytes are rejected.
+// created is the creation date from the torrent file, or the zero time if it's not known.
+func torrentSpecFromURL(input string, maxSize int64, client *http.Client, header http.Header, retry retryPolicy) (output *torrent.TorrentSpec, created time.Time, err error) {
 	if len(input) == 0 {
-		return output, fmt.Errorf("URL not specified")
+		return output, created, fmt.Errorf("URL not specified")
 	}
 
 	u, err := url.Parse(input)
@@ -29,7 +46,7 @@ func torrentSpecFromURL(input string) (output *torrent.TorrentSpec, err error) {
 	}
 
 	if u.Scheme == "" {
-		return output, fmt.Errorf("Unable to parse URL")
+		return output, created, fmt.Errorf("Unable to parse URL")
 	}
 	// if it's a magnet scheme, then try to convert to spec, if it's malformed, we'll fail
 	if u.Scheme == "magnet" {
@@ -41,29 +58,260 @@ func torrentSpecFromURL(input string) (output *torrent.TorrentSpec, err error) {
 	}
 
 	// if it's an HTTP url, then attempt to fetch it and convert to magnet
-	// but if it's not either of those, bail we don't know what to do
-	if u.Scheme != "http" && u.Scheme != "https" {
-		return output, fmt.Errorf("Unknown URL scheme: %s", u.Scheme)
+	// but if it's not either of those, or a file, bail we don't know what to do
+	var data []byte
+	switch u.Scheme {
+	case "http", "https":
+		data, err = fetchTorrentFile(input, maxSize, client, header, retry)
+	case "file":
+		data, err = readTorrentFile(u.Path, maxSize)
+	case "data":
+		data, err = decodeDataURL(input, maxSize)
+	default:
+		return output, created, fmt.Errorf("Unknown URL scheme: %s", u.Scheme)
+	}
+	if err != nil {
+		return
 	}
 
-	resp, err := http.Get(input)
+	mi, err := metainfo.Load(bytes.NewReader(data))
 	if err != nil {
-		return output, fmt.Errorf("Error fetching: %s", err)
+		return output, created, fmt.Errorf("Not a valid torrent file: %s", err)
 	}
-	defer resp.Body.Close()
 
-	// TODO: be more permissive on code here?
-	if resp.StatusCode != 200 {
-		return output, fmt.Errorf("%s", resp.Status)
+	output = torrent.TorrentSpecFromMetaInfo(mi)
+	if mi.CreationDate > 0 {
+		created = time.Unix(mi.CreationDate, 0)
 	}
 
-	// this will fail fast and not read the whole body if it's not a torrent file
-	mi, err := metainfo.Load(resp.Body)
+	return
+}
+
+// Read a torrent file from disk, rejecting files bigger than maxSize bytes.
+func readTorrentFile(path string, maxSize int64) (data []byte, err error) {
+	f, err := os.Open(path)
 	if err != nil {
-		return output, fmt.Errorf("Not a valid torrent file: %s", err)
+		return
 	}
+	defer f.Close()
 
-	output = torrent.TorrentSpecFromMetaInfo(mi)
+	data, err = ioutil.ReadAll(io.LimitReader(f, maxSize+1))
+	if err != nil {
+		return
+	}
+
+	if int64(len(data)) > maxSize {
+		return nil, fmt.Errorf("Torrent file is larger than %d bytes", maxSize)
+	}
+
+	return
+}
+
+// Return the torrent file in a data: URL, rejecting files bigger than maxSize bytes.
+//
+// Only base64 data with no media type, or a torrent or binary one, is accepted.
+func decodeDataURL(input string, maxSize int64) (data []byte, err error) {
+	comma := strings.Index(input, ",")
+	if comma < 0 {
+		return nil, fmt.Errorf("Malformed data url: no data")
+	}
+	params := strings.Split(input[len("data:"):comma], ";")
+
+	mediaType := strings.ToLower(strings.TrimSpace(params[0]))
+	if len(mediaType) > 0 && mediaType != "application/x-bittorrent" && mediaType != "application/octet-stream" {
+		return nil, fmt.Errorf("Unsupported data url media type: %s", mediaType)
+	}
+	if !strings.EqualFold(params[len(params)-1], "base64") {
+		return nil, fmt.Errorf("Malformed data url: data must be base64")
+	}
+
+	// it may have been escaped to pass in a query string
+	encoded, err := url.PathUnescape(input[comma+1:])
+	if err != nil {
+		return nil, fmt.Errorf("Malformed data url: %s", err)
+	}
+	// checked before decoding too, allowing for padding, so a huge URL isn't decoded for nothing
+	if int64(base64.StdEncoding.DecodedLen(len(encoded))) > maxSize+2 {
+		return nil, fmt.Errorf("Torrent file is larger than %d bytes", maxSize)
+	}
+
+	data, err = base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("Malformed data url: %s", err)
+	}
+
+	if int64(len(data)) > maxSize {
+		return nil, fmt.Errorf("Torrent file is larger than %d bytes", maxSize)
+	}
+
+	return
+}
+
+// How many times to try fetching a torrent file over HTTP, resuming where the last try left off.
+const torrentFetchAttempts = 5
+
+// How to retry fetching a torrent file after a network error, or a response that says to try again.
+type retryPolicy struct {
+	// how many times to retry after the first try
+	retries int
+	// how long to wait before the first retry, doubling for each one after
+	backoff time.Duration
+}
+
+// Returns true if a response with this status code might succeed if it's tried again.
+func retryableStatus(code int) bool {
+	return code >= 500 || code == http.StatusTooManyRequests
+}
+
+// Return how long a response asks us to wait before trying again, or 0 if it doesn't say.
+func retryAfter(resp *http.Response) time.Duration {
+	seconds, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+	if err != nil || seconds < 0 {
+		return 0
+	}
+
+	return time.Duration(seconds) * time.Second
+}
+
+// Fetch a torrent file over HTTP, and return its contents.
+//
+// Redirects are followed.  Network errors, 5xx and 429 responses are retried as retry allows,
+// waiting at least as long as a Retry-After header asks.
+//
+// If the response is cut short, the rest is requested with a Range request, as long as the
+// file hasn't changed.  Files bigger than maxSize bytes are rejected without reading them all.
+func fetchTorrentFile(input string, maxSize int64, client *http.Client, header http.Header, retry retryPolicy) (data []byte, err error) {
+	var validator string
+	var retried, resumed int
+
+	// wait to try again, or return false if we've tried enough
+	again := func(reason string, after time.Duration) bool {
+		if retried >= retry.retries {
+			return false
+		}
+
+		wait := retry.backoff << uint(retried)
+		if after > wait {
+			wait = after
+		}
+		retried++
+
+		log.Printf("Fetching torrent file failed, retrying in %s (%d of %d): %s", wait, retried, retry.retries, reason)
+		time.Sleep(wait)
+
+		return true
+	}
+
+	for {
+		req, err := http.NewRequest("GET", input, nil)
+		if err != nil {
+			return nil, fmt.Errorf("Error fetching: %s", err)
+		}
+		for name, values := range header {
+			req.Header[name] = values
+		}
+
+		resuming := len(data) > 0
+		if resuming {
+			req.Header.Set("Range", fmt.Sprintf("bytes=%d-", len(data)))
+			req.Header.Set("If-Range", validator)
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			if again(err.Error(), 0) {
+				continue
+			}
+			return nil, fmt.Errorf("Error fetching: %s", err)
+		}
+
+		switch {
+		case resuming && resp.StatusCode == 206:
+		case resp.StatusCode == 200:
+			// the server ignored our range, or the file changed, so start over
+			data = data[:0]
+			validator = resp.Header.Get("ETag")
+			if len(validator) == 0 {
+				validator = resp.Header.Get("Last-Modified")
+			}
+		case retryableStatus(resp.StatusCode):
+			resp.Body.Close()
+			if again(resp.Status, retryAfter(resp)) {
+				continue
+			}
+			return nil, fmt.Errorf("%s", resp.Status)
+		default:
+			resp.Body.Close()
+			return nil, fmt.Errorf("%s", resp.Status)
+		}
+
+		if resp.ContentLength > 0 && int64(len(data))+resp.ContentLength > maxSize {
+			resp.Body.Close()
+			return nil, fmt.Errorf("Torrent file is larger than %d bytes", maxSize)
+		}
+
+		// read one byte past the limit to find out if it's over
+		chunk, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxSize-int64(len(data))+1))
+		resp.Body.Close()
+		data = append(data, chunk...)
+
+		if int64(len(data)) > maxSize {
+			return nil, fmt.Errorf("Torrent file is larger than %d bytes", maxSize)
+		}
+
+		if err == nil {
+			return data, nil
+		}
+
+		// without a validator we can't tell if a range is from the same file
+		resumed++
+		if resumed == torrentFetchAttempts || len(validator) == 0 {
+			return nil, fmt.Errorf("Error fetching: %s", err)
+		}
+
+		log.Printf("Fetching torrent file interrupted after %d bytes, resuming: %s", len(data), err)
+	}
+}
+
+// Return how to retry fetching an http(s) TorrentURL, as configured.
+func torrentURLRetry(config *Config) retryPolicy {
+	if config.TorrentURLRetries < 0 {
+		return retryPolicy{}
+	}
+
+	return retryPolicy{retries: config.TorrentURLRetries, backoff: config.TorrentURLRetryBackoff}
+}
+
+// Return the client and headers to fetch an http(s) TorrentURL with, as configured.
+func torrentURLClient(config *Config) (client *http.Client, header http.Header, err error) {
+	header = make(http.Header)
+	for name, value := range config.TorrentURLHeaders {
+		header.Set(name, value)
+	}
+
+	if config.TorrentURLClient != nil {
+		return config.TorrentURLClient, header, nil
+	}
+
+	client = newHTTPClient(config.DNSResolver)
+
+	if len(config.TorrentURLProxy) > 0 {
+		// the proxy URL may hold credentials, so it's left out of the error
+		u, err := url.Parse(config.TorrentURLProxy)
+		if err != nil || len(u.Host) == 0 {
+			return nil, nil, fmt.Errorf("Invalid TorrentURLProxy")
+		}
+
+		transport := newHTTPTransport(config.DNSResolver)
+		transport.Proxy = http.ProxyURL(u)
+		client = &http.Client{Transport: transport}
+	}
+
+	if config.TorrentURLTimeout > 0 {
+		timed := *client
+		timed.Timeout = config.TorrentURLTimeout
+		client = &timed
+	}
 
 	return
 }
@@ -74,10 +322,16 @@ func torrentSpecFromURL(input string) (output *torrent.TorrentSpec, err error) {
 // Resolve all DHT nodes.
 // nodes is an array of host:port strings. See net.Dial() docs for valid formats.
 //
+// Hostnames are looked up with resolver, or the system resolver if it's nil.
 // Returns an error if any of the items are not resolvable.
-func resolveDHTNodes(nodes []string) (resolvedDHTNodes []dht.Addr, err error) {
+func resolveDHTNodes(nodes []string, resolver *net.Resolver) (resolvedDHTNodes []dht.Addr, err error) {
 	for _, hostport := range nodes {
-		addr, err := net.ResolveUDPAddr("udp", hostport)
+		var addr *net.UDPAddr
+		if resolver == nil {
+			addr, err = net.ResolveUDPAddr("udp", hostport)
+		} else {
+			addr, err = resolveUDPAddr(resolver, hostport)
+		}
 		if err != nil {
 			return resolvedDHTNodes, err
 		}
@@ -86,3 +340,98 @@ func resolveDHTNodes(nodes []string) (resolvedDHTNodes []dht.Addr, err error) {
 
 	return
 }
+
+// Parse the Range header of a request for a file of the given size.
+//
+// Only a single byte range is supported, as that's what players send when seeking.
+// ok is false if there is no Range header, or it can't be satisfied.
+func parseRange(header string, size int64) (start int64, length int64, ok bool) {
+	if !strings.HasPrefix(header, "bytes=") || strings.Contains(header, ",") {
+		return
+	}
+
+	spec := strings.TrimSpace(header[len("bytes="):])
+	dash := strings.Index(spec, "-")
+	if dash < 0 {
+		return
+	}
+
+	first, last := spec[:dash], spec[dash+1:]
+
+	// bytes=-N is the last N bytes
+	if len(first) == 0 {
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n <= 0 {
+			return
+		}
+		if n > size {
+			n = size
+		}
+		return size - n, n, size > 0
+	}
+
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 || start >= size {
+		return 0, 0, false
+	}
+
+	end := size - 1
+	if len(last) > 0 {
+		end, err = strconv.ParseInt(last, 10, 64)
+		if err != nil || end < start {
+			return 0, 0, false
+		}
+		if end >= size {
+			end = size - 1
+		}
+	}
+
+	return start, end - start + 1, true
+}
+
+// Convert backslash separated paths, from torrents created on Windows, to forward slashes.
+func normalizePath(path string) string {
+	return strings.Replace(path, "\\", "/", -1)
+}
+
+// Return the escaped URL path a file in the torrent is served at.
+func fileLink(path string) string {
+	return (&url.URL{Path: "/" + path}).EscapedPath()
+}
+
+// Return r with base, a Config.BasePath, stripped from its path, so it's routed as if we owned /,
+// whether or not a reverse proxy has stripped it already.
+func stripBasePath(r *http.Request, base string) *http.Request {
+	if len(base) == 0 || (r.URL.Path != base && !strings.HasPrefix(r.URL.Path, base+"/")) {
+		return r
+	}
+
+	u := *r.URL
+	u.Path, u.RawPath = "/"+strings.TrimPrefix(r.URL.Path[len(base):], "/"), ""
+	r = r.WithContext(r.Context())
+	r.URL = &u
+
+	return r
+}
+
+// Return the path of a file in the torrent as it's listed and requested over HTTP.
+func filePath(file torrent.File) string {
+	return normalizePath(file.Path())
+}
+
+// Return the names of the files and directories directly under dir, which is empty for the root
+// or ends in a /, mapped to whether they're directories.
+func dirEntries(paths []string, dir string) (entries map[string]bool) {
+	entries = make(map[string]bool)
+
+	for _, path := range paths {
+		if !strings.HasPrefix(path, dir) {
+			continue
+		}
+
+		parts := strings.SplitN(path[len(dir):], "/", 2)
+		entries[parts[0]] = entries[parts[0]] || len(parts) > 1
+	}
+
+	return
+}
@@ -1,9 +1,16 @@
 package proxy
 
 import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
 	"log"
 	"net"
 	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"time"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -26,7 +33,7 @@ var _ = Describe("Helpers", func() {
 				inputUrl string
 			)
 			AfterEach(func() {
-				spec, err = torrentSpecFromURL(inputUrl)
+				spec, _, err = torrentSpecFromURL(inputUrl, 1<<20, http.DefaultClient, nil, retryPolicy{})
 				Expect(err).To(HaveOccurred())
 			})
 
@@ -49,7 +56,7 @@ var _ = Describe("Helpers", func() {
 
 		Context("Magnet URL decoding", func() {
 			It("fails when given an malformed magnet URL", func() {
-				spec, err = torrentSpecFromURL("magnet:?xt=urn:btih:this-is-not-valid-hex")
+				spec, _, err = torrentSpecFromURL("magnet:?xt=urn:btih:this-is-not-valid-hex", 1<<20, http.DefaultClient, nil, retryPolicy{})
 				Expect(err).To(HaveOccurred())
 			})
 
@@ -57,7 +64,7 @@ var _ = Describe("Helpers", func() {
 				hex := "adecafcafeadecafcafeadecafcafeadecafcafe"
 				name := "some-title"
 
-				spec, err = torrentSpecFromURL("magnet:?dn=" + name + "&xt=urn:btih:" + hex)
+				spec, _, err = torrentSpecFromURL("magnet:?dn="+name+"&xt=urn:btih:"+hex, 1<<20, http.DefaultClient, nil, retryPolicy{})
 
 				Expect(err).To(Succeed())
 				Expect(spec.InfoHash.HexString()).To(Equal(hex))
@@ -65,9 +72,42 @@ var _ = Describe("Helpers", func() {
 			})
 		})
 
+		Context("Data URL decoding", func() {
+			It("decodes a torrent file in base64", func() {
+				mi, _ := metainfo.LoadFromFile("testdata/sample.torrent")
+				data, _ := ioutil.ReadFile("testdata/sample.torrent")
+
+				spec, _, err = torrentSpecFromURL("data:application/x-bittorrent;base64,"+base64.StdEncoding.EncodeToString(data), 1<<20, http.DefaultClient, nil, retryPolicy{})
+
+				Expect(err).To(Succeed())
+				Expect(spec.InfoHash.HexString()).To(Equal(mi.HashInfoBytes().HexString()))
+				Expect(spec.InfoBytes).NotTo(BeNil())
+			})
+
+			It("fails when the data isn't a torrent file in base64", func() {
+				_, _, err = torrentSpecFromURL("data:application/x-bittorrent,d8:announce", 1<<20, http.DefaultClient, nil, retryPolicy{})
+				Expect(err).To(MatchError(ContainSubstring("must be base64")))
+
+				_, _, err = torrentSpecFromURL("data:text/plain;base64,aGVsbG8=", 1<<20, http.DefaultClient, nil, retryPolicy{})
+				Expect(err).To(MatchError(ContainSubstring("media type")))
+
+				_, _, err = torrentSpecFromURL("data:;base64,aGVsbG8=", 1<<20, http.DefaultClient, nil, retryPolicy{})
+				Expect(err).To(MatchError(ContainSubstring("Not a valid torrent file")))
+			})
+
+			It("fails when the torrent file is too large", func() {
+				data, _ := ioutil.ReadFile("testdata/sample.torrent")
+
+				_, _, err = torrentSpecFromURL("data:;base64,"+base64.StdEncoding.EncodeToString(data), 100, http.DefaultClient, nil, retryPolicy{})
+				Expect(err).To(MatchError(ContainSubstring("larger than 100 bytes")))
+			})
+		})
+
 		Context("When talking to an HTTP server", func() {
 			var (
-				baseUrl string
+				baseUrl     string
+				interrupted []string
+				unavailable int
 			)
 
 			BeforeEach(func() {
@@ -84,31 +124,161 @@ var _ = Describe("Helpers", func() {
 					http.ServeFile(w, r, "testdata/sample.torrent")
 				})
 
+				// serves half the torrent, then hangs up, unless the client asks for a range
+				interrupted = nil
+				http.HandleFunc("/flaky-torrent", func(w http.ResponseWriter, r *http.Request) {
+					interrupted = append(interrupted, r.Header.Get("Range"))
+					w.Header().Set("ETag", `"sample"`)
+					if len(r.Header.Get("Range")) > 0 {
+						http.ServeFile(w, r, "testdata/sample.torrent")
+						return
+					}
+
+					data, _ := ioutil.ReadFile("testdata/sample.torrent")
+					w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+					w.Write(data[:len(data)/2])
+					w.(http.Flusher).Flush()
+					panic(http.ErrAbortHandler)
+				})
+
+				http.HandleFunc("/private-torrent", func(w http.ResponseWriter, r *http.Request) {
+					if r.Header.Get("Cookie") != "uid=1; pass=secret" {
+						http.Error(w, "Forbidden", 403)
+						return
+					}
+					http.ServeFile(w, r, "testdata/sample.torrent")
+				})
+
+				// fails twice, then works
+				unavailable = 0
+				http.HandleFunc("/unavailable-torrent", func(w http.ResponseWriter, r *http.Request) {
+					unavailable++
+					if unavailable <= 2 {
+						http.Error(w, "Bad Gateway", 502)
+						return
+					}
+					http.ServeFile(w, r, "testdata/sample.torrent")
+				})
+
+				http.HandleFunc("/moved-torrent", func(w http.ResponseWriter, r *http.Request) {
+					http.Redirect(w, r, "/a-torrent", 302)
+				})
+
 				listener, _ := net.Listen("tcp", "localhost:0")
 				baseUrl = "http://" + listener.Addr().String()
 				go http.Serve(listener, nil)
 			})
 
+			It("retries transient failures with backoff", func() {
+				started := time.Now()
+				spec, _, err = torrentSpecFromURL(baseUrl+"/unavailable-torrent", 1<<20, http.DefaultClient, nil, retryPolicy{retries: 2, backoff: 10 * time.Millisecond})
+
+				Expect(err).To(Succeed())
+				Expect(unavailable).To(Equal(3))
+				Expect(time.Since(started)).To(BeNumerically(">=", 30*time.Millisecond))
+			})
+
+			It("gives up once it's out of retries", func() {
+				spec, _, err = torrentSpecFromURL(baseUrl+"/unavailable-torrent", 1<<20, http.DefaultClient, nil, retryPolicy{retries: 1, backoff: time.Millisecond})
+
+				Expect(err).To(MatchError(ContainSubstring("502")))
+				Expect(unavailable).To(Equal(2))
+			})
+
+			It("doesn't retry responses that won't change", func() {
+				started := time.Now()
+				spec, _, err = torrentSpecFromURL(baseUrl+"/fail", 1<<20, http.DefaultClient, nil, retryPolicy{retries: 3, backoff: time.Second})
+
+				Expect(err).To(MatchError(ContainSubstring("404")))
+				Expect(time.Since(started)).To(BeNumerically("<", time.Second))
+			})
+
+			It("follows redirects", func() {
+				spec, _, err = torrentSpecFromURL(baseUrl+"/moved-torrent", 1<<20, http.DefaultClient, nil, retryPolicy{})
+				Expect(err).To(Succeed())
+			})
+
+			It("sends the configured headers", func() {
+				client, header, err := torrentURLClient(&Config{
+					TorrentURLHeaders: map[string]string{"cookie": "uid=1; pass=secret"},
+				})
+				Expect(err).To(Succeed())
+
+				spec, _, err = torrentSpecFromURL(baseUrl+"/private-torrent", 1<<20, client, nil, retryPolicy{})
+				Expect(err).To(MatchError(ContainSubstring("403")))
+
+				spec, _, err = torrentSpecFromURL(baseUrl+"/private-torrent", 1<<20, client, header, retryPolicy{})
+				Expect(err).To(Succeed())
+			})
+
+			It("fetches through the configured proxy", func() {
+				var proxied []string
+				proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					proxied = append(proxied, r.URL.String())
+					http.ServeFile(w, r, "testdata/sample.torrent")
+				}))
+				defer proxy.Close()
+
+				client, header, err := torrentURLClient(&Config{TorrentURLProxy: proxy.URL})
+				Expect(err).To(Succeed())
+
+				spec, _, err = torrentSpecFromURL("http://tracker.invalid/a.torrent", 1<<20, client, header, retryPolicy{})
+				Expect(err).To(Succeed())
+				Expect(proxied).To(Equal([]string{"http://tracker.invalid/a.torrent"}))
+			})
+
+			It("rejects an invalid proxy without revealing it", func() {
+				_, _, err := torrentURLClient(&Config{TorrentURLProxy: "user:secret"})
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).NotTo(ContainSubstring("secret"))
+			})
+
+			It("uses the configured client and timeout", func() {
+				client, _, _ := torrentURLClient(&Config{TorrentURLTimeout: time.Second})
+				Expect(client.Timeout).To(Equal(time.Second))
+				Expect(http.DefaultClient.Timeout).To(BeZero())
+
+				custom := &http.Client{}
+				client, _, _ = torrentURLClient(&Config{TorrentURLClient: custom, TorrentURLTimeout: time.Second})
+				Expect(client).To(BeIdenticalTo(custom))
+			})
+
 			It("fails when given an unreachable url", func() {
-				spec, err = torrentSpecFromURL("http://localhost:99999/")
+				spec, _, err = torrentSpecFromURL("http://localhost:99999/", 1<<20, http.DefaultClient, nil, retryPolicy{})
 				Expect(err).To(HaveOccurred())
 			})
 
 			It("fails when given a URL that doesn't return 200", func() {
-				spec, err = torrentSpecFromURL(baseUrl + "/fail")
+				spec, _, err = torrentSpecFromURL(baseUrl+"/fail", 1<<20, http.DefaultClient, nil, retryPolicy{})
 				Expect(err).To(HaveOccurred())
 			})
 
 			It("fails when given an URL that isn't a torrent", func() {
-				spec, err = torrentSpecFromURL(baseUrl + "/not-a-torrent")
+				spec, _, err = torrentSpecFromURL(baseUrl+"/not-a-torrent", 1<<20, http.DefaultClient, nil, retryPolicy{})
 				Expect(err).To(HaveOccurred())
 			})
 
+			It("resumes interrupted fetches", func() {
+				mi, _ := metainfo.LoadFromFile("testdata/sample.torrent")
+				data, _ := ioutil.ReadFile("testdata/sample.torrent")
+
+				spec, _, err = torrentSpecFromURL(baseUrl+"/flaky-torrent", 1<<20, http.DefaultClient, nil, retryPolicy{})
+
+				Expect(err).To(Succeed())
+				Expect(spec.InfoHash.HexString()).To(Equal(mi.HashInfoBytes().HexString()))
+				Expect(interrupted).To(Equal([]string{"", fmt.Sprintf("bytes=%d-", len(data)/2)}))
+			})
+
+			It("fails when the torrent file is too large", func() {
+				spec, _, err = torrentSpecFromURL(baseUrl+"/a-torrent", 100, http.DefaultClient, nil, retryPolicy{})
+				Expect(err).To(MatchError(ContainSubstring("larger than 100 bytes")))
+			})
+
 			It("decodes the torrent when given an good URL", func() {
 				mi, _ := metainfo.LoadFromFile("testdata/sample.torrent")
 				info, _ := mi.UnmarshalInfo()
 
-				spec, err = torrentSpecFromURL(baseUrl + "/a-torrent")
+				spec, _, err = torrentSpecFromURL(baseUrl+"/a-torrent", 1<<20, http.DefaultClient, nil, retryPolicy{})
 
 				Expect(err).To(Succeed())
 				Expect(spec.InfoHash.HexString()).To(Equal(mi.HashInfoBytes().HexString()))
@@ -117,6 +287,83 @@ var _ = Describe("Helpers", func() {
 		})
 	})
 
+	Describe("Parsing Range headers", func() {
+		It("parses a bounded range", func() {
+			start, length, ok := parseRange("bytes=100-199", 1000)
+
+			Expect(ok).To(BeTrue())
+			Expect(start).To(Equal(int64(100)))
+			Expect(length).To(Equal(int64(100)))
+		})
+
+		It("parses an open ended range", func() {
+			start, length, ok := parseRange("bytes=900-", 1000)
+
+			Expect(ok).To(BeTrue())
+			Expect(start).To(Equal(int64(900)))
+			Expect(length).To(Equal(int64(100)))
+		})
+
+		It("parses a suffix range", func() {
+			start, length, ok := parseRange("bytes=-10", 1000)
+
+			Expect(ok).To(BeTrue())
+			Expect(start).To(Equal(int64(990)))
+			Expect(length).To(Equal(int64(10)))
+		})
+
+		It("clamps ranges past the end of the file", func() {
+			_, length, ok := parseRange("bytes=500-5000", 1000)
+
+			Expect(ok).To(BeTrue())
+			Expect(length).To(Equal(int64(500)))
+		})
+
+		It("rejects ranges it can't satisfy", func() {
+			for _, header := range []string{"", "bytes=1000-", "bytes=10-5", "bytes=0-1,5-10", "items=0-10", "bytes=abc-"} {
+				_, _, ok := parseRange(header, 1000)
+				Expect(ok).To(BeFalse(), header)
+			}
+		})
+	})
+
+	Describe("Normalizing paths", func() {
+		It("converts backslashes to slashes", func() {
+			Expect(normalizePath(`Some Show\Season 1\episode.mkv`)).To(Equal("Some Show/Season 1/episode.mkv"))
+		})
+
+		It("leaves slashes alone", func() {
+			Expect(normalizePath("Some Show/episode.mkv")).To(Equal("Some Show/episode.mkv"))
+		})
+	})
+
+	Describe("Linking to files", func() {
+		It("escapes paths", func() {
+			Expect(fileLink("Some Show/ep 1 [720p]#?.mkv")).To(Equal("/Some%20Show/ep%201%20%5B720p%5D%23%3F.mkv"))
+			Expect(fileLink("Amélie.mkv")).To(Equal("/Am%C3%A9lie.mkv"))
+		})
+	})
+
+	Describe("Listing directories", func() {
+		paths := []string{"Show/Season 1/ep1.mkv", "Show/Season 1/ep2.mkv", "Show/readme.txt", "Show/Season 2/ep1.mkv"}
+
+		It("lists the root", func() {
+			Expect(dirEntries(paths, "")).To(Equal(map[string]bool{"Show": true}))
+		})
+
+		It("lists files and directories under a directory", func() {
+			Expect(dirEntries(paths, "Show/")).To(Equal(map[string]bool{
+				"Season 1":   true,
+				"Season 2":   true,
+				"readme.txt": false,
+			}))
+		})
+
+		It("lists nothing under a file", func() {
+			Expect(dirEntries(paths, "Show/readme.txt/")).To(BeEmpty())
+		})
+	})
+
 	Describe("Resolving DHT Nodes", func() {
 		var (
 			nodes         []string
@@ -125,7 +372,7 @@ var _ = Describe("Helpers", func() {
 		)
 
 		Context("When no nodes are provided", func() {
-			resolvedNodes, err := resolveDHTNodes(nodes)
+			resolvedNodes, err := resolveDHTNodes(nodes, nil)
 
 			log.Print(resolvedNodes)
 			It("should return an empty list", func() {
@@ -145,7 +392,7 @@ var _ = Describe("Helpers", func() {
 				addrs[i] = addr + ":1234"
 			}
 
-			resolvedNodes, err = resolveDHTNodes(nodes)
+			resolvedNodes, err = resolveDHTNodes(nodes, nil)
 
 			It("returns them resolved", func() {
 				Expect(err).To(Succeed())
@@ -155,7 +402,7 @@ var _ = Describe("Helpers", func() {
 
 		Context("When valid IP addresses are provided", func() {
 			AfterEach(func() {
-				resolvedNodes, err = resolveDHTNodes(nodes)
+				resolvedNodes, err = resolveDHTNodes(nodes, nil)
 				Expect(err).To(Succeed())
 				Expect(nodes[0]).To(Equal(resolvedNodes[0].String()))
 			})
@@ -172,7 +419,7 @@ var _ = Describe("Helpers", func() {
 
 		Context("When invalid values are provided", func() {
 			AfterEach(func() {
-				resolvedNodes, err = resolveDHTNodes(nodes)
+				resolvedNodes, err = resolveDHTNodes(nodes, nil)
 				Expect(err).To(HaveOccurred())
 			})
 
@@ -188,5 +435,31 @@ var _ = Describe("Helpers", func() {
 				nodes = []string{"192.0.2.1:99999"}
 			})
 		})
+
+		Context("When a resolver is provided", func() {
+			// a resolver that can't reach any DNS server
+			broken := &net.Resolver{
+				PreferGo: true,
+				Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+					return nil, fmt.Errorf("no DNS here")
+				},
+			}
+
+			It("looks up hostnames with it", func() {
+				_, err := resolveDHTNodes([]string{"example.com:1234"}, broken)
+				Expect(err).To(HaveOccurred())
+			})
+
+			It("doesn't need it for IP addresses", func() {
+				resolvedNodes, err := resolveDHTNodes([]string{"192.0.2.1:1234"}, broken)
+				Expect(err).To(Succeed())
+				Expect(resolvedNodes[0].String()).To(Equal("192.0.2.1:1234"))
+			})
+
+			It("fetches torrent URLs with it", func() {
+				_, _, err := torrentSpecFromURL("http://example.com/a.torrent", 1<<20, newHTTPClient(broken), nil, retryPolicy{})
+				Expect(err).To(MatchError(ContainSubstring("no DNS here")))
+			})
+		})
 	})
 })
@@ -0,0 +1,120 @@
+package proxy
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/anacrolix/torrent"
+)
+
+// How often transfer rates are sampled for /stats/history.
+const historyInterval = 10 * time.Second
+
+// How many samples are kept, an hour's worth.
+const historySamples = 360
+
+// The window of samples /stats/history returns, if none is asked for.
+const defaultHistoryWindow = 10 * time.Minute
+
+// The transfer rates of the torrent over one historyInterval, see GET /stats/history.
+type RateSample struct {
+	// When the sample was taken, at the end of the interval
+	Time time.Time `json:"time"`
+	// Bytes of piece data per second downloaded from peers
+	Download float64 `json:"download"`
+	// Bytes of piece data per second uploaded to peers
+	Upload float64 `json:"upload"`
+}
+
+// A ring buffer of the most recent historySamples rate samples.
+type rateHistory struct {
+	lock    sync.Mutex
+	samples []RateSample
+	next    int
+}
+
+// Record a sample, replacing the oldest once the buffer is full.
+func (h *rateHistory) Add(sample RateSample) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if len(h.samples) < historySamples {
+		h.samples = append(h.samples, sample)
+	} else {
+		h.samples[h.next] = sample
+	}
+	h.next = (h.next + 1) % historySamples
+}
+
+// Return the samples taken since since, oldest first.
+func (h *rateHistory) Since(since time.Time) []RateSample {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	samples := make([]RateSample, 0, len(h.samples))
+	for i := range h.samples {
+		// once full, the oldest is the one that's next to be replaced
+		sample := h.samples[(h.next+i)%len(h.samples)]
+
+		if !sample.Time.Before(since) {
+			samples = append(samples, sample)
+		}
+	}
+
+	return samples
+}
+
+// Sample the transfer rates of t every historyInterval until the proxy is closed.
+//
+// t is passed in rather than read from p.torrent, which Close clears, and the ticker can win
+// the race with closed.
+func (p *TorrentProxy) recordHistory(t *torrent.Torrent) {
+	ticker := time.NewTicker(historyInterval)
+	defer ticker.Stop()
+
+	stats := t.Stats()
+	last := time.Now()
+	read, written := stats.BytesReadData, stats.BytesWrittenData
+
+	for {
+		select {
+		case now := <-ticker.C:
+			stats = t.Stats()
+			seconds := now.Sub(last).Seconds()
+
+			p.history.Add(RateSample{
+				Time:     now.UTC(),
+				Download: float64(stats.BytesReadData-read) / seconds,
+				Upload:   float64(stats.BytesWrittenData-written) / seconds,
+			})
+
+			last = now
+			read, written = stats.BytesReadData, stats.BytesWrittenData
+		case <-p.closed:
+			return
+		}
+	}
+}
+
+// Serve the rate samples of the last ?window=, a duration like 10m, as JSON.
+func (p *TorrentProxy) serveHistory(w http.ResponseWriter, r *http.Request) {
+	window := defaultHistoryWindow
+	if len(r.URL.Query().Get("window")) > 0 {
+		var err error
+		window, err = time.ParseDuration(r.URL.Query().Get("window"))
+		if err != nil || window <= 0 {
+			p.errlog.Printf("%d %s: invalid window", 400, r.URL.Path)
+
+			writeError(w, r, 400, "Invalid window, expected a duration like 10m", nil)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(p.history.Since(time.Now().Add(-window)))
+
+	log.Printf("%d %s", 200, r.URL.Path)
+}
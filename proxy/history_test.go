@@ -0,0 +1,36 @@
+package proxy
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Rate history", func() {
+	start := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	It("returns the samples in the window, oldest first", func() {
+		h := &rateHistory{}
+		for i := 0; i < 5; i++ {
+			h.Add(RateSample{Time: start.Add(time.Duration(i) * historyInterval), Download: float64(i)})
+		}
+
+		samples := h.Since(start.Add(3 * historyInterval))
+		Expect(samples).To(HaveLen(2))
+		Expect(samples[0].Download).To(Equal(float64(3)))
+		Expect(samples[1].Download).To(Equal(float64(4)))
+	})
+
+	It("drops the oldest samples once full", func() {
+		h := &rateHistory{}
+		for i := 0; i < historySamples+10; i++ {
+			h.Add(RateSample{Time: start.Add(time.Duration(i) * historyInterval), Download: float64(i)})
+		}
+
+		samples := h.Since(start)
+		Expect(samples).To(HaveLen(historySamples))
+		Expect(samples[0].Download).To(Equal(float64(10)))
+		Expect(samples[historySamples-1].Download).To(Equal(float64(historySamples + 9)))
+	})
+})
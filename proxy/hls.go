@@ -0,0 +1,93 @@
+package proxy
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os/exec"
+	"strconv"
+	"time"
+)
+
+// The target length in seconds of each segment in an HLS playlist.
+const hlsSegmentDuration = 10.0
+
+// Build an HLS playlist that presents a file as byte ranges of itself.
+//
+// Nothing is remuxed, so segment durations are estimated by assuming a constant bitrate.
+// Segments are rounded up to whole pieces, so each one is fetched from the swarm in one go.
+func hlsPlaylist(uri string, length int64, duration float64, pieceLength int64) string {
+	segment := int64(float64(length) * hlsSegmentDuration / duration)
+	if segment < pieceLength {
+		segment = pieceLength
+	}
+	segment = (segment + pieceLength - 1) / pieceLength * pieceLength
+	if segment > length {
+		segment = length
+	}
+
+	var b bytes.Buffer
+	b.WriteString("#EXTM3U\n")
+	b.WriteString("#EXT-X-VERSION:4\n")
+	fmt.Fprintf(&b, "#EXT-X-TARGETDURATION:%d\n", int(math.Ceil(duration*float64(segment)/float64(length))))
+	b.WriteString("#EXT-X-MEDIA-SEQUENCE:0\n")
+	b.WriteString("#EXT-X-PLAYLIST-TYPE:VOD\n")
+
+	for off := int64(0); off < length; off += segment {
+		size := segment
+		if off+size > length {
+			size = length - off
+		}
+
+		fmt.Fprintf(&b, "#EXTINF:%.3f,\n", duration*float64(size)/float64(length))
+		fmt.Fprintf(&b, "#EXT-X-BYTERANGE:%d@%d\n", size, off)
+		b.WriteString(uri + "\n")
+	}
+
+	b.WriteString("#EXT-X-ENDLIST\n")
+
+	return b.String()
+}
+
+// Serve an HLS playlist for a media file, for players that can't stream a plain file.
+func (p *TorrentProxy) serveHLS(w http.ResponseWriter, r *http.Request, path string) {
+	file, ok := p.findFile(path)
+	if !ok || !isMediaFile(filePath(file)) {
+		p.errlog.Printf("%d %s", 404, r.URL.Path)
+
+		writeError(w, r, 404, "File Not Found", nil)
+		return
+	}
+
+	// we need the duration to say how long each segment is
+	info, err := p.MediaInfo(path)
+	if err == nil && info.Duration <= 0 {
+		err = fmt.Errorf("Unknown duration: %s", path)
+	}
+	if err != nil {
+		code := 500
+		if _, ok := err.(*exec.Error); ok {
+			// ffprobe isn't installed
+			code = 501
+		}
+		p.errlog.Printf("%d %s: %s", code, r.URL.Path, err)
+
+		writeError(w, r, code, err.Error(), nil)
+		return
+	}
+
+	uri := p.link(filePath(file))
+	// segments are good for as long as the playlist
+	if expires, err := strconv.ParseInt(r.URL.Query().Get("expires"), 10, 64); err == nil {
+		if query := p.signedQuery(filePath(file), time.Unix(expires, 0)); len(query) > 0 {
+			uri += "?" + query
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+	w.Write([]byte(hlsPlaylist(uri, file.Length(), info.Duration, p.torrent.Info().PieceLength)))
+
+	log.Printf("%d %s", 200, r.URL.Path)
+}
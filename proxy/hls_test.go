@@ -0,0 +1,31 @@
+package proxy
+
+import (
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("HLS playlists", func() {
+	It("splits a file into piece aligned byte ranges", func() {
+		// 100 seconds over 1000 bytes is 100 bytes per segment, rounded up to 128 byte pieces
+		playlist := hlsPlaylist("/movie.mkv", 1000, 100, 128)
+
+		Expect(playlist).To(HavePrefix("#EXTM3U\n"))
+		Expect(playlist).To(ContainSubstring("#EXT-X-TARGETDURATION:13\n"))
+		Expect(playlist).To(ContainSubstring("#EXTINF:12.800,\n#EXT-X-BYTERANGE:128@0\n/movie.mkv\n"))
+		Expect(playlist).To(ContainSubstring("#EXTINF:10.400,\n#EXT-X-BYTERANGE:104@896\n/movie.mkv\n"))
+		Expect(playlist).To(HaveSuffix("#EXT-X-ENDLIST\n"))
+
+		Expect(strings.Count(playlist, "#EXT-X-BYTERANGE")).To(Equal(8))
+	})
+
+	It("uses a single segment for files smaller than a piece", func() {
+		playlist := hlsPlaylist("/clip.mp4", 100, 5, 1024)
+
+		Expect(strings.Count(playlist, "#EXT-X-BYTERANGE")).To(Equal(1))
+		Expect(playlist).To(ContainSubstring("#EXT-X-TARGETDURATION:5\n"))
+		Expect(playlist).To(ContainSubstring("#EXT-X-BYTERANGE:100@0\n"))
+	})
+})
@@ -0,0 +1,110 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os/exec"
+	"strings"
+)
+
+// What the routing hook is told about each content request.
+type RoutingRequest struct {
+	// The path of the file requested, without the leading /
+	Path string `json:"path"`
+	// The HTTP method
+	Method string `json:"method"`
+	// The client's address, as host:port
+	Client string `json:"client"`
+	// The client's User-Agent header
+	UserAgent string `json:"userAgent"`
+}
+
+// What the routing hook decided to do with a request.
+type RoutingDecision struct {
+	// "allow", "deny" or "rewrite"
+	Action string `json:"action"`
+	// For "rewrite", the path of the file to serve instead
+	Path string `json:"path,omitempty"`
+	// For "deny", the 4xx or 5xx status code to respond with.  Anything else is 403.
+	Status int `json:"status,omitempty"`
+}
+
+// Ask the routing hook what to do with a request for path.
+//
+// Hooks starting with http:// or https:// are sent the RoutingRequest as a JSON POST.
+// Anything else is run as an executable with the RoutingRequest as JSON on stdin.
+// Either way, the hook must respond with a RoutingDecision as JSON.
+func (p *TorrentProxy) route(r *http.Request, path string) (decision *RoutingDecision, err error) {
+	body, err := json.Marshal(&RoutingRequest{
+		Path:      path,
+		Method:    r.Method,
+		Client:    r.RemoteAddr,
+		UserAgent: r.UserAgent(),
+	})
+	if err != nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), p.config.RoutingHookTimeout)
+	defer cancel()
+
+	hook := p.config.RoutingHook
+
+	if strings.HasPrefix(hook, "http://") || strings.HasPrefix(hook, "https://") {
+		req, err := http.NewRequest("POST", hook, bytes.NewReader(body))
+		if err != nil {
+			return decision, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+		if err != nil {
+			return decision, err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != 200 {
+			return decision, fmt.Errorf("Routing hook returned %s", resp.Status)
+		}
+
+		decision = &RoutingDecision{}
+		err = json.NewDecoder(resp.Body).Decode(decision)
+		if err != nil {
+			return decision, fmt.Errorf("Invalid routing decision: %s", err)
+		}
+	} else {
+		cmd := exec.CommandContext(ctx, hook)
+		cmd.Stdin = bytes.NewReader(body)
+
+		out, err := cmd.Output()
+		if err != nil {
+			return decision, fmt.Errorf("Routing hook failed: %s", err)
+		}
+
+		decision = &RoutingDecision{}
+		err = json.Unmarshal(out, decision)
+		if err != nil {
+			return decision, fmt.Errorf("Invalid routing decision: %s", err)
+		}
+	}
+
+	switch decision.Action {
+	case "allow":
+	case "deny":
+		// a 2xx or 3xx wouldn't deny anything
+		if decision.Status < 400 || decision.Status > 599 {
+			decision.Status = 403
+		}
+	case "rewrite":
+		if len(decision.Path) == 0 {
+			return decision, fmt.Errorf("Routing hook rewrote to an empty path")
+		}
+	default:
+		return decision, fmt.Errorf("Unknown routing action: %q", decision.Action)
+	}
+
+	return
+}
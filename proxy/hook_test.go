@@ -0,0 +1,123 @@
+package proxy
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Routing hooks", func() {
+	var (
+		p   *TorrentProxy
+		req *http.Request
+	)
+
+	BeforeEach(func() {
+		p = &TorrentProxy{config: &Config{RoutingHookTimeout: time.Second}}
+		req = httptest.NewRequest("GET", "/some/file.mkv", nil)
+	})
+
+	Context("When the hook is a URL", func() {
+		var (
+			server   *httptest.Server
+			received RoutingRequest
+			response string
+		)
+
+		BeforeEach(func() {
+			server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				json.NewDecoder(r.Body).Decode(&received)
+				w.Write([]byte(response))
+			}))
+			p.config.RoutingHook = server.URL
+		})
+
+		AfterEach(func() {
+			server.Close()
+		})
+
+		It("sends the request details", func() {
+			response = `{"action": "allow"}`
+
+			decision, err := p.route(req, "some/file.mkv")
+
+			Expect(err).To(Succeed())
+			Expect(decision.Action).To(Equal("allow"))
+			Expect(received.Path).To(Equal("some/file.mkv"))
+			Expect(received.Method).To(Equal("GET"))
+			Expect(received.Client).To(Equal(req.RemoteAddr))
+		})
+
+		It("returns rewrites", func() {
+			response = `{"action": "rewrite", "path": "other/file.mkv"}`
+
+			decision, err := p.route(req, "some/file.mkv")
+
+			Expect(err).To(Succeed())
+			Expect(decision.Path).To(Equal("other/file.mkv"))
+		})
+
+		It("denies with 403 unless told an error status", func() {
+			response = `{"action": "deny"}`
+			decision, err := p.route(req, "some/file.mkv")
+			Expect(err).To(Succeed())
+			Expect(decision.Status).To(Equal(403))
+
+			response = `{"action": "deny", "status": 200}`
+			decision, err = p.route(req, "some/file.mkv")
+			Expect(err).To(Succeed())
+			Expect(decision.Status).To(Equal(403))
+		})
+
+		It("fails on unknown actions", func() {
+			response = `{"action": "maybe"}`
+
+			_, err := p.route(req, "some/file.mkv")
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("fails on rewrites without a path", func() {
+			response = `{"action": "rewrite"}`
+
+			_, err := p.route(req, "some/file.mkv")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Context("When the hook is an executable", func() {
+		var dir string
+
+		BeforeEach(func() {
+			dir, _ = ioutil.TempDir("", "evaporation")
+			p.config.RoutingHook = filepath.Join(dir, "hook")
+
+			ioutil.WriteFile(p.config.RoutingHook, []byte("#!/bin/sh\ncat > /dev/null\necho '{\"action\": \"deny\", \"status\": 451}'\n"), 0755)
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(dir)
+		})
+
+		It("returns its decision", func() {
+			decision, err := p.route(req, "some/file.mkv")
+
+			Expect(err).To(Succeed())
+			Expect(decision.Action).To(Equal("deny"))
+			Expect(decision.Status).To(Equal(451))
+		})
+
+		It("fails when it can't be run", func() {
+			p.config.RoutingHook = filepath.Join(dir, "missing")
+
+			_, err := p.route(req, "some/file.mkv")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
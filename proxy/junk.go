@@ -0,0 +1,63 @@
+package proxy
+
+import (
+	"path"
+	"strings"
+)
+
+// Directories whose contents are never the main attraction.
+var junkDirs = map[string]bool{
+	"proof":   true,
+	"proofs":  true,
+	"sample":  true,
+	"samples": true,
+	"screens": true,
+}
+
+// Files that are never wanted, whatever their size.
+var junkExtensions = map[string]bool{
+	".exe": true,
+	".lnk": true,
+	".url": true,
+}
+
+// Text files smaller than this are release notes and ads.
+const junkTextSize = 1 << 10
+
+// Returns true if a file in a torrent is obviously not worth downloading.
+func isJunk(name string, length int64) bool {
+	name = strings.ToLower(name)
+	parts := strings.Split(name, "/")
+
+	for _, dir := range parts[:len(parts)-1] {
+		if junkDirs[dir] {
+			return true
+		}
+	}
+
+	base := parts[len(parts)-1]
+	ext := path.Ext(base)
+
+	switch {
+	case junkExtensions[ext], base == "thumbs.db":
+		return true
+	case ext == ".txt" && length < junkTextSize:
+		return true
+	case isMediaFile(base) && strings.Contains(strings.TrimSuffix(base, ext), "sample"):
+		return true
+	}
+
+	return false
+}
+
+// Set every junk file in the torrent to PrioritySkip.
+func (p *TorrentProxy) skipJunk() {
+	p.priorityLock.Lock()
+	defer p.priorityLock.Unlock()
+
+	for _, file := range p.torrent.Files() {
+		if isJunk(filePath(file), file.Length()) {
+			p.priorities[filePath(file)] = PrioritySkip
+		}
+	}
+}
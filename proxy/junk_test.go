@@ -0,0 +1,22 @@
+package proxy
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Junk", func() {
+	It("recognizes extras", func() {
+		Expect(isJunk("Movie/Sample/movie.mkv", 50<<20)).To(BeTrue())
+		Expect(isJunk("Movie/Proof/cover.jpg", 100<<10)).To(BeTrue())
+		Expect(isJunk("Movie/movie-sample.mkv", 50<<20)).To(BeTrue())
+		Expect(isJunk("Movie/RARBG.txt", 30)).To(BeTrue())
+		Expect(isJunk("Movie/Visit us.url", 100)).To(BeTrue())
+	})
+
+	It("leaves everything else alone", func() {
+		Expect(isJunk("Movie/movie.mkv", 4<<30)).To(BeFalse())
+		Expect(isJunk("Movie/notes.txt", 10<<10)).To(BeFalse())
+		Expect(isJunk("Samples of Music/track.flac", 30<<20)).To(BeFalse())
+	})
+})
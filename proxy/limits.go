@@ -0,0 +1,97 @@
+package proxy
+
+import (
+	"net"
+	"net/http"
+	"sync"
+)
+
+// Seconds clients should wait before retrying a file request turned away by a stream limit.
+const streamRetryAfter = "5"
+
+// Counts the file streams in progress, in total and per client IP, against Config.MaxStreams
+// and Config.MaxStreamsPerClient.
+type streamLimiter struct {
+	// 0 for no limit
+	max       int
+	perClient int
+
+	lock     sync.Mutex
+	total    int
+	byClient map[string]int
+}
+
+// Create a limiter that allows max streams at once, and perClient from any one client IP.
+func newStreamLimiter(max, perClient int) *streamLimiter {
+	return &streamLimiter{
+		max:       max,
+		perClient: perClient,
+		byClient:  make(map[string]int),
+	}
+}
+
+// Start a stream for client if the limits allow it, returning the func to call when it's done,
+// or the status code to turn it away with.
+func (l *streamLimiter) Acquire(client string) (release func(), code int) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	// the client is the one at fault, so tell it so before blaming everyone
+	if l.perClient > 0 && l.byClient[client] >= l.perClient {
+		return nil, 429
+	}
+	if l.max > 0 && l.total >= l.max {
+		return nil, 503
+	}
+
+	l.total++
+	l.byClient[client]++
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.lock.Lock()
+			defer l.lock.Unlock()
+
+			l.total--
+			l.byClient[client]--
+			if l.byClient[client] == 0 {
+				delete(l.byClient, client)
+			}
+		})
+	}, 0
+}
+
+// Return the IP a request came from.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+// Start a stream for the request if the stream limits allow it.  Returns the func to call when
+// the stream is done, or false if the request was turned away and has been handled.
+func (p *TorrentProxy) acquireStream(w http.ResponseWriter, r *http.Request) (release func(), ok bool) {
+	if p.streams == nil {
+		return func() {}, true
+	}
+
+	release, code := p.streams.Acquire(clientIP(r))
+	if release != nil {
+		return release, true
+	}
+
+	w.Header().Set("Retry-After", streamRetryAfter)
+	p.errlog.Printf("%d %s: stream limit reached for %s", code, r.URL.Path, clientIP(r))
+
+	if code == 429 {
+		writeError(w, r, code, "Too many streams from this client", nil)
+	} else {
+		writeError(w, r, code, "Too many streams, try again later", nil)
+	}
+
+	return nil, false
+}
@@ -0,0 +1,47 @@
+package proxy
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Stream limits", func() {
+	It("limits streams per client", func() {
+		l := newStreamLimiter(0, 2)
+
+		a, code := l.Acquire("192.0.2.1")
+		Expect(code).To(BeZero())
+		_, code = l.Acquire("192.0.2.1")
+		Expect(code).To(BeZero())
+
+		release, code := l.Acquire("192.0.2.1")
+		Expect(release).To(BeNil())
+		Expect(code).To(Equal(429))
+
+		// other clients have their own allowance
+		_, code = l.Acquire("192.0.2.2")
+		Expect(code).To(BeZero())
+
+		a()
+		_, code = l.Acquire("192.0.2.1")
+		Expect(code).To(BeZero())
+	})
+
+	It("limits streams in total", func() {
+		l := newStreamLimiter(1, 0)
+
+		release, code := l.Acquire("192.0.2.1")
+		Expect(code).To(BeZero())
+
+		_, code = l.Acquire("192.0.2.2")
+		Expect(code).To(Equal(503))
+
+		// releasing twice doesn't free up a stream that's still in use
+		release()
+		release()
+		_, code = l.Acquire("192.0.2.2")
+		Expect(code).To(BeZero())
+		_, code = l.Acquire("192.0.2.3")
+		Expect(code).To(Equal(503))
+	})
+})
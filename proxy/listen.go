@@ -0,0 +1,93 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// Marks a listen address that is the path of a unix domain socket, e.g. unix:///run/evaporation.sock.
+const unixSocketPrefix = "unix://"
+
+// Returns true if addr is a unix domain socket rather than host:port.
+func isUnixSocket(addr string) bool {
+	return strings.HasPrefix(addr, unixSocketPrefix)
+}
+
+// Listen for HTTP connections on addr, which is host:port, or a unix socket created with mode.
+// Returns the listener and the address it ended up on, which differs from addr if the port was 0.
+func listenHTTP(addr string, mode os.FileMode) (listener net.Listener, actual string, err error) {
+	if !isUnixSocket(addr) {
+		listener, err = net.Listen("tcp", addr)
+		if err != nil {
+			return
+		}
+
+		return listener, listener.Addr().String(), nil
+	}
+
+	path := strings.TrimPrefix(addr, unixSocketPrefix)
+	if len(path) == 0 {
+		return nil, "", fmt.Errorf("Invalid unix socket address: %s", addr)
+	}
+
+	err = removeStaleSocket(path)
+	if err != nil {
+		return
+	}
+
+	listener, err = net.Listen("unix", path)
+	if err != nil {
+		return
+	}
+
+	// the socket is created with the umask applied, so set what we were asked for
+	err = os.Chmod(path, mode)
+	if err != nil {
+		listener.Close()
+		return nil, "", fmt.Errorf("Unable to set permissions of %s: %s", path, err)
+	}
+
+	return listener, addr, nil
+}
+
+// Remove the socket at path if a previous run left it behind, so we can listen on it again.
+// Refuses to remove anything that isn't a socket, or a socket something is still listening on.
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s exists and is not a socket", path)
+	}
+
+	conn, err := net.DialTimeout("unix", path, time.Second)
+	if err == nil {
+		conn.Close()
+		return fmt.Errorf("%s is already in use", path)
+	}
+
+	return os.Remove(path)
+}
+
+// Return the base URL of an HTTP server listening on addr.
+//
+// A unix socket has no URL of its own, so it's publicURL, the URL a reverse proxy in front of it
+// is reached at, which must be specified.
+func listenURL(addr string, publicURL string) (string, error) {
+	if isUnixSocket(addr) {
+		if len(publicURL) == 0 {
+			return "", fmt.Errorf("PublicURL must be specified to listen on a unix socket")
+		}
+		return strings.TrimSuffix(publicURL, "/"), nil
+	}
+
+	return "http://" + addr, nil
+}
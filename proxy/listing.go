@@ -0,0 +1,88 @@
+package proxy
+
+import (
+	"encoding/json"
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// The HTML rendering of a directory listing.
+var listingTemplate = template.Must(template.New("listing").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>{{.Dir}}</title></head>
+<body>
+<h1>{{.Dir}}</h1>
+<table>
+<tr><th>Path</th><th>Size</th><th>Complete</th></tr>
+{{range .Files}}<tr><td><a href="{{.URL}}">{{.Path}}</a></td><td>{{.Length}}</td><td>{{printf "%.0f" .Percent}}%</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+// A file in the HTML rendering of a directory listing.
+type listingEntry struct {
+	*TorrentFile
+	// relative to the directory
+	Path    string
+	Percent float32
+}
+
+// Return the files in the torrent under dir, which must end in a /.
+func (p *TorrentProxy) listDirectory(dir string) (files []*TorrentFile) {
+	files = make([]*TorrentFile, 0)
+
+	for _, file := range p.Status().Files {
+		if strings.HasPrefix(file.Path, dir) {
+			files = append(files, file)
+		}
+	}
+
+	return
+}
+
+// Serve a listing of the files under dir as JSON, or as HTML to browsers.
+//
+// Returns false if there are no files under dir, so it's not a directory.
+// Directories requested without a trailing / are redirected to include one.
+func (p *TorrentProxy) serveDirectory(w http.ResponseWriter, r *http.Request, dir string) bool {
+	slash := strings.HasSuffix(dir, "/")
+	if !slash {
+		dir += "/"
+	}
+
+	files := p.listDirectory(dir)
+	if len(files) == 0 {
+		return false
+	}
+
+	// like http.FileServer, so relative links from the listing work
+	if !slash {
+		http.Redirect(w, r, p.basePath()+r.URL.Path+"/", http.StatusMovedPermanently)
+
+		log.Printf("%d %s", http.StatusMovedPermanently, r.URL.Path)
+		return true
+	}
+
+	if r.URL.Query().Get("format") == "html" || strings.Contains(r.Header.Get("Accept"), "text/html") {
+		entries := make([]*listingEntry, 0, len(files))
+		for _, file := range files {
+			entries = append(entries, &listingEntry{
+				TorrentFile: file,
+				Path:        file.Path[len(dir):],
+				Percent:     file.Complete * 100,
+			})
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		listingTemplate.Execute(w, map[string]interface{}{"Dir": dir, "Files": entries})
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(files)
+	}
+
+	log.Printf("%d %s", 200, r.URL.Path)
+	return true
+}
@@ -0,0 +1,108 @@
+package proxy
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// The most distinct messages we'll track before lumping the rest together.
+const maxSampledMessages = 1000
+
+// Deduplicates high-frequency repeated log messages.
+//
+// The first occurrence of a message is logged immediately.  Repeats within the interval are
+// counted instead, and a "repeated N times" summary is logged when the interval expires.
+type sampledLogger struct {
+	interval time.Duration
+
+	lock     sync.Mutex
+	messages map[string]*sampledMessage
+	// repeats of messages we couldn't track because the map was full
+	overflow int
+}
+
+// A message we've logged, and how many times it's been repeated since.
+type sampledMessage struct {
+	first   time.Time
+	repeats int
+}
+
+// Create a logger that logs each distinct message at most once per interval.
+func newSampledLogger(interval time.Duration) *sampledLogger {
+	return &sampledLogger{
+		interval: interval,
+		messages: make(map[string]*sampledMessage),
+	}
+}
+
+// Log a message unless it has already been logged within the interval.
+func (l *sampledLogger) Printf(format string, v ...interface{}) {
+	msg := fmt.Sprintf(format, v...)
+
+	if l.interval <= 0 {
+		log.Print(msg)
+		return
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	m, ok := l.messages[msg]
+	if ok && time.Since(m.first) < l.interval {
+		m.repeats++
+		return
+	}
+
+	if ok {
+		l.summarize(msg, m)
+	} else if len(l.messages) >= maxSampledMessages {
+		l.overflow++
+		return
+	}
+
+	l.messages[msg] = &sampledMessage{first: time.Now()}
+	log.Print(msg)
+}
+
+// Log summaries for, and forget, messages whose interval has expired.
+func (l *sampledLogger) Flush() {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	for msg, m := range l.messages {
+		if time.Since(m.first) >= l.interval {
+			l.summarize(msg, m)
+			delete(l.messages, msg)
+		}
+	}
+
+	if l.overflow > 0 {
+		log.Printf("%d other repeated messages suppressed", l.overflow)
+		l.overflow = 0
+	}
+}
+
+// Log how many times a message was repeated, if at all.
+func (l *sampledLogger) summarize(msg string, m *sampledMessage) {
+	if m.repeats > 0 {
+		log.Printf("%s (repeated %d times in %s)", msg, m.repeats, time.Since(m.first).Truncate(time.Second))
+	}
+}
+
+// Periodically flush summaries until done is closed.
+func (l *sampledLogger) run(done <-chan struct{}) {
+	ticker := time.NewTicker(l.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			l.Flush()
+		case <-done:
+			l.Flush()
+			return
+		}
+	}
+}
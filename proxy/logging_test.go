@@ -0,0 +1,71 @@
+package proxy
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("sampledLogger", func() {
+	var (
+		out *bytes.Buffer
+		l   *sampledLogger
+	)
+
+	BeforeEach(func() {
+		out = new(bytes.Buffer)
+		log.SetOutput(out)
+
+		l = newSampledLogger(time.Hour)
+	})
+
+	AfterEach(func() {
+		log.SetOutput(os.Stderr)
+	})
+
+	It("logs the first occurrence of a message", func() {
+		l.Printf("%d %s", 404, "/missing")
+
+		Expect(out.String()).To(ContainSubstring("404 /missing"))
+	})
+
+	It("suppresses repeats within the interval", func() {
+		for i := 0; i < 10; i++ {
+			l.Printf("%d %s", 404, "/missing")
+		}
+
+		Expect(strings.Count(out.String(), "404 /missing")).To(Equal(1))
+	})
+
+	It("logs distinct messages", func() {
+		l.Printf("%d %s", 404, "/missing")
+		l.Printf("%d %s", 404, "/also-missing")
+
+		Expect(strings.Count(out.String(), "\n")).To(Equal(2))
+	})
+
+	It("summarizes repeats when the interval expires", func() {
+		l.interval = 500 * time.Millisecond
+		l.Printf("%d %s", 404, "/missing")
+		l.Printf("%d %s", 404, "/missing")
+		l.Printf("%d %s", 404, "/missing")
+
+		time.Sleep(l.interval)
+		l.Flush()
+
+		Expect(out.String()).To(ContainSubstring("repeated"))
+	})
+
+	It("logs everything when disabled", func() {
+		l.interval = 0
+		l.Printf("%d %s", 404, "/missing")
+		l.Printf("%d %s", 404, "/missing")
+
+		Expect(strings.Count(out.String(), "404 /missing")).To(Equal(2))
+	})
+})
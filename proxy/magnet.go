@@ -0,0 +1,71 @@
+package proxy
+
+import (
+	"log"
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/anacrolix/torrent"
+)
+
+// What a magnet URL says about where to find the torrent, besides its infohash, name and
+// trackers, which are in the TorrentSpec made from it.
+type magnetHints struct {
+	// x.pe=, host:port addresses of peers
+	peers []string
+	// ws=, URLs of web seeds
+	webSeeds []string
+}
+
+// Return the peers and web seeds in a magnet URL, or nothing if it isn't one.
+func parseMagnetHints(input string) (hints magnetHints) {
+	u, err := url.Parse(input)
+	if err != nil || u.Scheme != "magnet" {
+		return
+	}
+
+	query := u.Query()
+	hints.peers = nonEmpty(query["x.pe"])
+	hints.webSeeds = nonEmpty(query["ws"])
+
+	return
+}
+
+// Return values without those that are blank.
+func nonEmpty(values []string) (kept []string) {
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if len(value) > 0 {
+			kept = append(kept, value)
+		}
+	}
+
+	return
+}
+
+// Add the peers a magnet URL named to the torrent, looking up their hostnames first, so a
+// trackerless magnet has someone to ask for its metadata before the DHT finds anyone.
+func (p *TorrentProxy) addMagnetPeers(t *torrent.Torrent, addrs []string) {
+	var peers []torrent.Peer
+	for _, hostport := range addrs {
+		var addr *net.UDPAddr
+		var err error
+		// peers take uTP on the same port as TCP, so either resolves the same
+		if p.config.DNSResolver == nil {
+			addr, err = net.ResolveUDPAddr("udp", hostport)
+		} else {
+			addr, err = resolveUDPAddr(p.config.DNSResolver, hostport)
+		}
+		if err != nil {
+			log.Printf("Ignoring magnet peer %s: %s", hostport, err)
+			continue
+		}
+
+		peers = append(peers, torrent.Peer{IP: addr.IP, Port: addr.Port})
+	}
+
+	if len(peers) > 0 {
+		t.AddPeers(peers)
+	}
+}
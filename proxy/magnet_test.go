@@ -0,0 +1,37 @@
+package proxy
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Magnet URLs", func() {
+	const magnet = "magnet:?xt=urn:btih:adecafcafeadecafcafeadecafcafeadecafcafe" +
+		"&tr=udp%3A%2F%2Ftracker.example.com%3A80&tr=http%3A%2F%2Ftracker.example.org%2Fannounce" +
+		"&x.pe=127.0.0.1%3A6881&x.pe=%5B%3A%3A1%5D%3A6882&x.pe=" +
+		"&ws=http%3A%2F%2Fseed.example.com%2Ffiles%2F"
+
+	It("finds the peers and web seeds in a magnet URL", func() {
+		hints := parseMagnetHints(magnet)
+		Expect(hints.peers).To(Equal([]string{"127.0.0.1:6881", "[::1]:6882"}))
+		Expect(hints.webSeeds).To(Equal([]string{"http://seed.example.com/files/"}))
+
+		Expect(parseMagnetHints("http://example.com/some.torrent?ws=x")).To(Equal(magnetHints{}))
+	})
+
+	It("uses the trackers and peers of a magnet, and reports them in the status", func() {
+		p, err := NewTorrentProxy(&Config{
+			TorrentURL:        magnet,
+			TorrentListenAddr: "localhost:0",
+		})
+		Expect(err).To(Succeed())
+		defer p.Close()
+
+		s := p.Status()
+		Expect(s.Trackers).To(ConsistOf("udp://tracker.example.com:80", "http://tracker.example.org/announce"))
+		Expect(s.MagnetPeers).To(Equal([]string{"127.0.0.1:6881", "[::1]:6882"}))
+		Expect(s.WebSeeds).To(Equal([]string{"http://seed.example.com/files/"}))
+		Expect(s.Magnet).To(ContainSubstring("x.pe=127.0.0.1%3A6881"))
+		Expect(s.Magnet).To(ContainSubstring("ws=http%3A%2F%2Fseed.example.com%2Ffiles%2F"))
+	})
+})
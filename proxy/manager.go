@@ -0,0 +1,508 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/anacrolix/torrent"
+)
+
+// Hosts many torrents in one process, sharing a torrent client and HTTP server between them.
+//
+// Each torrent is served by its own TorrentProxy under /{infohash}/, so /{infohash}/path/to/file
+// behaves like /path/to/file on a standalone proxy, and the links it returns include /{infohash}.
+// Config.BasePath goes before it.
+//
+// Use NewProxyManager to create.
+type ProxyManager struct {
+	config    *Config
+	client    *torrent.Client
+	dhtNodes  *dhtNodeList
+	server    *http.Server
+	httperror chan error
+	// closed when the manager is closed
+	closed    chan struct{}
+	closeOnce sync.Once
+
+	// infohashes to the proxies serving them
+	proxies map[string]*TorrentProxy
+	lock    sync.RWMutex
+
+	// how many torrents count against Config.MaxActiveTorrents, and those waiting, in the order
+	// they were added, with what admits them.  Guarded by lock.
+	active int
+	queue  []*queuedProxy
+}
+
+// A torrent waiting for room under Config.MaxActiveTorrents.
+type queuedProxy struct {
+	proxy    *TorrentProxy
+	admitted chan struct{}
+}
+
+// Create a manager and start its torrent client and HTTP server.
+//
+// Only the DHTNodes, DHTListenAddr, DNSResolver, HTTPListenAddr, SocketMode, TorrentListenAddr, PeerTransport,
+// Encryption, MaxPeers, MaxHalfOpen, PeerInterface, PeerIPVersion, ReadToken, AdminToken, DataDir,
+// FlatDataDir, DataKey, EphemeralData, Offline, CompleteTTL, MaxActiveTorrents, BasePath, PublicURL, and DisableHTTP fields of config are used.  Everything else is configured per torrent with Add,
+// except for the torrents in TorrentURLs, WatchDir and Feeds, which are added with the rest of config.
+func NewProxyManager(config *Config) (m *ProxyManager, err error) {
+	applyConfigDefaults(config)
+
+	resolvedDHTNodes, err := resolveDHTNodes(config.DHTNodes, config.DNSResolver)
+	if err != nil {
+		return nil, fmt.Errorf("Error resolving DHT node: %s", err)
+	}
+
+	dhtNodes := newDHTNodeList(resolvedDHTNodes)
+	disk, err := newDiskStorage(config)
+	if err != nil {
+		return
+	}
+
+	client, err := newTorrentClient(config, dhtNodes, disk)
+	if err != nil {
+		return
+	}
+
+	m = &ProxyManager{
+		config:   config,
+		client:   client,
+		dhtNodes: dhtNodes,
+		closed:   make(chan struct{}),
+		proxies:  make(map[string]*TorrentProxy),
+	}
+
+	if !config.DisableHTTP {
+		err = m.startHTTPServer()
+		if err != nil {
+			client.Close()
+			return nil, err
+		}
+	}
+
+	// from here on, Close stops it
+	if client.DHT() != nil {
+		go runDHTNodeSaver(client.DHT(), config.DataDir, m.closed)
+	}
+
+	for _, torrentURL := range config.TorrentURLs {
+		_, err = m.addURL(torrentURL)
+		if err != nil {
+			m.Close()
+			return nil, fmt.Errorf("Unable to add %s: %s", torrentURL, err)
+		}
+	}
+
+	if len(config.WatchDir) > 0 {
+		info, err := os.Stat(config.WatchDir)
+		if err == nil && !info.IsDir() {
+			err = fmt.Errorf("Not a directory")
+		}
+		if err != nil {
+			m.Close()
+			return nil, fmt.Errorf("Invalid WatchDir: %s", err)
+		}
+
+		go m.runWatchDir(config.WatchDir)
+	}
+
+	if config.CompleteTTL > 0 {
+		go m.runRetention()
+	}
+
+	if len(config.Feeds) > 0 {
+		include, exclude, err := compileFeedFilters(config)
+		if err != nil {
+			m.Close()
+			return nil, err
+		}
+
+		go m.runFeeds(include, exclude)
+	}
+
+	return
+}
+
+// Add a torrent with a copy of the manager's own config, as for TorrentURLs.
+func (m *ProxyManager) addURL(torrentURL string) (p *TorrentProxy, err error) {
+	// each gets its own copy, as Add fills in what the manager serves it with
+	c := *m.config
+	c.TorrentURL, c.TorrentURLs, c.WatchDir, c.Feeds = torrentURL, nil, "", nil
+	c.AdminListenAddr = ""
+
+	return m.Add(&c)
+}
+
+// Start the manager's HTTP server on Config.HTTPListenAddr.
+func (m *ProxyManager) startHTTPServer() (err error) {
+	_, err = listenURL(m.config.HTTPListenAddr, m.config.PublicURL)
+	if err != nil {
+		return
+	}
+
+	listener, addr, err := listenHTTP(m.config.HTTPListenAddr, m.config.SocketMode)
+	if err != nil {
+		return
+	}
+	m.config.HTTPListenAddr = addr
+
+	m.httperror = make(chan error)
+	m.server = &http.Server{Handler: m}
+
+	go func() {
+		err := m.server.Serve(listener)
+		if err == http.ErrServerClosed {
+			err = nil
+		}
+		m.httperror <- err
+	}()
+
+	return
+}
+
+// Add a torrent, returning the proxy that serves it.
+//
+// config is the proxy's configuration, as for NewTorrentProxy, except that the manager's torrent
+// client, HTTP server and BasePath are used.  If DataDir, ReadToken or AdminToken are not specified, they
+// default to the manager's.
+// Blocks until the torrent URL is resolved, but not for the torrent metadata.  If there are already
+// Config.MaxActiveTorrents, the torrent is queued rather than added to the torrent client.
+func (m *ProxyManager) Add(config *Config) (p *TorrentProxy, err error) {
+	if len(config.DataDir) == 0 {
+		config.DataDir = m.config.DataDir
+	}
+	if len(config.ReadToken) == 0 {
+		config.ReadToken = m.config.ReadToken
+	}
+	if len(config.AdminToken) == 0 {
+		config.AdminToken = m.config.AdminToken
+	}
+	// the manager's HTTP server is what a reverse proxy serves under BasePath
+	config.BasePath = m.config.BasePath
+	config.DisableHTTP = true
+	config.Async = false
+
+	// take a place now if there's room, so the torrent is added to the client as it would be
+	// without a limit
+	var queued *queuedProxy
+	if m.config.MaxActiveTorrents > 0 {
+		m.lock.Lock()
+		if m.active < m.config.MaxActiveTorrents {
+			m.active++
+		} else {
+			queued = &queuedProxy{admitted: make(chan struct{})}
+		}
+		m.lock.Unlock()
+	}
+
+	var admitted chan struct{}
+	if queued != nil {
+		admitted = queued.admitted
+	}
+
+	p, err = newTorrentProxy(config, m.client, m.dhtNodes, admitted)
+	if err != nil {
+		if m.config.MaxActiveTorrents > 0 && queued == nil {
+			m.release()
+		}
+		return
+	}
+
+	// a torrent that's already being served is refused by the client when it's added, before
+	// this proxy can touch it, but a queued one isn't in the client yet
+	id := p.infoHash()
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if _, ok := m.proxies[id]; ok && queued != nil {
+		p.Close()
+		return nil, fmt.Errorf("Already added: %s", id)
+	}
+
+	p.prefix = "/" + id
+	p.url = m.URL() + p.prefix
+	m.proxies[id] = p
+
+	if queued != nil {
+		queued.proxy = p
+		m.queue = append(m.queue, queued)
+		// room may have been made while the torrent was resolved
+		m.admitQueued()
+	} else if m.config.MaxActiveTorrents > 0 {
+		go m.releaseWhenDone(p)
+	}
+
+	return
+}
+
+// Admit queued torrents while there's room under Config.MaxActiveTorrents.  Called with lock held.
+func (m *ProxyManager) admitQueued() {
+	for len(m.queue) > 0 && m.active < m.config.MaxActiveTorrents {
+		queued := m.queue[0]
+		m.queue = m.queue[1:]
+
+		// removed while it waited
+		select {
+		case <-queued.proxy.closed:
+			continue
+		default:
+		}
+
+		log.Printf("Starting queued torrent %s", queued.proxy.infoHash())
+		m.active++
+		close(queued.admitted)
+		go m.releaseWhenDone(queued.proxy)
+	}
+}
+
+// Give up a place under Config.MaxActiveTorrents, admitting the next queued torrent.
+func (m *ProxyManager) release() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.active--
+	m.admitQueued()
+}
+
+// Block until an admitted torrent no longer counts against Config.MaxActiveTorrents, because
+// every piece is downloaded, it was refused, or it was closed, then release its place.
+func (m *ProxyManager) releaseWhenDone(p *TorrentProxy) {
+	defer m.release()
+
+	select {
+	case <-p.ready:
+	case <-p.refused:
+		return
+	case <-p.closed:
+		return
+	}
+
+	for {
+		complete, changed := p.completion.Complete()
+		if complete {
+			return
+		}
+
+		select {
+		case <-changed:
+		case <-p.closed:
+			return
+		}
+	}
+}
+
+// Return the proxy for a torrent by its infohash, as a hex string.
+func (m *ProxyManager) Get(id string) (p *TorrentProxy, ok bool) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	p, ok = m.proxies[strings.ToLower(id)]
+	return
+}
+
+// Return the proxy for a torrent by its infohash, or failing that its name.  If more than one
+// torrent has the name, the one with the lowest infohash is returned.
+func (m *ProxyManager) find(id string) (p *TorrentProxy, ok bool) {
+	if p, ok = m.Get(id); ok {
+		return
+	}
+
+	for _, p := range m.List() {
+		if p.torrent != nil && p.torrent.Name() == id {
+			return p, true
+		}
+	}
+
+	return nil, false
+}
+
+// Stop serving a torrent and drop it from the torrent client, deleting its data from DataDir
+// if the torrent's Config.DeleteDataOnRemove or EphemeralData is set.
+func (m *ProxyManager) Remove(id string) (err error) {
+	return m.remove(id, false)
+}
+
+// Stop serving a torrent and drop it from the torrent client, deleting its data from DataDir
+// whatever its Config says.
+func (m *ProxyManager) Purge(id string) (err error) {
+	return m.remove(id, true)
+}
+
+// Remove a torrent, deleting its data if purge or its Config.DeleteDataOnRemove is set.
+func (m *ProxyManager) remove(id string, purge bool) (err error) {
+	m.lock.Lock()
+	p, ok := m.proxies[strings.ToLower(id)]
+	delete(m.proxies, strings.ToLower(id))
+	m.lock.Unlock()
+
+	if !ok {
+		return fmt.Errorf("Not found: %s", id)
+	}
+
+	// the files are only known while the torrent is open, and only safe to delete once it's
+	// closed.  Close deletes them itself for EphemeralData.
+	var paths []string
+	if (purge || p.config.DeleteDataOnRemove) && !p.config.EphemeralData {
+		paths = p.dataPaths()
+	}
+
+	p.Close()
+
+	return removeDataPaths(p.config.DataDir, paths)
+}
+
+// Remove a torrent on DELETE, and its data too with ?purge=true.
+func (m *ProxyManager) serveRemove(w http.ResponseWriter, r *http.Request, p *TorrentProxy) {
+	if serveUnauthorized(w, r, roleAdmin, m.config.ReadToken, m.config.AdminToken, log.Printf) {
+		return
+	}
+
+	purge := r.URL.Query().Get("purge") == "true"
+
+	var err error
+	if purge {
+		err = m.Purge(p.infoHash())
+	} else {
+		err = m.Remove(p.infoHash())
+	}
+	if err != nil {
+		log.Printf("%d %s %s: %s", 500, r.Method, r.URL.Path, err)
+
+		writeError(w, r, 500, err.Error(), nil)
+		return
+	}
+
+	w.WriteHeader(204)
+
+	log.Printf("%d %s %s", 204, r.Method, r.URL.Path)
+}
+
+// Return every proxy, ordered by infohash.
+func (m *ProxyManager) List() (proxies []*TorrentProxy) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	ids := make([]string, 0, len(m.proxies))
+	for id := range m.proxies {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		proxies = append(proxies, m.proxies[id])
+	}
+
+	return
+}
+
+// Return the URL for the webserver, which is Config.PublicURL if it listens on a unix socket.
+func (m *ProxyManager) URL() string {
+	u, _ := listenURL(m.config.HTTPListenAddr, m.config.PublicURL)
+	return u
+}
+
+// Block until the webserver stops.
+//
+// If the HTTP server is disabled, this blocks forever.
+func (m *ProxyManager) Run() error {
+	return <-m.httperror
+}
+
+// Closes every proxy and the torrent client.
+func (m *ProxyManager) Close() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	for id, p := range m.proxies {
+		p.Close()
+		delete(m.proxies, id)
+	}
+
+	if m.server != nil {
+		m.server.Close()
+	}
+
+	m.closeOnce.Do(func() {
+		close(m.closed)
+
+		if m.client.DHT() != nil {
+			err := saveDHTNodes(m.client.DHT(), m.config.DataDir)
+			if err != nil {
+				log.Printf("Unable to save DHT nodes: %s", err)
+			}
+		}
+	})
+	m.client.Close()
+
+	if m.config.EphemeralData {
+		err := removePieceCompletion(m.config.DataDir)
+		if err != nil {
+			log.Printf("Unable to delete data: %s", err)
+		}
+	}
+}
+
+// Implement Handler interface for net/http.Serve().  The following URLs are supported:
+//
+//	/ - Return the TorrentStatus of every torrent as JSON
+//
+//	POST /torrents - Add the .torrent file in the body, either as it is or as a file in a
+//	multipart form, and return its TorrentStatus as JSON.  An admin request.
+//
+//	/{infohash}/... - Handled by the torrent's TorrentProxy, see TorrentProxy.ServeHTTP.
+//	DELETE /{infohash} removes the torrent, and with ?purge=true deletes its data from DataDir.
+//	The torrent's name works in place of its infohash, which is what links use.
+func (m *ProxyManager) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	r = stripBasePath(r, m.config.BasePath)
+
+	if r.URL.Path == "/" {
+		if serveUnauthorized(w, r, apiRole(r, "/"), m.config.ReadToken, m.config.AdminToken, log.Printf) {
+			return
+		}
+
+		statuses := make([]*TorrentStatus, 0)
+		for _, p := range m.List() {
+			statuses = append(statuses, p.Status())
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(statuses)
+
+		log.Printf("%d %s", 200, r.URL.Path)
+		return
+	}
+
+	if r.URL.Path == "/torrents" {
+		m.serveUpload(w, r)
+		return
+	}
+
+	id := strings.SplitN(r.URL.Path[1:], "/", 2)[0]
+	p, ok := m.find(id)
+	if !ok {
+		log.Printf("%d %s", 404, r.URL.Path)
+
+		writeError(w, r, 404, "Torrent Not Found", nil)
+		return
+	}
+
+	if r.Method == "DELETE" && (r.URL.Path == "/"+id || r.URL.Path == "/"+id+"/") {
+		m.serveRemove(w, r, p)
+		return
+	}
+
+	if r.URL.Path == "/"+id {
+		http.Redirect(w, r, m.config.BasePath+r.URL.Path+"/", http.StatusMovedPermanently)
+		return
+	}
+
+	http.StripPrefix("/"+id, p).ServeHTTP(w, r)
+}
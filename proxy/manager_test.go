@@ -0,0 +1,195 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ProxyManager", func() {
+	const (
+		hash   = "adecafcafeadecafcafeadecafcafeadecafcafe"
+		magnet = "magnet:?xt=urn:btih:" + hash
+	)
+
+	var (
+		err error
+		m   *ProxyManager
+	)
+
+	BeforeEach(func() {
+		m, err = NewProxyManager(&Config{
+			TorrentListenAddr: "localhost:0",
+		})
+
+		Expect(err).To(Succeed())
+	})
+
+	AfterEach(func() {
+		m.Close()
+	})
+
+	It("adds, finds, and removes torrents", func() {
+		p, err := m.Add(&Config{TorrentURL: magnet})
+		Expect(err).To(Succeed())
+
+		found, ok := m.Get(hash)
+		Expect(ok).To(BeTrue())
+		Expect(found).To(Equal(p))
+		Expect(m.List()).To(Equal([]*TorrentProxy{p}))
+
+		Expect(m.Remove(hash)).To(Succeed())
+		_, ok = m.Get(hash)
+		Expect(ok).To(BeFalse())
+		Expect(m.List()).To(BeEmpty())
+	})
+
+	It("rejects torrents that were already added", func() {
+		_, err := m.Add(&Config{TorrentURL: magnet})
+		Expect(err).To(Succeed())
+
+		_, err = m.Add(&Config{TorrentURL: magnet})
+		Expect(err).To(MatchError(ContainSubstring("Already added")))
+
+		// the first one is still being served
+		p, ok := m.Get(hash)
+		Expect(ok).To(BeTrue())
+		Expect(p.torrent).NotTo(BeNil())
+	})
+
+	It("queues torrents past MaxActiveTorrents until there's room", func() {
+		m.config.MaxActiveTorrents = 1
+		first, err := m.Add(&Config{TorrentURL: magnet})
+		Expect(err).To(Succeed())
+
+		const other = "bdecafcafeadecafcafeadecafcafeadecafcafe"
+		queued, err := m.Add(&Config{TorrentURL: "magnet:?xt=urn:btih:" + other})
+		Expect(err).To(Succeed())
+		Expect(first.Status().Status).To(Equal("pending"))
+		Expect(queued.Status().Status).To(Equal("queued"))
+		Expect(queued.Status().Hash).To(Equal(other))
+		Expect(queued.currentTorrent()).To(BeNil())
+
+		// it's found, and refused again, before it's in the torrent client
+		_, ok := m.Get(other)
+		Expect(ok).To(BeTrue())
+		_, err = m.Add(&Config{TorrentURL: "magnet:?xt=urn:btih:" + other})
+		Expect(err).To(MatchError(ContainSubstring("Already added")))
+
+		Expect(m.Remove(hash)).To(Succeed())
+		Eventually(queued.currentTorrent).ShouldNot(BeNil())
+		Expect(queued.Status().Status).To(Equal("pending"))
+	})
+
+	It("returns errors for bad torrents", func() {
+		_, err := m.Add(&Config{TorrentURL: "unknown://protocol/here"})
+		Expect(err).To(MatchError(ContainSubstring("Invalid torrent")))
+	})
+
+	It("links to hosted files under BasePath and the infohash", func() {
+		m.config.BasePath = "/torrent"
+		p, err := m.Add(&Config{TorrentURL: "testdata/sample.torrent"})
+		Expect(err).To(Succeed())
+		Eventually(p.Ready(), 10*time.Second).Should(BeClosed())
+
+		file := p.Status().Files[0]
+		id := p.torrent.InfoHash().HexString()
+		Expect(file.URL).To(HavePrefix("/torrent/" + id + "/"))
+		Expect(p.SignURL(file.Path, time.Minute).URL).To(Equal(m.URL() + file.URL))
+
+		// whether or not a reverse proxy strips BasePath
+		for _, u := range []string{m.URL() + file.URL, m.URL() + strings.TrimPrefix(file.URL, "/torrent")} {
+			resp, err := http.Head(u)
+			Expect(err).To(Succeed())
+			Expect(resp.StatusCode).To(Equal(200))
+			Expect(resp.ContentLength).To(Equal(file.Length))
+		}
+
+		client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		}}
+		dir := file.Path[:strings.Index(file.Path, "/")]
+		resp, _ := client.Get(p.URL() + "/" + dir)
+		resp.Body.Close()
+		Expect(resp.StatusCode).To(Equal(301))
+		Expect(resp.Header.Get("Location")).To(Equal("/torrent/" + id + "/" + dir + "/"))
+	})
+
+	It("serves every torrent's status", func() {
+		m.Add(&Config{TorrentURL: magnet})
+
+		resp, err := http.Get(m.URL())
+		Expect(err).To(Succeed())
+		defer resp.Body.Close()
+
+		var statuses []*TorrentStatus
+		json.NewDecoder(resp.Body).Decode(&statuses)
+
+		Expect(statuses).To(HaveLen(1))
+		Expect(statuses[0].Hash).To(Equal(hash))
+	})
+
+	It("routes requests to each torrent's proxy", func() {
+		p, _ := m.Add(&Config{TorrentURL: magnet})
+		Expect(p.URL()).To(Equal(m.URL() + "/" + hash))
+
+		// the metadata will never arrive, so the proxy asks us to come back later
+		resp, err := http.Get(p.URL() + "/some/file.mkv")
+		Expect(err).To(Succeed())
+		Expect(resp.StatusCode).To(Equal(503))
+
+		resp, err = http.Get(m.URL() + "/" + "0000000000000000000000000000000000000000/some/file.mkv")
+		Expect(err).To(Succeed())
+		Expect(resp.StatusCode).To(Equal(404))
+	})
+
+	It("routes requests by torrent name too", func() {
+		m.Add(&Config{TorrentURL: magnet + "&dn=Some%20Name"})
+
+		resp, err := http.Get(m.URL() + "/Some%20Name/")
+		Expect(err).To(Succeed())
+		defer resp.Body.Close()
+
+		var s TorrentStatus
+		json.NewDecoder(resp.Body).Decode(&s)
+		Expect(s.Hash).To(Equal(hash))
+	})
+
+	It("adds the torrents in TorrentURLs when it starts", func() {
+		other, err := NewProxyManager(&Config{
+			TorrentListenAddr: "localhost:0",
+			TorrentURLs:       []string{magnet, "magnet:?xt=urn:btih:0123456789abcdef0123456789abcdef01234567"},
+			SkipJunk:          true,
+		})
+		Expect(err).To(Succeed())
+		defer other.Close()
+
+		Expect(other.List()).To(HaveLen(2))
+		p, ok := other.Get(hash)
+		Expect(ok).To(BeTrue())
+		Expect(p.config.SkipJunk).To(BeTrue())
+		Expect(p.URL()).To(Equal(other.URL() + "/" + hash))
+	})
+
+	It("fails to start if one of TorrentURLs can't be added", func() {
+		failed, err := NewProxyManager(&Config{
+			TorrentListenAddr: "localhost:0",
+			TorrentURLs:       []string{magnet, "unknown://protocol/here"},
+		})
+		Expect(err).To(MatchError(ContainSubstring("unknown://protocol/here")))
+		Expect(failed).To(BeNil())
+	})
+
+	It("fails to start if its HTTP server can't listen", func() {
+		failed, err := NewProxyManager(&Config{
+			TorrentListenAddr: "localhost:0",
+			HTTPListenAddr:    m.config.HTTPListenAddr,
+		})
+		Expect(err).To(HaveOccurred())
+		Expect(failed).To(BeNil())
+	})
+})
@@ -0,0 +1,170 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os/exec"
+	"strconv"
+	"time"
+)
+
+// How long to wait for ffprobe to read the container headers from the swarm.
+const mediaInfoTimeout = 2 * time.Minute
+
+// A single audio, video or subtitle track in a media file
+type MediaTrack struct {
+	// The index of the stream in the container
+	Index int `json:"index"`
+	// "video", "audio", "subtitle", etc
+	Type string `json:"type"`
+	// The codec name as reported by ffprobe, e.g. "h264"
+	Codec string `json:"codec"`
+	// The language tag of the track, if any
+	Language string `json:"language,omitempty"`
+	// The title tag of the track, if any
+	Title string `json:"title,omitempty"`
+	// The number of audio channels
+	Channels int `json:"channels,omitempty"`
+	// The dimensions of video tracks
+	Width  int `json:"width,omitempty"`
+	Height int `json:"height,omitempty"`
+}
+
+// Track, codec and duration information for a media file in the torrent
+type MediaInfo struct {
+	// The path to the file
+	Path string `json:"path"`
+	// The container format as reported by ffprobe, e.g. "matroska,webm"
+	Format string `json:"format"`
+	// The duration in seconds
+	Duration float64 `json:"duration"`
+	// The tracks in the container
+	Tracks []*MediaTrack `json:"tracks"`
+}
+
+// The subset of `ffprobe -print_format json -show_format -show_streams` we care about.
+type ffprobeOutput struct {
+	Format struct {
+		FormatName string `json:"format_name"`
+		Duration   string `json:"duration"`
+	} `json:"format"`
+	Streams []struct {
+		Index     int               `json:"index"`
+		CodecType string            `json:"codec_type"`
+		CodecName string            `json:"codec_name"`
+		Channels  int               `json:"channels"`
+		Width     int               `json:"width"`
+		Height    int               `json:"height"`
+		Tags      map[string]string `json:"tags"`
+	} `json:"streams"`
+}
+
+// Return the media info for a file in the torrent, running ffprobe if it hasn't been probed yet.
+//
+// ffprobe reads the file through our own HTTP server so it can issue range requests for
+// the container headers, and those pieces are prioritized as it reads them.
+func (p *TorrentProxy) MediaInfo(path string) (info *MediaInfo, err error) {
+	p.mediaInfoLock.Lock()
+	info = p.mediaInfo[path]
+	p.mediaInfoLock.Unlock()
+
+	if info != nil {
+		return
+	}
+
+	file, ok := p.findFile(path)
+	if !ok {
+		return info, fmt.Errorf("File not found: %s", path)
+	}
+
+	p.configLock.RLock()
+	ffprobe := p.config.FFProbePath
+	p.configLock.RUnlock()
+	if len(ffprobe) == 0 {
+		ffprobe = "ffprobe"
+	}
+
+	ffprobe, err = exec.LookPath(ffprobe)
+	if err != nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), mediaInfoTimeout)
+	defer cancel()
+
+	// under BasePath, as a unix socket is only reached through the reverse proxy at PublicURL,
+	// and signed like any other link, or the request is refused when URLSigningKey is set
+	fileURL := p.serverURL() + p.link(filePath(file))
+	if query := p.signedQuery(filePath(file), time.Now().Add(mediaInfoTimeout)); len(query) > 0 {
+		fileURL += "?" + query
+	}
+	out, err := exec.CommandContext(ctx, ffprobe, "-v", "quiet", "-print_format", "json", "-show_format", "-show_streams", fileURL).Output()
+	if err != nil {
+		return info, fmt.Errorf("ffprobe failed: %s", err)
+	}
+
+	var probed ffprobeOutput
+	err = json.Unmarshal(out, &probed)
+	if err != nil {
+		return info, fmt.Errorf("Unable to parse ffprobe output: %s", err)
+	}
+
+	info = &MediaInfo{
+		Path:   path,
+		Format: probed.Format.FormatName,
+		Tracks: make([]*MediaTrack, 0),
+	}
+
+	// ffprobe reports N/A for streams without a known duration
+	info.Duration, _ = strconv.ParseFloat(probed.Format.Duration, 64)
+
+	for _, stream := range probed.Streams {
+		info.Tracks = append(info.Tracks, &MediaTrack{
+			Index:    stream.Index,
+			Type:     stream.CodecType,
+			Codec:    stream.CodecName,
+			Language: stream.Tags["language"],
+			Title:    stream.Tags["title"],
+			Channels: stream.Channels,
+			Width:    stream.Width,
+			Height:   stream.Height,
+		})
+	}
+
+	p.mediaInfoLock.Lock()
+	p.mediaInfo[path] = info
+	p.mediaInfoLock.Unlock()
+
+	return
+}
+
+// Serve the media info for a file as JSON.
+func (p *TorrentProxy) serveMediaInfo(w http.ResponseWriter, r *http.Request, path string) {
+	if _, ok := p.findFile(path); !ok {
+		p.errlog.Printf("%d %s", 404, r.URL.Path)
+
+		writeError(w, r, 404, "File Not Found", nil)
+		return
+	}
+
+	info, err := p.MediaInfo(path)
+	if err != nil {
+		code := 500
+		if _, ok := err.(*exec.Error); ok {
+			// ffprobe isn't installed
+			code = 501
+		}
+		p.errlog.Printf("%d %s: %s", code, r.URL.Path, err)
+
+		writeError(w, r, code, err.Error(), nil)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(info)
+
+	log.Printf("%d %s", 200, r.URL.Path)
+}
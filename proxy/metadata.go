@@ -0,0 +1,40 @@
+package proxy
+
+import (
+	"time"
+
+	"github.com/anacrolix/torrent"
+)
+
+// How the search for a magnet's metadata is going, while the torrent is pending.
+//
+// The torrent client doesn't say how much of the info dictionary has arrived, so what's been
+// received from peers, and from how many, stands in for it.
+type MetadataProgress struct {
+	// When the search started
+	Since time.Time `json:"since"`
+	// How long it has been going, in seconds
+	Seconds float64 `json:"seconds"`
+	// Peers connected, each of which is asked for the metadata
+	Peers int `json:"peers"`
+	// Peers known of, connected or not.  Zero after a while means nobody is sharing the torrent,
+	// or the trackers and DHT can't be reached.
+	KnownPeers int `json:"knownPeers"`
+	// Bytes received from peers, the metadata included
+	BytesReceived int64 `json:"bytesReceived"`
+}
+
+// Return how the search for the torrent's metadata is going, or nil if it has arrived.
+func (p *TorrentProxy) metadataProgress(stats torrent.TorrentStats) *MetadataProgress {
+	if p.torrent.Info() != nil {
+		return nil
+	}
+
+	return &MetadataProgress{
+		Since:         p.metadataSince,
+		Seconds:       time.Since(p.metadataSince).Seconds(),
+		Peers:         stats.ActivePeers,
+		KnownPeers:    stats.TotalPeers,
+		BytesReceived: stats.BytesRead,
+	}
+}
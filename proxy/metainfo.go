@@ -0,0 +1,57 @@
+package proxy
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+// Return where the metainfo of a torrent started from a magnet is kept, so restarts don't have
+// to wait for peers to send it again.
+func metainfoCacheFile(dataDir string, infoHash metainfo.Hash) string {
+	return filepath.Join(dataDir, ".metainfo-"+infoHash.HexString()+".torrent")
+}
+
+// Fill in the info of spec from the metainfo kept in dataDir, returning false if there is none.
+func loadCachedMetainfo(spec *torrent.TorrentSpec, dataDir string) (ok bool, err error) {
+	path := metainfoCacheFile(dataDir, spec.InfoHash)
+
+	mi, err := metainfo.LoadFromFile(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("Invalid metainfo in %s: %s", path, err)
+	}
+
+	// the info is only as good as its hash
+	if mi.HashInfoBytes() != spec.InfoHash {
+		return false, fmt.Errorf("Metainfo in %s is for %s", path, mi.HashInfoBytes().HexString())
+	}
+
+	spec.InfoBytes = mi.InfoBytes
+	return true, nil
+}
+
+// Write the metainfo to dataDir, replacing the old file only once the new one is complete.
+func saveCachedMetainfo(mi metainfo.MetaInfo, dataDir string) (err error) {
+	var buf bytes.Buffer
+	err = mi.Write(&buf)
+	if err != nil {
+		return
+	}
+
+	path := metainfoCacheFile(dataDir, mi.HashInfoBytes())
+	tmp := path + ".tmp"
+	err = ioutil.WriteFile(tmp, buf.Bytes(), 0644)
+	if err != nil {
+		return
+	}
+
+	return os.Rename(tmp, path)
+}
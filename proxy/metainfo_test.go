@@ -0,0 +1,70 @@
+package proxy
+
+import (
+	"io/ioutil"
+	"os"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+var _ = Describe("Caching metainfo", func() {
+	var (
+		dir string
+		mi  *metainfo.MetaInfo
+	)
+
+	BeforeEach(func() {
+		dir, _ = ioutil.TempDir("", "metainfo")
+		mi, _ = metainfo.LoadFromFile("testdata/sample.torrent")
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("fills in a magnet's info from the cache", func() {
+		spec := &torrent.TorrentSpec{InfoHash: mi.HashInfoBytes()}
+
+		ok, err := loadCachedMetainfo(spec, dir)
+		Expect(err).To(Succeed())
+		Expect(ok).To(BeFalse())
+
+		Expect(saveCachedMetainfo(*mi, dir)).To(Succeed())
+
+		ok, err = loadCachedMetainfo(spec, dir)
+		Expect(err).To(Succeed())
+		Expect(ok).To(BeTrue())
+		Expect(spec.InfoBytes).To(Equal([]byte(mi.InfoBytes)))
+	})
+
+	It("ignores metainfo for another torrent", func() {
+		other := metainfo.Hash{1}
+		data, _ := ioutil.ReadFile("testdata/sample.torrent")
+		ioutil.WriteFile(metainfoCacheFile(dir, other), data, 0644)
+
+		spec := &torrent.TorrentSpec{InfoHash: other}
+		ok, err := loadCachedMetainfo(spec, dir)
+		Expect(err).To(HaveOccurred())
+		Expect(ok).To(BeFalse())
+		Expect(spec.InfoBytes).To(BeNil())
+	})
+
+	It("starts a magnet without peers once its metainfo is cached", func() {
+		Expect(saveCachedMetainfo(*mi, dir)).To(Succeed())
+
+		p, err := NewTorrentProxy(&Config{
+			TorrentURL:        "magnet:?xt=urn:btih:" + mi.HashInfoBytes().HexString(),
+			TorrentListenAddr: "localhost:0",
+			DataDir:           dir,
+		})
+		Expect(err).To(Succeed())
+		defer p.Close()
+
+		Eventually(p.Ready(), 5*time.Second).Should(BeClosed())
+	})
+})
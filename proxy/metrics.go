@@ -0,0 +1,191 @@
+package proxy
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// How many recent samples latency percentiles are computed over.
+const latencySamples = 1024
+
+// The quantiles reported for latencies.
+var latencyQuantiles = []float64{0.5, 0.9, 0.99}
+
+// Upper bounds, in bytes, of the histogram buckets for the offsets clients abort at.
+var abortOffsetBuckets = [...]int64{1 << 20, 16 << 20, 128 << 20, 1 << 30, 8 << 30}
+
+// Keeps a window of recent latencies for computing percentiles, plus running totals.
+type latencyTracker struct {
+	lock    sync.Mutex
+	samples []time.Duration
+	next    int
+	count   int64
+	sum     time.Duration
+}
+
+// Record a latency.
+func (t *latencyTracker) Observe(d time.Duration) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if len(t.samples) < latencySamples {
+		t.samples = append(t.samples, d)
+	} else {
+		t.samples[t.next] = d
+	}
+	t.next = (t.next + 1) % latencySamples
+
+	t.count++
+	t.sum += d
+}
+
+// Return the latency at each quantile of the recent samples, along with the running count and sum.
+func (t *latencyTracker) Snapshot(quantiles []float64) (values []time.Duration, count int64, sum time.Duration) {
+	t.lock.Lock()
+	sorted := make([]time.Duration, len(t.samples))
+	copy(sorted, t.samples)
+	count, sum = t.count, t.sum
+	t.lock.Unlock()
+
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	values = make([]time.Duration, len(quantiles))
+	if len(sorted) == 0 {
+		return
+	}
+
+	for i, q := range quantiles {
+		values[i] = sorted[int(q*float64(len(sorted)-1))]
+	}
+
+	return
+}
+
+// Counters and latencies exposed at /metrics.
+type metrics struct {
+	firstByte latencyTracker
+
+	lock        sync.Mutex
+	sloBreaches int64
+
+	// file responses that were sent in full, and that the client went away in the middle of
+	completed    int64
+	aborted      int64
+	abortedBytes int64
+	// counts of aborts at offsets up to each of abortOffsetBuckets, and past the last
+	abortOffsets [len(abortOffsetBuckets) + 1]int64
+}
+
+// Wraps a ResponseWriter to report how long it took to send the first byte of content.
+type firstByteWriter struct {
+	http.ResponseWriter
+	start       time.Time
+	code        int
+	done        bool
+	onFirstByte func(d time.Duration)
+	// bytes of content written
+	written int64
+}
+
+func (w *firstByteWriter) WriteHeader(code int) {
+	w.code = code
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *firstByteWriter) Write(b []byte) (int, error) {
+	// only content counts, not error pages
+	if !w.done && len(b) > 0 && (w.code == 0 || w.code == 200 || w.code == 206) {
+		w.done = true
+		w.onFirstByte(time.Since(w.start))
+	}
+
+	n, err := w.ResponseWriter.Write(b)
+	w.written += int64(n)
+	return n, err
+}
+
+// Record the first byte latency of a request, and complain if it was slower than the SLO.
+func (p *TorrentProxy) observeFirstByte(r *http.Request, d time.Duration) {
+	p.metrics.firstByte.Observe(d)
+
+	if p.config.FirstByteSLO > 0 && d > p.config.FirstByteSLO {
+		p.metrics.lock.Lock()
+		p.metrics.sloBreaches++
+		p.metrics.lock.Unlock()
+
+		p.errlog.Printf("First byte SLO of %s breached: %s", p.config.FirstByteSLO, r.URL.Path)
+	}
+}
+
+// Record whether a file response was sent in full, or the client went away at offset after
+// being sent written bytes.
+func (p *TorrentProxy) observeResponse(aborted bool, offset int64, written int64) {
+	p.metrics.lock.Lock()
+	defer p.metrics.lock.Unlock()
+
+	if !aborted {
+		p.metrics.completed++
+		return
+	}
+
+	p.metrics.aborted++
+	p.metrics.abortedBytes += written
+
+	bucket := len(abortOffsetBuckets)
+	for i, le := range abortOffsetBuckets {
+		if offset <= le {
+			bucket = i
+			break
+		}
+	}
+	p.metrics.abortOffsets[bucket]++
+}
+
+// Serve metrics in the Prometheus text exposition format.
+func (p *TorrentProxy) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	values, count, sum := p.metrics.firstByte.Snapshot(latencyQuantiles)
+
+	fmt.Fprintln(w, "# HELP evaporation_first_byte_seconds Time from request arrival to the first byte of file content.")
+	fmt.Fprintln(w, "# TYPE evaporation_first_byte_seconds summary")
+	for i, q := range latencyQuantiles {
+		fmt.Fprintf(w, "evaporation_first_byte_seconds{quantile=\"%g\"} %g\n", q, values[i].Seconds())
+	}
+	fmt.Fprintf(w, "evaporation_first_byte_seconds_sum %g\n", sum.Seconds())
+	fmt.Fprintf(w, "evaporation_first_byte_seconds_count %d\n", count)
+
+	p.metrics.lock.Lock()
+	breaches := p.metrics.sloBreaches
+	completed, aborted, abortedBytes, abortOffsets := p.metrics.completed, p.metrics.aborted, p.metrics.abortedBytes, p.metrics.abortOffsets
+	p.metrics.lock.Unlock()
+
+	fmt.Fprintln(w, "# HELP evaporation_first_byte_slo_breaches_total Requests whose first byte was slower than Config.FirstByteSLO.")
+	fmt.Fprintln(w, "# TYPE evaporation_first_byte_slo_breaches_total counter")
+	fmt.Fprintf(w, "evaporation_first_byte_slo_breaches_total %d\n", breaches)
+
+	fmt.Fprintln(w, "# HELP evaporation_responses_total File responses, by whether they were completed or aborted by the client.")
+	fmt.Fprintln(w, "# TYPE evaporation_responses_total counter")
+	fmt.Fprintf(w, "evaporation_responses_total{result=\"completed\"} %d\n", completed)
+	fmt.Fprintf(w, "evaporation_responses_total{result=\"aborted\"} %d\n", aborted)
+
+	fmt.Fprintln(w, "# HELP evaporation_aborted_response_bytes_total Bytes sent in responses the client aborted.")
+	fmt.Fprintln(w, "# TYPE evaporation_aborted_response_bytes_total counter")
+	fmt.Fprintf(w, "evaporation_aborted_response_bytes_total %d\n", abortedBytes)
+
+	fmt.Fprintln(w, "# HELP evaporation_abort_offset_bytes The offset into the file at which clients aborted responses.")
+	fmt.Fprintln(w, "# TYPE evaporation_abort_offset_bytes histogram")
+	var cumulative int64
+	for i, le := range abortOffsetBuckets {
+		cumulative += abortOffsets[i]
+		fmt.Fprintf(w, "evaporation_abort_offset_bytes_bucket{le=\"%d\"} %d\n", le, cumulative)
+	}
+	fmt.Fprintf(w, "evaporation_abort_offset_bytes_bucket{le=\"+Inf\"} %d\n", aborted)
+	fmt.Fprintf(w, "evaporation_abort_offset_bytes_count %d\n", aborted)
+
+	log.Printf("%d %s", 200, r.URL.Path)
+}
@@ -0,0 +1,124 @@
+package proxy
+
+import (
+	"net/http/httptest"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Metrics", func() {
+	Describe("latencyTracker", func() {
+		It("reports zero with no samples", func() {
+			var t latencyTracker
+
+			values, count, _ := t.Snapshot(latencyQuantiles)
+			Expect(count).To(Equal(int64(0)))
+			Expect(values).To(Equal([]time.Duration{0, 0, 0}))
+		})
+
+		It("computes percentiles over recent samples", func() {
+			var t latencyTracker
+
+			for i := 1; i <= 100; i++ {
+				t.Observe(time.Duration(i) * time.Millisecond)
+			}
+
+			values, count, sum := t.Snapshot([]float64{0, 0.5, 1})
+			Expect(count).To(Equal(int64(100)))
+			Expect(sum).To(Equal(5050 * time.Millisecond))
+			Expect(values).To(Equal([]time.Duration{time.Millisecond, 50 * time.Millisecond, 100 * time.Millisecond}))
+		})
+
+		It("only keeps a window of samples", func() {
+			var t latencyTracker
+
+			for i := 0; i < latencySamples*2; i++ {
+				t.Observe(time.Second)
+			}
+
+			Expect(t.samples).To(HaveLen(latencySamples))
+		})
+	})
+
+	Describe("firstByteWriter", func() {
+		var (
+			rec      *httptest.ResponseRecorder
+			observed []time.Duration
+			w        *firstByteWriter
+		)
+
+		BeforeEach(func() {
+			rec = httptest.NewRecorder()
+			observed = nil
+			w = &firstByteWriter{
+				ResponseWriter: rec,
+				start:          time.Now(),
+				onFirstByte: func(d time.Duration) {
+					observed = append(observed, d)
+				},
+			}
+		})
+
+		It("reports the first byte of content once", func() {
+			w.WriteHeader(206)
+			w.Write([]byte("hello"))
+			w.Write([]byte("world"))
+
+			Expect(observed).To(HaveLen(1))
+		})
+
+		It("ignores error responses", func() {
+			w.WriteHeader(416)
+			w.Write([]byte("bad range"))
+
+			Expect(observed).To(BeEmpty())
+		})
+
+		It("counts the bytes written", func() {
+			w.Write([]byte("hello"))
+			w.Write([]byte("world"))
+
+			Expect(w.written).To(Equal(int64(10)))
+		})
+	})
+
+	Describe("Abort statistics", func() {
+		var p *TorrentProxy
+
+		BeforeEach(func() {
+			p = &TorrentProxy{config: &Config{}, metrics: &metrics{}}
+		})
+
+		It("counts completed and aborted responses", func() {
+			p.observeResponse(false, 100, 100)
+			p.observeResponse(true, 10, 10)
+			p.observeResponse(true, 20<<20, 4<<20)
+
+			Expect(p.metrics.completed).To(Equal(int64(1)))
+			Expect(p.metrics.aborted).To(Equal(int64(2)))
+			Expect(p.metrics.abortedBytes).To(Equal(int64(4<<20 + 10)))
+		})
+
+		It("buckets the offsets clients abort at", func() {
+			p.observeResponse(true, 10, 10)
+			p.observeResponse(true, 20<<20, 0)
+			p.observeResponse(true, 100<<30, 0)
+
+			Expect(p.metrics.abortOffsets).To(Equal([len(abortOffsetBuckets) + 1]int64{1, 0, 1, 0, 0, 1}))
+		})
+
+		It("exposes them as cumulative histogram buckets", func() {
+			p.observeResponse(true, 10, 10)
+			p.observeResponse(true, 20<<20, 0)
+
+			rec := httptest.NewRecorder()
+			p.serveMetrics(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+			Expect(rec.Body.String()).To(ContainSubstring(`evaporation_responses_total{result="aborted"} 2`))
+			Expect(rec.Body.String()).To(ContainSubstring(`evaporation_abort_offset_bytes_bucket{le="1048576"} 1`))
+			Expect(rec.Body.String()).To(ContainSubstring(`evaporation_abort_offset_bytes_bucket{le="134217728"} 2`))
+		})
+	})
+})
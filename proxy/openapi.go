@@ -0,0 +1,231 @@
+package proxy
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Where the JSON API is served.  The same endpoints are served at their original paths too.
+const apiPrefix = "/api/v1"
+
+// The JSON endpoints under apiPrefix, mapped to the paths they've always been served at.
+var apiRoutes = map[string]string{
+	"/status":              "/",
+	"/etags":               "/etags",
+	"/files":               "/files",
+	"/verify":              "/verify",
+	"/trackers":            "/trackers",
+	"/pieces":              "/pieces",
+	"/stats/history":       "/stats/history",
+	"/links":               "/f",
+	"/admin/config":        "/admin/config",
+	"/admin/sign":          "/admin/sign",
+	"/admin/dht/bootstrap": "/admin/dht/bootstrap",
+	"/debug/readers":       "/debug/readers",
+}
+
+// Return the original path of an API path, without apiPrefix, or false if it isn't one.
+func apiPath(path string) (legacy string, ok bool) {
+	if legacy, ok = apiRoutes[path]; ok {
+		return
+	}
+
+	if strings.HasPrefix(path, "/files/") && strings.HasSuffix(path, "/mediainfo") {
+		return path, true
+	}
+
+	return
+}
+
+// An operation in the OpenAPI document.
+type apiOperation struct {
+	path    string
+	method  string
+	summary string
+	// the parameters in the query string
+	query []string
+	// the JSON request body, or nil for none
+	request interface{}
+	// the JSON response
+	response interface{}
+	// the status of a successful response, if not 200
+	status int
+	// only served if Config.AdminAPI is true, and only here if there's no AdminListenAddr
+	admin bool
+}
+
+// Every operation of the JSON API.  The schemas of requests and responses are generated from their types.
+var apiOperations = []apiOperation{
+	{path: "/status", method: "get", summary: "The status of the torrent and each of its files, once it reaches the state in wait", query: []string{"wait", "timeout"}, response: TorrentStatus{}},
+	{path: "/etags", method: "get", summary: "The URL and ETag of each file", query: []string{"prefix", "complete"}, response: []FileETag{}},
+	{path: "/files", method: "patch", summary: "Change the priority of files", request: []FilePriority{}, response: TorrentStatus{}},
+	{path: "/files/{path}/mediainfo", method: "get", summary: "Tracks, codecs and duration of a media file", response: MediaInfo{}},
+	{path: "/verify", method: "get", summary: "The progress of the last check of the data against the piece hashes", response: VerifyStatus{}},
+	{path: "/verify", method: "post", summary: "Check the data against the piece hashes in the background", response: VerifyStatus{}, status: 202},
+	{path: "/trackers", method: "get", summary: "What each tracker says about the swarm, from a scrape", response: []TrackerStatus{}},
+	{path: "/pieces", method: "get", summary: "Which pieces are complete, as a bitfield", response: PieceMap{}},
+	{path: "/stats/history", method: "get", summary: "Download and upload rates over the last window", query: []string{"window"}, response: []RateSample{}},
+	{path: "/links", method: "get", summary: "Every short link", response: []ShortLink{}},
+	{path: "/links", method: "post", summary: "The short link for a file, minted if needed", request: struct {
+		Path string `json:"path"`
+	}{}, response: ShortLink{}},
+	{path: "/admin/config", method: "get", summary: "The configuration, with secrets redacted", response: Config{}, admin: true},
+	{path: "/admin/config", method: "put", summary: "Change the configuration that can be changed while running", request: Config{}, response: Config{}, admin: true},
+	{path: "/admin/sign", method: "get", summary: "A link to a file that expires, signed with URLSigningKey", query: []string{"path", "ttl"}, response: SignedURL{}, admin: true},
+	{path: "/admin/dht/bootstrap", method: "post", summary: "Bootstrap the DHT again, after adding nodes", request: struct {
+		Nodes []string `json:"nodes"`
+	}{}, response: DHTBootstrap{}, admin: true},
+	{path: "/debug/readers", method: "get", summary: "What every active request is reading", response: []ReaderInfo{}, admin: true},
+}
+
+// Return a JSON schema for t, adding the schemas of named structs to components and referring to them.
+func apiSchema(t reflect.Type, components map[string]interface{}) map[string]interface{} {
+	switch t {
+	case reflect.TypeOf(time.Time{}):
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case reflect.TypeOf(time.Duration(0)):
+		return map[string]interface{}{"type": "integer", "format": "int64", "description": "nanoseconds"}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		return apiSchema(t.Elem(), components)
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Int64, reflect.Uint64:
+		return map[string]interface{}{"type": "integer", "format": "int64"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": apiSchema(t.Elem(), components)}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": apiSchema(t.Elem(), components)}
+	case reflect.Struct:
+		if len(t.Name()) > 0 {
+			if _, ok := components[t.Name()]; !ok {
+				// reserve the name first, in case the struct refers to itself
+				components[t.Name()] = nil
+				components[t.Name()] = apiStructSchema(t, components)
+			}
+
+			return map[string]interface{}{"$ref": "#/components/schemas/" + t.Name()}
+		}
+
+		return apiStructSchema(t, components)
+	}
+
+	// funcs and channels aren't sent
+	return nil
+}
+
+// Return a JSON schema for the fields of a struct, named as encoding/json names them.
+func apiStructSchema(t reflect.Type, components map[string]interface{}) map[string]interface{} {
+	properties := make(map[string]interface{})
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if len(field.PkgPath) > 0 {
+			continue
+		}
+
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if len(name) == 0 {
+			name = field.Name
+		}
+
+		if schema := apiSchema(field.Type, components); schema != nil {
+			properties[name] = schema
+		}
+	}
+
+	return map[string]interface{}{"type": "object", "properties": properties}
+}
+
+// Return the OpenAPI 3 document describing the JSON API as served by this proxy.
+func (p *TorrentProxy) openAPI() map[string]interface{} {
+	components := make(map[string]interface{})
+	paths := make(map[string]map[string]interface{})
+
+	for _, op := range apiOperations {
+		if op.admin && (!p.config.AdminAPI || len(p.config.AdminListenAddr) > 0) {
+			continue
+		}
+
+		status := op.status
+		if status == 0 {
+			status = 200
+		}
+
+		operation := map[string]interface{}{
+			"summary": op.summary,
+			"responses": map[string]interface{}{
+				strconv.Itoa(status): map[string]interface{}{
+					"description": http.StatusText(status),
+					"content": map[string]interface{}{
+						"application/json": map[string]interface{}{"schema": apiSchema(reflect.TypeOf(op.response), components)},
+					},
+				},
+			},
+		}
+
+		var parameters []interface{}
+		if strings.Contains(op.path, "{path}") {
+			parameters = append(parameters, map[string]interface{}{
+				"name": "path", "in": "path", "required": true, "schema": map[string]interface{}{"type": "string"},
+			})
+		}
+		for _, name := range op.query {
+			parameters = append(parameters, map[string]interface{}{
+				"name": name, "in": "query", "schema": map[string]interface{}{"type": "string"},
+			})
+		}
+		if len(parameters) > 0 {
+			operation["parameters"] = parameters
+		}
+
+		if op.request != nil {
+			operation["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content": map[string]interface{}{
+					"application/json": map[string]interface{}{"schema": apiSchema(reflect.TypeOf(op.request), components)},
+				},
+			}
+		}
+
+		if paths[op.path] == nil {
+			paths[op.path] = make(map[string]interface{})
+		}
+		paths[op.path][op.method] = operation
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.0",
+		"info": map[string]interface{}{
+			"title":   "evaporation",
+			"version": "1",
+		},
+		"servers":    []interface{}{map[string]interface{}{"url": p.absoluteURL(apiPrefix)}},
+		"paths":      paths,
+		"components": map[string]interface{}{"schemas": components},
+	}
+}
+
+// Serve the OpenAPI document as JSON.
+func (p *TorrentProxy) serveOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(p.openAPI())
+
+	log.Printf("%d %s", 200, r.URL.Path)
+}
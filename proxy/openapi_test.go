@@ -0,0 +1,53 @@
+package proxy
+
+import (
+	"reflect"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("OpenAPI", func() {
+	It("maps API paths to their original paths", func() {
+		for path, legacy := range map[string]string{
+			"/status":                  "/",
+			"/links":                   "/f",
+			"/etags":                   "/etags",
+			"/files/a/b.mkv/mediainfo": "/files/a/b.mkv/mediainfo",
+		} {
+			mapped, ok := apiPath(path)
+			Expect(ok).To(BeTrue(), path)
+			Expect(mapped).To(Equal(legacy))
+		}
+
+		_, ok := apiPath("/a/b.mkv")
+		Expect(ok).To(BeFalse())
+	})
+
+	It("generates schemas from types", func() {
+		components := make(map[string]interface{})
+
+		schema := apiSchema(reflect.TypeOf([]*ReaderInfo{}), components)
+
+		Expect(schema).To(Equal(map[string]interface{}{
+			"type":  "array",
+			"items": map[string]interface{}{"$ref": "#/components/schemas/ReaderInfo"},
+		}))
+
+		properties := components["ReaderInfo"].(map[string]interface{})["properties"].(map[string]interface{})
+		Expect(properties["client"]).To(Equal(map[string]interface{}{"type": "string"}))
+		Expect(properties["started"]).To(Equal(apiSchema(reflect.TypeOf(time.Time{}), nil)))
+		Expect(properties["readahead"]).To(Equal(map[string]interface{}{"type": "integer", "format": "int64"}))
+	})
+
+	It("leaves out fields that aren't sent", func() {
+		components := make(map[string]interface{})
+		apiSchema(reflect.TypeOf(Config{}), components)
+
+		properties := components["Config"].(map[string]interface{})["properties"].(map[string]interface{})
+		Expect(properties).To(HaveKey("TorrentURL"))
+		Expect(properties).NotTo(HaveKey("Resolver"))
+		Expect(properties).NotTo(HaveKey("OnDegraded"))
+	})
+})
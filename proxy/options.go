@@ -0,0 +1,114 @@
+package proxy
+
+// Changes the Config of a proxy created with NewTorrentProxyWithOptions.
+type Option func(*Config)
+
+// Create an instance of the proxy for torrentURL, configured by opts, as NewTorrentProxy would
+// with a Config.  The config is checked with Config.Validate first, so every problem with it is
+// returned at once, as ConfigErrors.
+//
+// Options are applied in order, so a later one wins over an earlier one that sets the same thing.
+func NewTorrentProxyWithOptions(torrentURL string, opts ...Option) (*TorrentProxy, error) {
+	config := &Config{TorrentURL: torrentURL}
+	for _, opt := range opts {
+		opt(config)
+	}
+
+	err := config.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	return NewTorrentProxy(config)
+}
+
+// Change any of the Config, for what there's no Option for.
+func WithConfig(change func(config *Config)) Option {
+	return change
+}
+
+// Set Config.DataDir.
+func WithDataDir(dir string) Option {
+	return func(config *Config) {
+		config.DataDir = dir
+	}
+}
+
+// Set Config.HTTPListenAddr.
+func WithHTTPListenAddr(addr string) Option {
+	return func(config *Config) {
+		config.HTTPListenAddr = addr
+	}
+}
+
+// Set Config.TorrentListenAddr.
+func WithTorrentListenAddr(addr string) Option {
+	return func(config *Config) {
+		config.TorrentListenAddr = addr
+	}
+}
+
+// Set Config.DHTListenAddr.
+func WithDHTListenAddr(addr string) Option {
+	return func(config *Config) {
+		config.DHTListenAddr = addr
+	}
+}
+
+// Add to Config.DHTNodes.
+func WithDHTNodes(nodes ...string) Option {
+	return func(config *Config) {
+		config.DHTNodes = append(config.DHTNodes, nodes...)
+	}
+}
+
+// Set Config.ReadToken and Config.AdminToken.
+func WithTokens(readToken, adminToken string) Option {
+	return func(config *Config) {
+		config.ReadToken = readToken
+		config.AdminToken = adminToken
+	}
+}
+
+// Set Config.MemoryLimit and Config.Readahead.
+func WithMemory(limit, readahead int64) Option {
+	return func(config *Config) {
+		config.MemoryLimit = limit
+		config.Readahead = readahead
+	}
+}
+
+// Set Config.DataKey.
+func WithDataKey(key string) Option {
+	return func(config *Config) {
+		config.DataKey = key
+	}
+}
+
+// Set Config.Async, to return before the torrent URL is resolved.
+func WithAsync() Option {
+	return func(config *Config) {
+		config.Async = true
+	}
+}
+
+// Set Config.Stream, to download files in order from where they're read.
+func WithStream() Option {
+	return func(config *Config) {
+		config.Stream = true
+	}
+}
+
+// Set Config.SkipJunk, to not download samples, proofs and other extras.
+func WithSkipJunk() Option {
+	return func(config *Config) {
+		config.SkipJunk = true
+	}
+}
+
+// Set Config.Offline, to serve what's in DataDir without any network activity.
+func WithOffline() Option {
+	return func(config *Config) {
+		config.Offline = true
+	}
+}
@@ -0,0 +1,173 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/anacrolix/torrent"
+)
+
+// How the torrent client connects to peers, see Config.PeerTransport.
+const (
+	// Connect over both TCP and uTP.  This is the default.
+	TransportBoth = "both"
+	// Only connect over TCP.
+	TransportTCP = "tcp"
+	// Only connect over uTP, which backs off when other traffic needs the link.
+	TransportUTP = "utp"
+)
+
+// Whether connections to peers are encrypted with MSE, see Config.Encryption.
+const (
+	// Never encrypt.
+	EncryptionDisabled = "disabled"
+	// Encrypt when peers support it, and fall back to plain connections when they don't.
+	// This is the default.
+	EncryptionPreferred = "preferred"
+	// Only talk to peers that encrypt, for networks that throttle plain BitTorrent.
+	EncryptionRequired = "required"
+)
+
+// Which IP versions to reach peers over, see Config.PeerIPVersion.
+const (
+	// Use both IPv4 and IPv6.  This is the default.
+	IPBoth = "both"
+	// Only use IPv4.
+	IPv4Only = "ipv4"
+	// Only use IPv6.
+	IPv6Only = "ipv6"
+)
+
+// Set how the torrent client connects to peers, as configured.
+func setPeerPolicy(tc *torrent.Config, config *Config) error {
+	switch config.PeerTransport {
+	case TransportBoth:
+	case TransportTCP:
+		tc.DisableUTP = true
+	case TransportUTP:
+		tc.DisableTCP = true
+	default:
+		return fmt.Errorf("Invalid PeerTransport: %q", config.PeerTransport)
+	}
+
+	switch config.Encryption {
+	case EncryptionDisabled:
+		tc.EncryptionPolicy.DisableEncryption = true
+	case EncryptionPreferred:
+	case EncryptionRequired:
+		tc.EncryptionPolicy.ForceEncryption = true
+	default:
+		return fmt.Errorf("Invalid Encryption: %q", config.Encryption)
+	}
+
+	switch config.PeerIPVersion {
+	case IPBoth, IPv6Only:
+	case IPv4Only:
+		tc.DisableIPv6 = true
+	default:
+		return fmt.Errorf("Invalid PeerIPVersion: %q", config.PeerIPVersion)
+	}
+
+	if config.MaxPeers > 0 {
+		tc.EstablishedConnsPerTorrent = config.MaxPeers
+	}
+	if config.MaxHalfOpen > 0 {
+		tc.HalfOpenConnsPerTorrent = config.MaxHalfOpen
+	}
+
+	return nil
+}
+
+// Return the addresses the torrent client and the DHT should listen on, bound to
+// config.PeerInterface and restricted to config.PeerIPVersion.
+func peerListenAddrs(config *Config) (listenAddr, dhtListenAddr string, err error) {
+	var ip net.IP
+	if len(config.PeerInterface) > 0 {
+		ip, err = interfaceIP(config.PeerInterface, config.PeerIPVersion)
+		if err != nil {
+			return
+		}
+	}
+
+	listenAddr, err = bindAddr(config.TorrentListenAddr, ip, config.PeerIPVersion)
+	if err != nil {
+		return "", "", fmt.Errorf("Invalid TorrentListenAddr: %s", err)
+	}
+
+	if len(config.DHTListenAddr) > 0 {
+		dhtListenAddr, err = bindAddr(config.DHTListenAddr, ip, config.PeerIPVersion)
+		if err != nil {
+			return "", "", fmt.Errorf("Invalid DHTListenAddr: %s", err)
+		}
+	}
+
+	return
+}
+
+// Return addr with its host replaced by ip, if set, after checking it is of the given IP version.
+func bindAddr(addr string, ip net.IP, version string) (string, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", err
+	}
+
+	if ip != nil {
+		host = ip.String()
+	}
+
+	switch version {
+	case IPv4Only:
+		if len(host) == 0 {
+			host = "0.0.0.0"
+		}
+		if ip := net.ParseIP(host); ip == nil || ip.To4() == nil {
+			return "", fmt.Errorf("%s is not an IPv4 address", host)
+		}
+	case IPv6Only:
+		// the unspecified address also accepts IPv4 on most systems, so insist on a real one
+		if ip := net.ParseIP(host); ip == nil || ip.To4() != nil || ip.IsUnspecified() {
+			return "", fmt.Errorf("%s is not an IPv6 address, which IPv6Only needs, or set PeerInterface", host)
+		}
+	}
+
+	return net.JoinHostPort(host, port), nil
+}
+
+// Return the address of the named interface to bind to, preferring IPv4 unless version is IPv6Only.
+func interfaceIP(name, version string) (net.IP, error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid PeerInterface: %s", err)
+	}
+
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read the addresses of %s: %s", name, err)
+	}
+
+	var v4, v6 net.IP
+	for _, addr := range addrs {
+		ipnet, ok := addr.(*net.IPNet)
+		// link-local IPv6 addresses can't be bound without a zone
+		if !ok || ipnet.IP.IsLinkLocalUnicast() {
+			continue
+		}
+
+		if ipnet.IP.To4() != nil {
+			if v4 == nil {
+				v4 = ipnet.IP
+			}
+		} else if v6 == nil {
+			v6 = ipnet.IP
+		}
+	}
+
+	switch {
+	case version != IPv6Only && v4 != nil:
+		return v4, nil
+	case version != IPv4Only && v6 != nil:
+		return v6, nil
+	}
+
+	return nil, fmt.Errorf("%s has no address to bind to", name)
+}
@@ -0,0 +1,114 @@
+package proxy
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/anacrolix/torrent"
+)
+
+var _ = Describe("Peer policy", func() {
+	var tc *torrent.Config
+
+	BeforeEach(func() {
+		tc = &torrent.Config{}
+	})
+
+	It("uses every transport and prefers encryption by default", func() {
+		config := &Config{}
+		applyConfigDefaults(config)
+
+		Expect(setPeerPolicy(tc, config)).To(Succeed())
+		Expect(tc.DisableTCP).To(BeFalse())
+		Expect(tc.DisableUTP).To(BeFalse())
+		Expect(tc.EncryptionPolicy.DisableEncryption).To(BeFalse())
+		Expect(tc.EncryptionPolicy.ForceEncryption).To(BeFalse())
+	})
+
+	It("restricts transports", func() {
+		Expect(setPeerPolicy(tc, &Config{PeerTransport: TransportTCP, Encryption: EncryptionPreferred, PeerIPVersion: IPBoth})).To(Succeed())
+		Expect(tc.DisableUTP).To(BeTrue())
+
+		tc = &torrent.Config{}
+		Expect(setPeerPolicy(tc, &Config{PeerTransport: TransportUTP, Encryption: EncryptionPreferred, PeerIPVersion: IPBoth})).To(Succeed())
+		Expect(tc.DisableTCP).To(BeTrue())
+	})
+
+	It("requires or disables encryption", func() {
+		Expect(setPeerPolicy(tc, &Config{PeerTransport: TransportBoth, Encryption: EncryptionRequired, PeerIPVersion: IPBoth})).To(Succeed())
+		Expect(tc.EncryptionPolicy.ForceEncryption).To(BeTrue())
+
+		tc = &torrent.Config{}
+		Expect(setPeerPolicy(tc, &Config{PeerTransport: TransportBoth, Encryption: EncryptionDisabled, PeerIPVersion: IPBoth})).To(Succeed())
+		Expect(tc.EncryptionPolicy.DisableEncryption).To(BeTrue())
+	})
+
+	It("rejects unknown values", func() {
+		Expect(setPeerPolicy(tc, &Config{PeerTransport: "carrier-pigeon", Encryption: EncryptionPreferred, PeerIPVersion: IPBoth})).To(MatchError(ContainSubstring("PeerTransport")))
+		Expect(setPeerPolicy(tc, &Config{PeerTransport: TransportBoth, Encryption: "sometimes", PeerIPVersion: IPBoth})).To(MatchError(ContainSubstring("Encryption")))
+		Expect(setPeerPolicy(tc, &Config{PeerTransport: TransportBoth, Encryption: EncryptionPreferred, PeerIPVersion: "ipv5"})).To(MatchError(ContainSubstring("PeerIPVersion")))
+	})
+
+	It("disables IPv6 for IPv4Only", func() {
+		Expect(setPeerPolicy(tc, &Config{PeerTransport: TransportBoth, Encryption: EncryptionPreferred, PeerIPVersion: IPv4Only})).To(Succeed())
+		Expect(tc.DisableIPv6).To(BeTrue())
+	})
+
+	It("limits connections per torrent", func() {
+		Expect(setPeerPolicy(tc, &Config{PeerTransport: TransportBoth, Encryption: EncryptionPreferred, PeerIPVersion: IPBoth, MaxPeers: 20, MaxHalfOpen: 5})).To(Succeed())
+		Expect(tc.EstablishedConnsPerTorrent).To(Equal(20))
+		Expect(tc.HalfOpenConnsPerTorrent).To(Equal(5))
+	})
+
+	Describe("Listen addresses", func() {
+		It("leaves them alone by default", func() {
+			config := &Config{TorrentListenAddr: ":6881"}
+			applyConfigDefaults(config)
+
+			listenAddr, dhtListenAddr, err := peerListenAddrs(config)
+			Expect(err).To(Succeed())
+			Expect(listenAddr).To(Equal(":6881"))
+			Expect(dhtListenAddr).To(BeEmpty())
+		})
+
+		It("binds to the address of PeerInterface", func() {
+			config := &Config{TorrentListenAddr: ":6881", DHTListenAddr: ":6882", PeerInterface: "lo"}
+			applyConfigDefaults(config)
+
+			listenAddr, dhtListenAddr, err := peerListenAddrs(config)
+			Expect(err).To(Succeed())
+			Expect(listenAddr).To(Equal("127.0.0.1:6881"))
+			Expect(dhtListenAddr).To(Equal("127.0.0.1:6882"))
+		})
+
+		It("rejects unknown interfaces", func() {
+			config := &Config{PeerInterface: "nonexistent0"}
+			applyConfigDefaults(config)
+
+			_, _, err := peerListenAddrs(config)
+			Expect(err).To(MatchError(ContainSubstring("PeerInterface")))
+		})
+
+		It("binds all IPv4 addresses for IPv4Only", func() {
+			config := &Config{TorrentListenAddr: ":6881", PeerIPVersion: IPv4Only}
+			applyConfigDefaults(config)
+
+			listenAddr, _, err := peerListenAddrs(config)
+			Expect(err).To(Succeed())
+			Expect(listenAddr).To(Equal("0.0.0.0:6881"))
+		})
+
+		It("needs a real IPv6 address for IPv6Only", func() {
+			config := &Config{TorrentListenAddr: ":6881", PeerIPVersion: IPv6Only}
+			applyConfigDefaults(config)
+
+			_, _, err := peerListenAddrs(config)
+			Expect(err).To(MatchError(ContainSubstring("IPv6")))
+
+			config.TorrentListenAddr = "[2001:db8::1]:6881"
+			listenAddr, _, err := peerListenAddrs(config)
+			Expect(err).To(Succeed())
+			Expect(listenAddr).To(Equal("[2001:db8::1]:6881"))
+		})
+	})
+})
@@ -0,0 +1,41 @@
+package proxy
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+// Which pieces of the torrent are complete, for drawing a piece map, see GET /pieces.
+type PieceMap struct {
+	// How many pieces there are
+	Pieces int `json:"pieces"`
+	// The size of every piece but the last
+	PieceLength int64 `json:"pieceLength"`
+	// How many pieces are complete
+	Complete int `json:"complete"`
+	// Base64 of one bit per piece, set if it's complete.  The first piece is the high bit of the
+	// first byte, as in the BitTorrent protocol, and the bits after the last piece are zero.
+	Bitfield string `json:"bitfield"`
+}
+
+// Return which pieces of the torrent are complete.  The torrent must have its metadata.
+func (p *TorrentProxy) Pieces() *PieceMap {
+	bits, complete := p.completion.Bitfield()
+
+	return &PieceMap{
+		Pieces:      p.torrent.NumPieces(),
+		PieceLength: p.torrent.Info().PieceLength,
+		Complete:    complete,
+		Bitfield:    base64.StdEncoding.EncodeToString(bits),
+	}
+}
+
+// Serve the PieceMap as JSON.
+func (p *TorrentProxy) servePieces(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(p.Pieces())
+
+	log.Printf("%d %s", 200, r.URL.Path)
+}
@@ -0,0 +1,97 @@
+package proxy
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// How long Status reuses what a file takes up on disk before looking at it again, so polling it
+// doesn't stat every file in the torrent each time.
+const allocatedTTL = 5 * time.Second
+
+// How the torrent's files are laid out in DataDir, see Config.Preallocate.
+const (
+	// Create files as pieces arrive, taking up disk only for what's been written.  This is the default.
+	PreallocateSparse = "sparse"
+	// Allocate every file in full once the metadata arrives, so it isn't fragmented and running
+	// out of space happens up front rather than partway through.
+	PreallocateFull = "full"
+)
+
+// Returns an error if Config.Preallocate isn't one we know.
+func checkPreallocate(config *Config) error {
+	switch config.Preallocate {
+	case PreallocateSparse, PreallocateFull:
+		return nil
+	default:
+		return fmt.Errorf("Invalid Preallocate: %q", config.Preallocate)
+	}
+}
+
+// Allocate the disk for every file in the torrent that isn't skipped, keeping whatever is
+// already in them.  The torrent must have its metadata.
+func (p *TorrentProxy) preallocateFiles() {
+	for _, file := range p.torrent.Files() {
+		if p.filePriority(filePath(file)) == PrioritySkip {
+			continue
+		}
+
+		err := preallocateFile(filepath.Join(p.dataDir(), file.Path()), file.Length())
+		if err != nil {
+			log.Printf("Unable to preallocate %s: %s", filePath(file), err)
+		}
+	}
+}
+
+// Create the file at path if needed, and allocate length bytes of disk for it.
+func preallocateFile(path string, length int64) (err error) {
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	return allocate(f, length)
+}
+
+// Return how many bytes of disk the file at path in the torrent takes up, or 0 if it doesn't exist yet.
+func (p *TorrentProxy) allocated(path string) int64 {
+	info, err := os.Stat(filepath.Join(p.dataDir(), path))
+	if err != nil {
+		return 0
+	}
+
+	return allocatedSize(info)
+}
+
+// What a file took up on disk when it was last looked at, see cachedAllocated.
+type allocation struct {
+	bytes int64
+	at    time.Time
+}
+
+// Return allocated(path), looking at the file again only if it's been allocatedTTL since the last time.
+func (p *TorrentProxy) cachedAllocated(path string) int64 {
+	p.allocationsLock.Lock()
+	defer p.allocationsLock.Unlock()
+
+	if a, ok := p.allocations[path]; ok && time.Since(a.at) < allocatedTTL {
+		return a.bytes
+	}
+
+	if p.allocations == nil {
+		p.allocations = make(map[string]allocation)
+	}
+	a := allocation{bytes: p.allocated(path), at: time.Now()}
+	p.allocations[path] = a
+
+	return a.bytes
+}
@@ -0,0 +1,71 @@
+package proxy
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Preallocation", func() {
+	var dir string
+
+	BeforeEach(func() {
+		dir, _ = ioutil.TempDir("", "evaporation-preallocate")
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("grows files to their length, keeping what's in them", func() {
+		path := filepath.Join(dir, "a", "file")
+		Expect(preallocateFile(path, 1<<20)).To(Succeed())
+
+		os.Truncate(path, 0)
+		ioutil.WriteFile(path, []byte("kept"), 0644)
+		Expect(preallocateFile(path, 1<<20)).To(Succeed())
+
+		data, _ := ioutil.ReadFile(path)
+		Expect(data).To(HaveLen(1 << 20))
+		Expect(string(data[:4])).To(Equal("kept"))
+	})
+
+	It("allocates every file once the metadata arrives if configured to", func() {
+		p, err := NewTorrentProxy(&Config{
+			TorrentURL:        "testdata/sample.torrent",
+			TorrentListenAddr: "localhost:0",
+			DataDir:           dir,
+			Preallocate:       PreallocateFull,
+		})
+		Expect(err).To(Succeed())
+		defer p.Close()
+		Eventually(p.Ready(), 10*time.Second).Should(BeClosed())
+
+		for _, file := range p.torrent.Files() {
+			info, err := os.Stat(filepath.Join(p.dataDir(), file.Path()))
+			Expect(err).To(Succeed())
+			Expect(info.Size()).To(Equal(file.Length()))
+		}
+		allocated := p.Status().Files[0].Allocated
+		Expect(allocated).To(BeNumerically(">", 0))
+
+		// polling Status doesn't look at the disk every time
+		first := p.torrent.Files()[0].Path()
+		os.Truncate(filepath.Join(p.dataDir(), first), 0)
+		Expect(p.Status().Files[0].Allocated).To(Equal(allocated))
+		Expect(p.allocated(first)).To(BeZero())
+	})
+
+	It("rejects strategies it doesn't know", func() {
+		_, err := NewTorrentProxy(&Config{
+			TorrentURL:        "testdata/sample.torrent",
+			TorrentListenAddr: "localhost:0",
+			Preallocate:       "eventually",
+		})
+		Expect(err).To(MatchError(ContainSubstring("Invalid Preallocate")))
+	})
+})
@@ -0,0 +1,196 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+
+	"github.com/anacrolix/torrent"
+)
+
+// How eagerly a file is downloaded.
+const (
+	// Only the pieces clients read are downloaded.
+	PrioritySkip = "skip"
+	// The whole file is downloaded once a client requests it.  This is the default.
+	PriorityNormal = "normal"
+	// The whole file is downloaded now.
+	PriorityDownload = "download"
+)
+
+// A change to the priority of the files matching Path.
+type FilePriority struct {
+	// The path to a file, or a pattern as understood by path.Match, e.g. "Season 2/*"
+	Path string `json:"path"`
+	// PrioritySkip, PriorityNormal or PriorityDownload
+	Priority string `json:"priority"`
+}
+
+// Return the priority of the file at path.
+func (p *TorrentProxy) filePriority(path string) string {
+	p.priorityLock.Lock()
+	defer p.priorityLock.Unlock()
+
+	if priority, ok := p.priorities[path]; ok {
+		return priority
+	}
+
+	return PriorityNormal
+}
+
+// Change the priority of files in the torrent.
+//
+// Either every change is applied, or, if any of them is invalid or matches no files, none are.
+// Blocks until the torrent metadata is available.
+func (p *TorrentProxy) SetPriorities(changes []*FilePriority) (err error) {
+	<-p.Ready()
+
+	files := p.torrent.Files()
+	updated := make(map[int]string)
+
+	// work out the end result before touching anything
+	for _, change := range changes {
+		switch change.Priority {
+		case PrioritySkip, PriorityNormal, PriorityDownload:
+		default:
+			return fmt.Errorf("Unknown priority: %q", change.Priority)
+		}
+
+		matched := false
+		for i, file := range files {
+			ok, err := path.Match(change.Path, filePath(file))
+			if err != nil {
+				return fmt.Errorf("Invalid path %q: %s", change.Path, err)
+			}
+			if ok {
+				updated[i] = change.Priority
+				matched = true
+			}
+		}
+
+		if !matched {
+			return fmt.Errorf("No files match: %s", change.Path)
+		}
+	}
+
+	p.priorityLock.Lock()
+	defer p.priorityLock.Unlock()
+
+	for i, priority := range updated {
+		p.priorities[filePath(files[i])] = priority
+
+		switch priority {
+		case PrioritySkip:
+			p.skipFile(i)
+		case PriorityDownload:
+			files[i].Download()
+		}
+	}
+
+	return
+}
+
+// Stop downloading a file, except for pieces it shares with its neighbours.
+func (p *TorrentProxy) skipFile(i int) {
+	c := p.completion
+	first, last := c.first[i], c.last[i]
+
+	for j := range c.first {
+		if j == i {
+			continue
+		}
+		if c.covers(j, first) {
+			first++
+		}
+		if c.covers(j, last) {
+			last--
+		}
+	}
+
+	if first <= last {
+		p.torrent.CancelPieces(first, last+1)
+	}
+}
+
+// Stop downloading a file whose client went away before it was served, unless another client
+// is still reading it or it's to be downloaded anyway.  File.Download and PrioritizeRegion
+// outlive the reader that asked for them, unlike its readahead.
+func (p *TorrentProxy) abandonFile(file torrent.File) {
+	path := filePath(file)
+	if p.isReading(path) {
+		return
+	}
+
+	p.priorityLock.Lock()
+	defer p.priorityLock.Unlock()
+
+	if p.downloadAll || p.priorities[path] == PriorityDownload {
+		return
+	}
+
+	files := p.torrent.Files()
+	for i, f := range files {
+		if filePath(f) != path {
+			continue
+		}
+
+		c := p.completion
+		first, last := c.first[i], c.last[i]
+		if first > last {
+			return
+		}
+		p.torrent.CancelPieces(first, last+1)
+
+		// the pieces at either end may be shared with neighbours that still want them
+		for j, neighbour := range files {
+			if j == i || !(c.covers(j, first) || c.covers(j, last)) {
+				continue
+			}
+			if p.priorities[filePath(neighbour)] == PriorityDownload || p.isReading(filePath(neighbour)) {
+				neighbour.Download()
+			}
+		}
+		return
+	}
+}
+
+// Returns true if a client is reading the file at path.
+func (p *TorrentProxy) isReading(path string) bool {
+	for _, info := range p.activeReaders() {
+		if info.Path == path {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Handle PATCH /files, which accepts a JSON list of FilePriority changes.
+func (p *TorrentProxy) servePriorities(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "PATCH" {
+		p.errlog.Printf("%d %s %s", 405, r.Method, r.URL.Path)
+
+		w.Header().Set("Allow", "PATCH")
+		writeError(w, r, 405, "Method Not Allowed", nil)
+		return
+	}
+
+	var changes []*FilePriority
+	err := json.NewDecoder(r.Body).Decode(&changes)
+	if err == nil {
+		err = p.SetPriorities(changes)
+	}
+	if err != nil {
+		p.errlog.Printf("%d %s %s: %s", 400, r.Method, r.URL.Path, err)
+
+		writeError(w, r, 400, fmt.Sprintf("Invalid priorities: %s", err), nil)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(p.Status())
+
+	log.Printf("%d %s %s", 200, r.Method, r.URL.Path)
+}
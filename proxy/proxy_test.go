@@ -1,14 +1,24 @@
 package proxy
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/json"
+	"fmt"
+	"html"
 
 	"io/ioutil"
 
 	"net"
 	"net/http"
+	"net/http/httptest"
 
 	"os"
+	"path/filepath"
+	"strconv"
 
 	"strings"
 
@@ -16,6 +26,9 @@ import (
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/metainfo"
 )
 
 var _ = Describe("Proxy", func() {
@@ -63,6 +76,16 @@ var _ = Describe("Proxy", func() {
 			Expect(err).To(MatchError(ContainSubstring("invalid port")))
 		})
 
+		It("returns an error when MemoryLimit can't hold Readahead", func() {
+			p, err = NewTorrentProxy(&Config{
+				TorrentURL:        "magnet:?xt=urn:btih:adecafcafeadecafcafeadecafcafeadecafcafe",
+				TorrentListenAddr: "localhost:0",
+				MemoryLimit:       1 << 20,
+			})
+
+			Expect(err).To(MatchError(ContainSubstring("MemoryLimit")))
+		})
+
 	})
 
 	Context("DHTnodes", func() {
@@ -88,6 +111,321 @@ var _ = Describe("Proxy", func() {
 			Expect(p.client.DHT()).To(Not(BeNil()))
 		})
 
+		It("runs DHT on its own port if configured to", func() {
+			p, err = NewTorrentProxy(&Config{
+				DHTNodes:          []string{"127.0.0.1:65535"},
+				DHTListenAddr:     "localhost:0",
+				TorrentListenAddr: "localhost:0",
+				TorrentURL:        "magnet:?xt=urn:btih:adecafcafeadecafcafeadecafcafeadecafcafe",
+			})
+
+			Expect(err).To(Succeed())
+			Expect(p.client.DHT().Addr().String()).NotTo(Equal(p.client.ListenAddr().String()))
+		})
+
+		It("reports DHT stats in the status", func() {
+			p, err = NewTorrentProxy(&Config{
+				DHTNodes:          []string{"127.0.0.1:65535"},
+				TorrentListenAddr: "localhost:0",
+				TorrentURL:        "magnet:?xt=urn:btih:adecafcafeadecafcafeadecafcafeadecafcafe",
+			})
+
+			Expect(err).To(Succeed())
+			Expect(p.Status().DHT).NotTo(BeNil())
+		})
+
+		It("bootstraps from the nodes saved last time", func() {
+			dir, _ := ioutil.TempDir("", "evaporation-dht")
+			defer os.RemoveAll(dir)
+
+			ioutil.WriteFile(filepath.Join(dir, dhtNodesFile), []byte(`["127.0.0.2:6881", "junk"]`), 0644)
+
+			saved, err := loadDHTNodes(dir)
+			Expect(err).To(Succeed())
+			Expect(saved).To(HaveLen(1))
+			Expect(saved[0].String()).To(Equal("127.0.0.2:6881"))
+
+			saved, err = loadDHTNodes(filepath.Join(dir, "missing"))
+			Expect(err).To(Succeed())
+			Expect(saved).To(BeEmpty())
+		})
+
+		It("returns an error when given a bad DHT listen address", func() {
+			p, err = NewTorrentProxy(&Config{
+				DHTNodes:          []string{"127.0.0.1:65535"},
+				DHTListenAddr:     "localhost:99999",
+				TorrentListenAddr: "localhost:0",
+				TorrentURL:        "magnet:?xt=urn:btih:adecafcafeadecafcafeadecafcafeadecafcafe",
+			})
+
+			Expect(err).To(MatchError(ContainSubstring("DHT")))
+		})
+
+	})
+
+	Context("A proxy on a unix socket", func() {
+		var dir string
+
+		BeforeEach(func() {
+			dir, _ = ioutil.TempDir("", "socket")
+		})
+
+		AfterEach(func() {
+			if p != nil {
+				p.Close()
+			}
+			os.RemoveAll(dir)
+		})
+
+		It("serves HTTP on the socket with the configured permissions", func() {
+			path := dir + "/evaporation.sock"
+			p, err = NewTorrentProxy(&Config{
+				TorrentURL:        "testdata/sample.torrent",
+				TorrentListenAddr: "localhost:0",
+				HTTPListenAddr:    "unix://" + path,
+				SocketMode:        0600,
+				PublicURL:         "http://evaporation.test/",
+			})
+			Expect(err).To(Succeed())
+			Expect(p.URL()).To(Equal("http://evaporation.test"))
+
+			info, err := os.Stat(path)
+			Expect(err).To(Succeed())
+			Expect(info.Mode() & os.ModePerm).To(Equal(os.FileMode(0600)))
+
+			client := &http.Client{Transport: &http.Transport{
+				DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+					return net.Dial("unix", path)
+				},
+			}}
+
+			resp, err := client.Get(p.URL())
+			Expect(err).To(Succeed())
+			resp.Body.Close()
+			Expect(resp.StatusCode).To(Equal(200))
+		})
+
+		It("replaces a stale socket, but not other files", func() {
+			path := dir + "/evaporation.sock"
+			listener, _ := net.Listen("unix", path)
+			listener.(*net.UnixListener).SetUnlinkOnClose(false)
+			listener.Close()
+
+			p, err = NewTorrentProxy(&Config{
+				TorrentURL:        "testdata/sample.torrent",
+				TorrentListenAddr: "localhost:0",
+				HTTPListenAddr:    "unix://" + path,
+				PublicURL:         "http://evaporation.test",
+			})
+			Expect(err).To(Succeed())
+			p.Close()
+			p = nil
+
+			ioutil.WriteFile(dir+"/file", nil, 0644)
+			p, err = NewTorrentProxy(&Config{
+				TorrentURL:        "testdata/sample.torrent",
+				TorrentListenAddr: "localhost:0",
+				HTTPListenAddr:    "unix://" + dir + "/file",
+				PublicURL:         "http://evaporation.test",
+			})
+			Expect(err).To(MatchError(ContainSubstring("not a socket")))
+		})
+
+		It("needs a PublicURL, as a socket has no URL of its own", func() {
+			p, err = NewTorrentProxy(&Config{
+				TorrentURL:        "testdata/sample.torrent",
+				TorrentListenAddr: "localhost:0",
+				HTTPListenAddr:    "unix://" + dir + "/evaporation.sock",
+			})
+			Expect(err).To(MatchError(ContainSubstring("PublicURL must be specified")))
+		})
+	})
+
+	Context("An asynchronously configured proxy", func() {
+		AfterEach(func() {
+			if p != nil {
+				p.Close()
+			}
+		})
+
+		It("serves pending status before the torrent is resolved", func() {
+			p, err = NewTorrentProxy(&Config{
+				TorrentURL:        "magnet:?xt=urn:btih:adecafcafeadecafcafeadecafcafeadecafcafe",
+				TorrentListenAddr: "localhost:0",
+				Async:             true,
+			})
+
+			Expect(err).To(Succeed())
+
+			resp, err := http.Get(p.URL())
+			Expect(err).To(Succeed())
+			defer resp.Body.Close()
+
+			var s TorrentStatus
+			json.NewDecoder(resp.Body).Decode(&s)
+			Expect(s.Status).To(Equal("pending"))
+			Consistently(p.Ready()).ShouldNot(BeClosed())
+		})
+
+		It("reports how the search for a magnet's metadata is going", func() {
+			p, err = NewTorrentProxy(&Config{
+				TorrentURL:        "magnet:?xt=urn:btih:adecafcafeadecafcafeadecafcafeadecafcafe",
+				TorrentListenAddr: "localhost:0",
+			})
+			Expect(err).To(Succeed())
+
+			s := p.Status()
+			Expect(s.Status).To(Equal("pending"))
+			Expect(s.Metadata).NotTo(BeNil())
+			Expect(s.Metadata.Since).To(BeTemporally("~", time.Now(), 10*time.Second))
+			Expect(s.Metadata.Peers).To(BeZero())
+		})
+
+		It("returns 503 for file requests while metadata is pending", func() {
+			p, err = NewTorrentProxy(&Config{
+				TorrentURL:        "magnet:?xt=urn:btih:adecafcafeadecafcafeadecafcafeadecafcafe",
+				TorrentListenAddr: "localhost:0",
+				Async:             true,
+			})
+
+			Expect(err).To(Succeed())
+
+			resp, err := http.Get(p.URL() + "/some/file.mkv")
+			Expect(err).To(Succeed())
+			defer resp.Body.Close()
+
+			Expect(resp.StatusCode).To(Equal(503))
+			Expect(resp.Header.Get("Retry-After")).NotTo(BeEmpty())
+
+			var e struct {
+				ErrorResponse
+				Details TorrentStatus `json:"details"`
+			}
+			json.NewDecoder(resp.Body).Decode(&e)
+			Expect(e.Code).To(Equal(503))
+			Expect(e.Details.Status).To(Equal("pending"))
+		})
+
+		It("signals readiness once the metadata is available", func() {
+			http.DefaultServeMux = new(http.ServeMux)
+			http.HandleFunc("/a-torrent", func(w http.ResponseWriter, r *http.Request) {
+				http.ServeFile(w, r, "testdata/sample.torrent")
+			})
+
+			listener, _ := net.Listen("tcp", "localhost:0")
+			go http.Serve(listener, nil)
+
+			p, err = NewTorrentProxy(&Config{
+				TorrentURL:        "http://" + listener.Addr().String() + "/a-torrent",
+				TorrentListenAddr: "localhost:0",
+				DataDir:           "testdata",
+				Async:             true,
+			})
+
+			Expect(err).To(Succeed())
+			Eventually(p.Ready(), 10*time.Second).Should(BeClosed())
+			Expect(p.Status().Status).To(Equal("ready"))
+		})
+
+		It("refuses torrents bigger than MaxTorrentBytes", func() {
+			http.DefaultServeMux = new(http.ServeMux)
+			http.HandleFunc("/a-torrent", func(w http.ResponseWriter, r *http.Request) {
+				http.ServeFile(w, r, "testdata/sample.torrent")
+			})
+
+			listener, _ := net.Listen("tcp", "localhost:0")
+			go http.Serve(listener, nil)
+
+			p, err = NewTorrentProxy(&Config{
+				TorrentURL:        "http://" + listener.Addr().String() + "/a-torrent",
+				TorrentListenAddr: "localhost:0",
+				DataDir:           "testdata",
+				MaxTorrentBytes:   1,
+				Async:             true,
+			})
+
+			Expect(err).To(Succeed())
+			Eventually(func() string { return p.Status().Status }, 10*time.Second).Should(Equal("refused"))
+			Expect(p.Status().Refused).To(ContainSubstring("more than the limit of 1 bytes"))
+			Expect(p.Ready()).NotTo(BeClosed())
+
+			resp, err := http.Get(p.URL() + "/?wait=ready")
+			Expect(err).To(Succeed())
+			resp.Body.Close()
+			Expect(resp.StatusCode).To(Equal(507))
+
+			resp, err = http.Get(p.URL() + "/some/file.mkv")
+			Expect(err).To(Succeed())
+			resp.Body.Close()
+			Expect(resp.StatusCode).To(Equal(507))
+		})
+
+		It("times out waiting for metadata that doesn't arrive", func() {
+			p, err = NewTorrentProxy(&Config{
+				TorrentURL:        "magnet:?xt=urn:btih:adecafcafeadecafcafeadecafcafeadecafcafe",
+				TorrentListenAddr: "localhost:0",
+				Async:             true,
+			})
+
+			Expect(err).To(Succeed())
+
+			resp, err := http.Get(p.URL() + "/?wait=ready&timeout=100ms")
+			Expect(err).To(Succeed())
+			resp.Body.Close()
+			Expect(resp.StatusCode).To(Equal(503))
+
+			resp, err = http.Get(p.URL() + "/?wait=forever")
+			Expect(err).To(Succeed())
+			resp.Body.Close()
+			Expect(resp.StatusCode).To(Equal(400))
+		})
+
+		It("returns startup errors from Run", func() {
+			p, err = NewTorrentProxy(&Config{
+				Async: true,
+			})
+
+			Expect(err).To(Succeed())
+			Expect(p.Run()).To(MatchError(ContainSubstring("Invalid torrent")))
+		})
+	})
+
+	Context("An offline proxy", func() {
+		AfterEach(func() {
+			if p != nil {
+				p.Close()
+			}
+		})
+
+		It("serves what's in DataDir without a client on the network", func() {
+			p, err = NewTorrentProxy(&Config{
+				TorrentURL:        "testdata/sample.torrent",
+				TorrentListenAddr: "localhost:0",
+				DHTNodes:          []string{"127.0.0.1:65535"},
+				DataDir:           "testdata",
+				FlatDataDir:       true,
+				Offline:           true,
+			})
+			Expect(err).To(Succeed())
+			Eventually(p.Ready(), 10*time.Second).Should(BeClosed())
+
+			Expect(p.client.DHT()).To(BeNil())
+			Expect(p.Trackers()).To(BeEmpty())
+
+			resp, err := http.Get(p.URL() + p.Status().Files[0].URL)
+			Expect(err).To(Succeed())
+			resp.Body.Close()
+			Expect(resp.StatusCode).To(Equal(200))
+		})
+
+		It("needs the metadata up front", func() {
+			p, err = NewTorrentProxy(&Config{
+				TorrentURL:        "magnet:?xt=urn:btih:adecafcafeadecafcafeadecafcafeadecafcafe",
+				TorrentListenAddr: "localhost:0",
+				Offline:           true,
+			})
+			Expect(err).To(MatchError(ContainSubstring("Offline needs the torrent's metadata")))
+		})
 	})
 
 	Context("A correctly configured proxy", func() {
@@ -108,6 +446,7 @@ var _ = Describe("Proxy", func() {
 				TorrentURL:        torrentURL,
 				TorrentListenAddr: "localhost:0",
 				DataDir:           "testdata",
+				FlatDataDir:       true,
 			})
 
 			Expect(err).To(Succeed())
@@ -156,6 +495,41 @@ var _ = Describe("Proxy", func() {
 			Expect(strings.TrimSpace(string(body))).To(Equal(string(js)))
 		})
 
+		It("Compresses the status for clients that accept it", func() {
+			req, _ := http.NewRequest("GET", p.URL(), nil)
+			req.Header.Set("Accept-Encoding", "gzip")
+			resp, _ := (&http.Transport{DisableCompression: true}).RoundTrip(req)
+			defer resp.Body.Close()
+
+			Expect(resp.Header.Get("Content-Encoding")).To(Equal("gzip"))
+
+			zr, err := gzip.NewReader(resp.Body)
+			Expect(err).To(Succeed())
+			var s TorrentStatus
+			Expect(json.NewDecoder(zr).Decode(&s)).To(Succeed())
+			Expect(s.Status).To(Equal("ready"))
+		})
+
+		It("Returns peer counts", func() {
+			s := p.Status()
+
+			Expect(s.Peers).NotTo(BeNil())
+			Expect(s.Peers.Active).To(BeZero())
+			Expect(s.Peers.Total).To(BeNumerically(">=", s.Peers.Active))
+		})
+
+		It("Returns the pieces of each file", func() {
+			mi, _ := metainfo.LoadFromFile("testdata/sample.torrent")
+			info, _ := mi.UnmarshalInfo()
+
+			s := p.Status()
+			last := s.Files[len(s.Files)-1]
+
+			Expect(s.Files[0].FirstPiece).To(Equal(0))
+			Expect(s.Files[0].PieceLength).To(Equal(info.PieceLength))
+			Expect(last.LastPiece).To(Equal(info.NumPieces() - 1))
+		})
+
 		It("Returns torrent content", func() {
 			s := p.Status()
 
@@ -169,11 +543,676 @@ var _ = Describe("Proxy", func() {
 
 		})
 
+		It("Returns torrent content in stream mode", func() {
+			p.config.Stream = true
+			s := p.Status()
+
+			source, _ := ioutil.ReadFile("testdata/" + s.Files[1].Path)
+
+			resp, _ := http.Get(p.URL() + "/" + s.Files[1].Path)
+			defer resp.Body.Close()
+			body, _ := ioutil.ReadAll(resp.Body)
+
+			Expect(body).To(Equal(source))
+		})
+
+		It("Returns torrent content when its ends cover the whole file", func() {
+			p.config.Stream = true
+			p.config.EndPieces = 1000
+			s := p.Status()
+
+			source, _ := ioutil.ReadFile("testdata/" + s.Files[0].Path)
+
+			resp, _ := http.Get(p.URL() + "/" + s.Files[0].Path)
+			defer resp.Body.Close()
+			body, _ := ioutil.ReadAll(resp.Body)
+
+			Expect(body).To(Equal(source))
+		})
+
+		It("Returns torrent content for paths mapped by the resolver", func() {
+			s := p.Status()
+			p.config.Resolver = func(path string) string {
+				if path == "first" {
+					return s.Files[0].Path
+				}
+				return path
+			}
+
+			source, _ := ioutil.ReadFile("testdata/" + s.Files[0].Path)
+
+			resp, _ := http.Get(p.URL() + "/first")
+			defer resp.Body.Close()
+			body, _ := ioutil.ReadAll(resp.Body)
+
+			Expect(body).To(Equal(source))
+		})
+
+		It("Changes file priorities", func() {
+			path := p.Status().Files[0].Path
+			body, _ := json.Marshal([]*FilePriority{{Path: path, Priority: PrioritySkip}})
+
+			req, _ := http.NewRequest("PATCH", p.URL()+"/files", bytes.NewReader(body))
+			resp, _ := http.DefaultClient.Do(req)
+			defer resp.Body.Close()
+
+			var s TorrentStatus
+			json.NewDecoder(resp.Body).Decode(&s)
+
+			Expect(resp.StatusCode).To(Equal(200))
+			Expect(s.Files[0].Priority).To(Equal(PrioritySkip))
+			Expect(s.Files[1].Priority).To(Equal(PriorityNormal))
+		})
+
+		It("Lets a read token observe, and only the admin token change things", func() {
+			p.config.ReadToken = "reader"
+			p.config.AdminToken = "admin"
+
+			do := func(method, path, token string) int {
+				req, _ := http.NewRequest(method, p.URL()+path, strings.NewReader("[]"))
+				if len(token) > 0 {
+					req.Header.Set("Authorization", "Bearer "+token)
+				}
+				resp, _ := http.DefaultClient.Do(req)
+				resp.Body.Close()
+				return resp.StatusCode
+			}
+
+			Expect(do("GET", "/", "")).To(Equal(401))
+			Expect(do("GET", "/", "wrong")).To(Equal(401))
+			Expect(do("GET", "/", "reader")).To(Equal(200))
+			Expect(do("GET", "/api/v1/status", "admin")).To(Equal(200))
+
+			Expect(do("PATCH", "/files", "")).To(Equal(401))
+			Expect(do("PATCH", "/files", "reader")).To(Equal(403))
+			Expect(do("PATCH", "/files", "admin")).To(Equal(200))
+
+			// file contents are left to URLSigningKey
+			Expect(do("GET", "/sample_contents/hubble25.jpg", "")).To(Equal(200))
+		})
+
+		It("Answers status once the torrent is complete", func() {
+			resp, err := http.Get(p.URL() + "/?wait=complete&timeout=5s")
+			Expect(err).To(Succeed())
+			defer resp.Body.Close()
+
+			var s TorrentStatus
+			json.NewDecoder(resp.Body).Decode(&s)
+			Expect(resp.StatusCode).To(Equal(200))
+			Expect(s.Status).To(Equal("ready"))
+		})
+
+		It("Verifies the data against the piece hashes", func() {
+			resp, _ := http.Get(p.URL() + "/verify")
+			resp.Body.Close()
+			Expect(resp.StatusCode).To(Equal(404))
+
+			resp, _ = http.Post(p.URL()+"/verify", "application/json", nil)
+			var status VerifyStatus
+			json.NewDecoder(resp.Body).Decode(&status)
+			resp.Body.Close()
+
+			Expect(resp.StatusCode).To(Equal(202))
+			Expect(status.Total).To(Equal(p.torrent.NumPieces()))
+
+			Eventually(func() string {
+				return p.Status().Verification.State
+			}, 10*time.Second).Should(Equal("done"))
+
+			v := p.Status().Verification
+			Expect(v.Checked).To(Equal(v.Total))
+			Expect(v.Failed).To(BeZero())
+			Expect(v.Finished).NotTo(BeNil())
+		})
+
+		It("Changes no file priorities if any change is invalid", func() {
+			err := p.SetPriorities([]*FilePriority{
+				{Path: "*", Priority: PriorityDownload},
+				{Path: "this-file-does-not-exist.txt", Priority: PrioritySkip},
+			})
+
+			Expect(err).To(HaveOccurred())
+			for _, f := range p.Status().Files {
+				Expect(f.Priority).To(Equal(PriorityNormal))
+			}
+		})
+
+		It("Returns digests of complete files", func() {
+			p.config.Digests = true
+
+			path := p.Status().Files[0].Path
+			source, _ := ioutil.ReadFile("testdata/" + path)
+			sum := sha256.Sum256(source)
+			want := "sha-256=:" + base64.StdEncoding.EncodeToString(sum[:]) + ":"
+
+			// the first request starts hashing in the background
+			Eventually(func() string {
+				resp, _ := http.Head(p.URL() + "/" + path)
+				return resp.Header.Get("Repr-Digest")
+			}, 10*time.Second, 100*time.Millisecond).Should(Equal(want))
+		})
+
+		It("Returns URLs and ETags for complete files", func() {
+			resp, _ := http.Get(p.URL() + "/etags?complete=true")
+			defer resp.Body.Close()
+
+			var etags []*FileETag
+			Expect(json.NewDecoder(resp.Body).Decode(&etags)).To(Succeed())
+			Expect(etags).To(HaveLen(2))
+
+			file, _ := http.Head(etags[0].URL)
+			Expect(file.StatusCode).To(Equal(200))
+			Expect(file.Header.Get("ETag")).To(Equal(etags[0].ETag))
+		})
+
+		It("Returns 304 for files the client already has", func() {
+			path := p.Status().Files[0].Path
+
+			resp, _ := http.Head(p.URL() + "/" + path)
+			etag := resp.Header.Get("ETag")
+			Expect(etag).NotTo(BeEmpty())
+
+			req, _ := http.NewRequest("GET", p.URL()+"/"+path, nil)
+			req.Header.Set("If-None-Match", etag)
+			resp, _ = http.DefaultClient.Do(req)
+
+			Expect(resp.StatusCode).To(Equal(304))
+		})
+
+		It("Resumes downloads with If-Range", func() {
+			path := p.Status().Files[0].Path
+			source, _ := ioutil.ReadFile("testdata/" + path)
+
+			resp, _ := http.Head(p.URL() + "/" + path)
+
+			req, _ := http.NewRequest("GET", p.URL()+"/"+path, nil)
+			req.Header.Set("Range", "bytes=10-")
+			req.Header.Set("If-Range", resp.Header.Get("ETag"))
+			resp, _ = http.DefaultClient.Do(req)
+			defer resp.Body.Close()
+			body, _ := ioutil.ReadAll(resp.Body)
+
+			Expect(resp.StatusCode).To(Equal(206))
+			Expect(body).To(Equal(source[10:]))
+		})
+
+		It("Returns directory listings", func() {
+			s := p.Status()
+			dir := s.Files[0].Path[:strings.Index(s.Files[0].Path, "/")+1]
+
+			resp, _ := http.Get(p.URL() + "/" + dir)
+			defer resp.Body.Close()
+
+			var files []*TorrentFile
+			json.NewDecoder(resp.Body).Decode(&files)
+
+			Expect(resp.StatusCode).To(Equal(200))
+			Expect(files).To(HaveLen(len(s.Files)))
+			Expect(files[0].Path).To(Equal(s.Files[0].Path))
+		})
+
+		It("Returns HTML directory listings", func() {
+			s := p.Status()
+			dir := s.Files[0].Path[:strings.Index(s.Files[0].Path, "/")+1]
+
+			resp, _ := http.Get(p.URL() + "/" + dir + "?format=html")
+			defer resp.Body.Close()
+			body, _ := ioutil.ReadAll(resp.Body)
+
+			Expect(resp.Header.Get("Content-Type")).To(HavePrefix("text/html"))
+			Expect(string(body)).To(ContainSubstring(`href="/` + s.Files[0].Path + `"`))
+		})
+
+		It("Serves under BasePath, with or without the prefix stripped", func() {
+			p.config.BasePath = "/torrent"
+			s := p.Status()
+			Expect(s.Files[0].URL).To(HavePrefix("/torrent/"))
+			Expect(p.SignURL(s.Files[0].Path, time.Minute).URL).To(Equal(p.URL() + s.Files[0].URL))
+
+			source, _ := ioutil.ReadFile("testdata/" + s.Files[0].Path)
+			for _, path := range []string{"/torrent/" + s.Files[0].Path, "/" + s.Files[0].Path} {
+				resp, _ := http.Get(p.URL() + path)
+				body, _ := ioutil.ReadAll(resp.Body)
+				resp.Body.Close()
+
+				Expect(resp.StatusCode).To(Equal(200))
+				Expect(body).To(Equal(source))
+			}
+
+			dir := s.Files[0].Path[:strings.Index(s.Files[0].Path, "/")]
+			client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			}}
+			resp, _ := client.Get(p.URL() + "/" + dir)
+			resp.Body.Close()
+
+			Expect(resp.StatusCode).To(Equal(301))
+			Expect(resp.Header.Get("Location")).To(Equal("/torrent/" + dir + "/"))
+		})
+
+		It("Serves status and files from handlers mounted elsewhere", func() {
+			mux := http.NewServeMux()
+			mux.Handle("/state", p.StatusHandler())
+			mux.Handle("/media/", http.StripPrefix("/media/", p.FilesHandler()))
+			server := httptest.NewServer(mux)
+			defer server.Close()
+
+			resp, _ := http.Get(server.URL + "/state")
+			var s TorrentStatus
+			json.NewDecoder(resp.Body).Decode(&s)
+			resp.Body.Close()
+
+			Expect(s.Status).To(Equal("ready"))
+
+			source, _ := ioutil.ReadFile("testdata/" + s.Files[0].Path)
+			resp, _ = http.Get(server.URL + "/media/" + s.Files[0].Path)
+			body, _ := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+
+			Expect(resp.StatusCode).To(Equal(200))
+			Expect(body).To(Equal(source))
+		})
+
+		It("Returns what active readers are doing", func() {
+			p.config.AdminAPI = true
+			files := p.torrent.Files()
+			file := files[len(files)-1]
+
+			trs := newTorrentReadSeeker(p.torrent, &file, p.config.Readahead)
+			defer trs.Close()
+			untrack := p.trackReader(trs, "192.0.2.1:1234")
+
+			resp, _ := http.Get(p.URL() + "/debug/readers")
+			defer resp.Body.Close()
+
+			var readers []*ReaderInfo
+			json.NewDecoder(resp.Body).Decode(&readers)
+
+			Expect(readers).To(HaveLen(1))
+			Expect(readers[0].Path).To(Equal(file.Path()))
+			Expect(readers[0].Position).To(Equal(int64(0)))
+			Expect(readers[0].LastPiece).To(Equal(p.torrent.NumPieces() - 1))
+
+			untrack()
+			Expect(p.Readers()).To(BeEmpty())
+		})
+
+		It("Returns torrent content for escaped paths", func() {
+			s := p.Status()
+			source, _ := ioutil.ReadFile("testdata/" + s.Files[0].Path)
+
+			resp, _ := http.Get(p.URL() + strings.Replace(s.Files[0].URL, "_", "%5F", -1))
+			defer resp.Body.Close()
+			body, _ := ioutil.ReadAll(resp.Body)
+
+			Expect(body).To(Equal(source))
+		})
+
+		It("Matches paths case insensitively if configured to", func() {
+			path := strings.ToUpper(p.Status().Files[0].Path)
+
+			resp, _ := http.Head(p.URL() + "/" + path)
+			Expect(resp.StatusCode).To(Equal(404))
+
+			p.config.CaseInsensitivePaths = true
+
+			resp, _ = http.Head(p.URL() + "/" + path)
+			Expect(resp.StatusCode).To(Equal(200))
+		})
+
+		It("Returns torrent content by index", func() {
+			source, _ := ioutil.ReadFile("testdata/" + p.Status().Files[1].Path)
+
+			resp, _ := http.Get(p.URL() + "/files/1")
+			defer resp.Body.Close()
+			body, _ := ioutil.ReadAll(resp.Body)
+
+			Expect(body).To(Equal(source))
+
+			resp, _ = http.Get(p.URL() + "/files/1000")
+			Expect(resp.StatusCode).To(Equal(404))
+		})
+
+		It("Returns the largest file as the stream", func() {
+			var largest *TorrentFile
+			for _, f := range p.Status().Files {
+				if largest == nil || f.Length > largest.Length {
+					largest = f
+				}
+			}
+
+			resp, _ := http.Head(p.URL() + "/stream")
+			Expect(resp.StatusCode).To(Equal(200))
+			Expect(resp.ContentLength).To(Equal(largest.Length))
+		})
+
+		It("Mints short links that survive restarts", func() {
+			s := p.Status()
+			source, _ := ioutil.ReadFile("testdata/" + s.Files[1].Path)
+			defer os.Remove(p.shortLinksFile())
+
+			resp, _ := http.Post(p.URL()+"/f", "application/json", strings.NewReader(`{"path": "`+s.Files[1].Path+`"}`))
+			defer resp.Body.Close()
+
+			var link ShortLink
+			json.NewDecoder(resp.Body).Decode(&link)
+
+			Expect(resp.StatusCode).To(Equal(200))
+			Expect(link.ID).To(HaveLen(shortLinkLength))
+			Expect(link.URL).To(Equal(p.URL() + "/f/" + link.ID))
+
+			again, err := p.ShortLink(s.Files[1].Path)
+			Expect(err).To(Succeed())
+			Expect(again.ID).To(Equal(link.ID))
+
+			// forget them, as if we'd restarted
+			p.shortLinks = nil
+
+			resp, _ = http.Get(link.URL)
+			defer resp.Body.Close()
+			body, _ := ioutil.ReadAll(resp.Body)
+
+			Expect(body).To(Equal(source))
+
+			resp, _ = http.Post(p.URL()+"/f", "application/json", strings.NewReader(`{"path": "not/a/file"}`))
+			Expect(resp.StatusCode).To(Equal(404))
+		})
+
+		It("Lets DLNA players browse media files if configured to", func() {
+			browse := `<?xml version="1.0"?><s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body>` +
+				`<u:Browse xmlns:u="urn:schemas-upnp-org:service:ContentDirectory:1"><ObjectID>sample_contents</ObjectID>` +
+				`<BrowseFlag>BrowseDirectChildren</BrowseFlag><StartingIndex>0</StartingIndex><RequestedCount>0</RequestedCount>` +
+				`</u:Browse></s:Body></s:Envelope>`
+
+			resp, _ := http.Get(p.URL() + "/dlna/device.xml")
+			Expect(resp.StatusCode).To(Equal(404))
+
+			p.config.DLNA = true
+
+			resp, _ = http.Get(p.URL() + "/dlna/device.xml")
+			defer resp.Body.Close()
+			body, _ := ioutil.ReadAll(resp.Body)
+
+			Expect(string(body)).To(ContainSubstring("uuid:" + dlnaUUID(p.torrent.InfoHash().HexString())))
+			Expect(string(body)).To(ContainSubstring("<friendlyName>" + p.torrent.Name() + "</friendlyName>"))
+
+			req, _ := http.NewRequest("POST", p.URL()+"/dlna/control/ContentDirectory", strings.NewReader(browse))
+			req.Header.Set("SOAPAction", `"urn:schemas-upnp-org:service:ContentDirectory:1#Browse"`)
+			resp, _ = http.DefaultClient.Do(req)
+			defer resp.Body.Close()
+			body, _ = ioutil.ReadAll(resp.Body)
+
+			Expect(resp.StatusCode).To(Equal(200))
+			Expect(string(body)).To(ContainSubstring("<TotalMatches>" + strconv.Itoa(len(p.Status().Files)) + "</TotalMatches>"))
+			Expect(string(body)).To(ContainSubstring(html.EscapeString(p.URL() + p.Status().Files[0].URL)))
+
+			resp, _ = http.Head(p.URL() + p.Status().Files[0].URL)
+			Expect(resp.Header.Get("contentFeatures.dlna.org")).To(Equal(dlnaContentFeatures))
+		})
+
+		It("Sends configured headers for matching files", func() {
+			p.config.Headers = []HeaderRule{
+				{Pattern: "[", Headers: map[string]string{"X-Broken": "yes"}},
+				{Pattern: "*/*", Headers: map[string]string{"Content-Language": "en", "X-Collection": "nasa"}},
+				{Pattern: "*/blue_*", Headers: map[string]string{"Content-Language": "fr"}},
+			}
+
+			resp, _ := http.Head(p.URL() + "/sample_contents/blue_marble.jpg")
+			Expect(resp.Header.Get("Content-Language")).To(Equal("fr"))
+			Expect(resp.Header.Get("X-Collection")).To(Equal("nasa"))
+			Expect(resp.Header.Get("X-Broken")).To(BeEmpty())
+
+			resp, _ = http.Head(p.URL() + "/sample_contents/hubble25.jpg")
+			Expect(resp.Header.Get("Content-Language")).To(Equal("en"))
+		})
+
+		It("Fails fast when reads keep timing out", func() {
+			p.config.ReadTimeout = 100 * time.Millisecond
+			p.breaker = newCircuitBreaker(2, time.Minute)
+
+			// nobody is seeding the rest of this file
+			for i := 0; i < 2; i++ {
+				resp, _ := http.Get(p.URL() + "/sample_contents/partial.jpg")
+				ioutil.ReadAll(resp.Body)
+				resp.Body.Close()
+			}
+
+			resp, _ := http.Get(p.URL() + "/sample_contents/partial.jpg")
+			resp.Body.Close()
+			Expect(resp.StatusCode).To(Equal(503))
+			Expect(resp.Header.Get("Retry-After")).NotTo(BeEmpty())
+
+			// files we already have don't need the swarm
+			resp, _ = http.Get(p.URL() + "/sample_contents/hubble25.jpg")
+			resp.Body.Close()
+			Expect(resp.StatusCode).To(Equal(200))
+		})
+
+		It("Requires signed URLs for files when URLSigningKey is set", func() {
+			p.config.URLSigningKey = "secret"
+			path := "sample_contents/hubble25.jpg"
+
+			resp, _ := http.Get(p.URL() + "/" + path)
+			resp.Body.Close()
+			Expect(resp.StatusCode).To(Equal(403))
+
+			resp, _ = http.Get(p.SignURL(path, time.Minute).URL)
+			resp.Body.Close()
+			Expect(resp.StatusCode).To(Equal(200))
+
+			resp, _ = http.Get(p.SignURL(path, -time.Minute).URL)
+			resp.Body.Close()
+			Expect(resp.StatusCode).To(Equal(403))
+
+			// signed for another file
+			signed := p.SignURL("sample_contents/blue_marble.jpg", time.Minute).URL
+			resp, _ = http.Get(p.URL() + "/" + path + signed[strings.Index(signed, "?"):])
+			resp.Body.Close()
+			Expect(resp.StatusCode).To(Equal(403))
+
+			// the status stays open
+			resp, _ = http.Get(p.URL())
+			resp.Body.Close()
+			Expect(resp.StatusCode).To(Equal(200))
+		})
+
+		It("Turns away clients with too many streams", func() {
+			p.streams = newStreamLimiter(0, 1)
+			release, _ := p.streams.Acquire("127.0.0.1")
+
+			resp, _ := http.Get(p.URL() + "/sample_contents/hubble25.jpg")
+			resp.Body.Close()
+			Expect(resp.StatusCode).To(Equal(429))
+			Expect(resp.Header.Get("Retry-After")).To(Equal(streamRetryAfter))
+
+			release()
+			resp, _ = http.Get(p.URL() + "/sample_contents/hubble25.jpg")
+			resp.Body.Close()
+			Expect(resp.StatusCode).To(Equal(200))
+		})
+
+		It("Answers HEAD from the metainfo without downloading", func() {
+			var file torrent.File
+			for _, f := range p.torrent.Files() {
+				if f.Path() == "sample_contents/partial.jpg" {
+					file = f
+				}
+			}
+
+			req, _ := http.NewRequest("HEAD", p.URL()+"/sample_contents/partial.jpg", nil)
+			req.Header.Set("Range", "bytes=100-199")
+			resp, _ := http.DefaultClient.Do(req)
+			resp.Body.Close()
+
+			Expect(resp.StatusCode).To(Equal(206))
+			Expect(resp.Header.Get("Content-Range")).To(Equal(fmt.Sprintf("bytes 100-199/%d", file.Length())))
+			Expect(resp.Header.Get("Content-Type")).To(Equal("image/jpeg"))
+
+			info := p.torrent.Info()
+			first := int(file.Offset() / info.PieceLength)
+			last := int((file.Offset() + file.Length() - 1) / info.PieceLength)
+			for i := first; i <= last; i++ {
+				state := p.torrent.PieceState(i)
+				if !state.Complete {
+					Expect(state.Priority).To(Equal(torrent.PiecePriorityNone))
+				}
+			}
+		})
+
+		It("Stops downloading a file when its client goes away", func() {
+			var file torrent.File
+			for _, f := range p.torrent.Files() {
+				if f.Path() == "sample_contents/partial.jpg" {
+					file = f
+				}
+			}
+
+			info := p.torrent.Info()
+			first := int(file.Offset() / info.PieceLength)
+			last := int((file.Offset() + file.Length() - 1) / info.PieceLength)
+			pending := func() (pending int) {
+				for i := first; i <= last; i++ {
+					state := p.torrent.PieceState(i)
+					if !state.Complete && state.Priority != torrent.PiecePriorityNone {
+						pending++
+					}
+				}
+				return
+			}
+
+			// nobody is seeding the rest of this file, so the request waits until it's cancelled
+			ctx, cancel := context.WithCancel(context.Background())
+			req, _ := http.NewRequest("GET", p.URL()+"/sample_contents/partial.jpg", nil)
+			go func() {
+				resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+				if err == nil {
+					ioutil.ReadAll(resp.Body)
+					resp.Body.Close()
+				}
+			}()
+
+			Eventually(p.Readers).Should(HaveLen(1))
+			Expect(pending()).NotTo(BeZero())
+
+			cancel()
+			Eventually(p.Readers).Should(BeEmpty())
+			Eventually(pending).Should(BeZero())
+		})
+
+		It("Traces requests and the reads they make", func() {
+			tracer := &recordingTracer{}
+			p.config.Tracer = tracer
+
+			req, _ := http.NewRequest("GET", p.URL()+"/sample_contents/hubble25.jpg", nil)
+			req.Header.Set("Range", "bytes=10-")
+			resp, _ := http.DefaultClient.Do(req)
+			ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+
+			// the span ends after the response has been sent
+			var requests []*recordedSpan
+			Eventually(func() bool {
+				requests = tracer.named("http.request")
+				return len(requests) == 1 && requests[0].ended
+			}).Should(BeTrue())
+			Expect(requests[0].err).To(BeNil())
+			Expect(requests[0].attributes).To(HaveKeyWithValue("torrent.infohash", p.Status().Hash))
+			Expect(requests[0].attributes).To(HaveKeyWithValue("file.path", "sample_contents/hubble25.jpg"))
+			Expect(requests[0].attributes).To(HaveKeyWithValue("http.range", "bytes=10-"))
+			Expect(requests[0].attributes).To(HaveKeyWithValue("http.status_code", 206))
+			Expect(requests[0].attributes).To(HaveKey("http.content_range"))
+
+			reads := tracer.named("torrent.read")
+			Expect(reads).ToNot(BeEmpty())
+			Expect(reads[0].parent).To(Equal(requests[0]))
+			Expect(reads[0].attributes).To(HaveKeyWithValue("file.offset", int64(10)))
+		})
+
+		It("Serves the JSON API under /api/v1", func() {
+			js, _ := json.Marshal(p.Status())
+
+			resp, _ := http.Get(p.URL() + "/api/v1/status")
+			defer resp.Body.Close()
+			body, _ := ioutil.ReadAll(resp.Body)
+
+			Expect(strings.TrimSpace(string(body))).To(Equal(string(js)))
+
+			// files are only served at their own paths
+			resp, _ = http.Get(p.URL() + "/api/v1/" + p.Status().Files[0].Path)
+			Expect(resp.StatusCode).To(Equal(404))
+		})
+
+		It("Describes the JSON API with OpenAPI", func() {
+			var doc struct {
+				OpenAPI string                 `json:"openapi"`
+				Paths   map[string]interface{} `json:"paths"`
+			}
+
+			resp, _ := http.Get(p.URL() + "/api/v1/openapi.json")
+			defer resp.Body.Close()
+			json.NewDecoder(resp.Body).Decode(&doc)
+
+			Expect(doc.OpenAPI).To(HavePrefix("3."))
+			Expect(doc.Paths).To(HaveKey("/status"))
+			Expect(doc.Paths).NotTo(HaveKey("/admin/config"))
+
+			p.config.AdminAPI = true
+
+			resp, _ = http.Get(p.URL() + "/api/v1/openapi.json")
+			defer resp.Body.Close()
+			json.NewDecoder(resp.Body).Decode(&doc)
+
+			Expect(doc.Paths).To(HaveKey("/admin/config"))
+		})
+
 		It("Returns 404 for unknown files", func() {
 			resp, _ := http.Get(p.URL() + "/this-file-does-not-exist.txt")
 			Expect(resp.StatusCode).To(Equal(404))
 		})
 
+		It("Returns errors as JSON, or as text to browsers", func() {
+			resp, _ := http.Get(p.URL() + "/this-file-does-not-exist.txt")
+			defer resp.Body.Close()
+
+			var e ErrorResponse
+			json.NewDecoder(resp.Body).Decode(&e)
+
+			Expect(resp.Header.Get("Content-Type")).To(Equal("application/json"))
+			Expect(e.Code).To(Equal(404))
+			Expect(e.Message).To(Equal("File Not Found"))
+
+			req, _ := http.NewRequest("GET", p.URL()+"/this-file-does-not-exist.txt", nil)
+			req.Header.Set("Accept", "text/html,application/xhtml+xml,*/*;q=0.8")
+			resp, _ = http.DefaultClient.Do(req)
+			defer resp.Body.Close()
+			body, _ := ioutil.ReadAll(resp.Body)
+
+			Expect(resp.StatusCode).To(Equal(404))
+			Expect(resp.Header.Get("Content-Type")).To(HavePrefix("text/plain"))
+			Expect(strings.TrimSpace(string(body))).To(Equal("File Not Found"))
+		})
+
+		It("Returns 404 media info for unknown files", func() {
+			resp, _ := http.Get(p.URL() + "/files/this-file-does-not-exist.mkv/mediainfo")
+			Expect(resp.StatusCode).To(Equal(404))
+		})
+
+		It("Returns 501 media info when ffprobe is not available", func() {
+			p.config.FFProbePath = "/this/ffprobe/does/not/exist"
+
+			resp, _ := http.Get(p.URL() + "/files/" + p.Status().Files[0].Path + "/mediainfo")
+			Expect(resp.StatusCode).To(Equal(501))
+		})
+
+		It("Shuts down gracefully", func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			Expect(p.Shutdown(ctx)).To(Succeed())
+			Expect(p.Run()).To(Succeed())
+			Expect(p.client).To(BeNil())
+		})
+
 		It("Blocks on the Run method until the channel is closed", func() {
 			close(p.httperror)
 			err = p.Run()
@@ -0,0 +1,135 @@
+package proxy
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// What a reader serving a request is up to, for diagnosing stalls.
+type ReaderInfo struct {
+	// The client's address, as host:port, or "fuse pid N" for reads through a mount
+	Client string `json:"client"`
+	// The path of the file being read
+	Path string `json:"path"`
+	// When the request started
+	Started time.Time `json:"started"`
+	// The reader's position in the file
+	Position int64 `json:"position"`
+	// How many bytes past Position the reader asks the swarm for
+	Readahead int64 `json:"readahead"`
+	// The range of pieces, inclusive, the reader is prioritizing
+	FirstPiece int `json:"firstPiece"`
+	LastPiece  int `json:"lastPiece"`
+}
+
+// Keep track of a reader serving client, until the returned function is called.
+func (p *TorrentProxy) trackReader(trs *torrentReadSeeker, client string) func() {
+	p.readersLock.Lock()
+	defer p.readersLock.Unlock()
+
+	p.readers[trs] = &ReaderInfo{
+		Client:    client,
+		Path:      filePath(*trs.File),
+		Started:   time.Now(),
+		Readahead: trs.Readahead,
+	}
+
+	return func() {
+		p.readersLock.Lock()
+		defer p.readersLock.Unlock()
+
+		delete(p.readers, trs)
+	}
+}
+
+// Return the active readers and what's known about them.
+//
+// Readers aren't asked anything while readersLock is held, since storage may need the lock
+// while the torrent client is waiting on a reader.
+func (p *TorrentProxy) activeReaders() map[*torrentReadSeeker]*ReaderInfo {
+	p.readersLock.Lock()
+	defer p.readersLock.Unlock()
+
+	readers := make(map[*torrentReadSeeker]*ReaderInfo, len(p.readers))
+	for trs, info := range p.readers {
+		readers[trs] = info
+	}
+
+	return readers
+}
+
+// Return what every active reader is doing, oldest first.
+func (p *TorrentProxy) Readers() (readers []*ReaderInfo) {
+	active := p.activeReaders()
+
+	readers = make([]*ReaderInfo, 0, len(active))
+	if !p.hasInfo() {
+		return
+	}
+	pieceLength := p.torrent.Info().PieceLength
+
+	for trs, info := range active {
+		// readers start at the beginning of the torrent until their first read
+		pos := trs.Reader.CurrentPos()
+		if pos < trs.File.Offset() {
+			pos = trs.File.Offset()
+		}
+
+		end := pos + info.Readahead
+		if max := trs.File.Offset() + trs.File.Length(); end > max {
+			end = max
+		}
+
+		reader := *info
+		reader.Position = pos - trs.File.Offset()
+		reader.FirstPiece = int(pos / pieceLength)
+		reader.LastPiece = int((end - 1) / pieceLength)
+
+		readers = append(readers, &reader)
+	}
+
+	sort.Slice(readers, func(i, j int) bool {
+		return readers[i].Started.Before(readers[j].Started)
+	})
+
+	return
+}
+
+// A region of the torrent, by offset, that a reader is about to read.
+type readWindow struct {
+	offset int64
+	length int64
+}
+
+// Return the region each active reader is about to read: Readahead bytes from its last read,
+// or up to the end of its file.  Fallback storage keeps these pieces in memory.
+func (p *TorrentProxy) readWindows() (windows []readWindow) {
+	for trs := range p.activeReaders() {
+		// the position of the reader itself can't be asked for while the torrent client waits on us
+		pos := atomic.LoadInt64(&trs.pos)
+		if pos < trs.File.Offset() {
+			pos = trs.File.Offset()
+		}
+
+		end := pos + p.config.Readahead
+		if max := trs.File.Offset() + trs.File.Length(); end > max {
+			end = max
+		}
+
+		windows = append(windows, readWindow{offset: pos, length: end - pos})
+	}
+
+	return
+}
+
+// Serve the active readers as JSON.
+func (p *TorrentProxy) serveReaders(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(p.Readers())
+
+	log.Printf("%d %s", 200, r.URL.Path)
+}
@@ -0,0 +1,90 @@
+package proxy
+
+import (
+	"errors"
+	"io"
+	"net/http"
+)
+
+// Wraps a ResponseWriter so content is copied to it in chunks of a fixed size.
+//
+// http.ServeContent copies with io.CopyN which defers to the ResponseWriter's own ReadFrom.
+// Overriding ReadFrom lets us control how much torrent data is requested per Read, and so
+// how much is buffered per write.
+type chunkedResponseWriter struct {
+	http.ResponseWriter
+	size int
+}
+
+// Hides everything but Write, so io.CopyBuffer will actually use our buffer.
+type writerOnly struct {
+	io.Writer
+}
+
+// Copy from r to the response using a buffer of the configured size.
+func (w *chunkedResponseWriter) ReadFrom(r io.Reader) (n int64, err error) {
+	return io.CopyBuffer(writerOnly{w.ResponseWriter}, r, make([]byte, w.size))
+}
+
+// Holds back the status code until the first byte of the body is written, so a response
+// that fails before sending anything can still be turned into an error response.
+type deferredHeaderWriter struct {
+	http.ResponseWriter
+	code  int
+	wrote bool
+}
+
+func (w *deferredHeaderWriter) WriteHeader(code int) {
+	if !w.wrote {
+		w.code = code
+	}
+}
+
+func (w *deferredHeaderWriter) Write(b []byte) (int, error) {
+	w.Flush()
+	return w.ResponseWriter.Write(b)
+}
+
+// Send the status code if it hasn't been sent already.
+func (w *deferredHeaderWriter) Flush() {
+	if w.wrote {
+		return
+	}
+	w.wrote = true
+
+	if w.code == 0 {
+		w.code = 200
+	}
+	w.ResponseWriter.WriteHeader(w.code)
+}
+
+// Stands in for the contents of a file in HEAD requests, so http.ServeContent can work out the
+// length and ranges without a reader, which would start downloading the file.
+type headSeeker struct {
+	size int64
+	pos  int64
+}
+
+func (s *headSeeker) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += s.pos
+	case io.SeekEnd:
+		offset += s.size
+	default:
+		return 0, errors.New("Invalid whence")
+	}
+
+	if offset < 0 {
+		return 0, errors.New("Negative position")
+	}
+	s.pos = offset
+
+	return offset, nil
+}
+
+// HEAD responses have no body, so nothing should read one.
+func (s *headSeeker) Read([]byte) (int, error) {
+	return 0, errors.New("HEAD requests don't read file contents")
+}
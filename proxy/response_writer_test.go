@@ -0,0 +1,64 @@
+package proxy
+
+import (
+	"bytes"
+	"io"
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// Records the size of each buffer it is asked to fill.
+type recordingReader struct {
+	io.Reader
+	sizes []int
+}
+
+func (r *recordingReader) Read(p []byte) (int, error) {
+	r.sizes = append(r.sizes, len(p))
+	return r.Reader.Read(p)
+}
+
+var _ = Describe("chunkedResponseWriter", func() {
+	It("copies using the configured buffer size", func() {
+		rec := httptest.NewRecorder()
+		w := &chunkedResponseWriter{ResponseWriter: rec, size: 10}
+		r := &recordingReader{Reader: bytes.NewReader(make([]byte, 25))}
+
+		n, err := io.Copy(w, io.LimitReader(r, 25))
+
+		Expect(err).To(Succeed())
+		Expect(n).To(Equal(int64(25)))
+		Expect(rec.Body.Len()).To(Equal(25))
+		Expect(r.sizes[0]).To(Equal(10))
+	})
+})
+
+var _ = Describe("deferredHeaderWriter", func() {
+	var (
+		rec *httptest.ResponseRecorder
+		w   *deferredHeaderWriter
+	)
+
+	BeforeEach(func() {
+		rec = httptest.NewRecorder()
+		w = &deferredHeaderWriter{ResponseWriter: rec}
+	})
+
+	It("holds the status code until the body is written", func() {
+		w.WriteHeader(206)
+		Expect(w.wrote).To(BeFalse())
+
+		w.Write([]byte("data"))
+		Expect(w.wrote).To(BeTrue())
+		Expect(rec.Code).To(Equal(206))
+	})
+
+	It("sends the status code on Flush if there was no body", func() {
+		w.WriteHeader(304)
+		w.Flush()
+
+		Expect(rec.Code).To(Equal(304))
+	})
+})
@@ -0,0 +1,117 @@
+package proxy
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// How often a ProxyManager looks for torrents that have been complete for CompleteTTL.
+const retentionInterval = time.Minute
+
+// Remove torrents once they've been complete for Config.CompleteTTL, until the manager is closed.
+func (m *ProxyManager) runRetention() {
+	ticker := time.NewTicker(retentionInterval)
+	defer ticker.Stop()
+
+	completed := make(map[string]time.Time)
+	for {
+		select {
+		case now := <-ticker.C:
+			completed = m.expireCompleted(completed, now)
+		case <-m.closed:
+			return
+		}
+	}
+}
+
+// Remove the torrents that have been complete for CompleteTTL as of now.  completed maps the
+// infohashes of torrents to when they were first seen complete, and the updated map is returned.
+func (m *ProxyManager) expireCompleted(completed map[string]time.Time, now time.Time) map[string]time.Time {
+	current := make(map[string]time.Time)
+
+	for _, p := range m.List() {
+		if !p.hasInfo() {
+			continue
+		}
+		// pieces that fail a Verify start the clock again once they're downloaded
+		if complete, _ := p.completion.Complete(); !complete {
+			continue
+		}
+
+		id := p.torrent.InfoHash().HexString()
+		since, ok := completed[id]
+		if !ok {
+			since = now
+		}
+
+		if now.Sub(since) < m.config.CompleteTTL {
+			current[id] = since
+			continue
+		}
+
+		log.Printf("Removing %s, complete since %s", id, since.UTC().Format(time.RFC3339))
+		err := m.Remove(id)
+		if err != nil {
+			log.Printf("Unable to remove %s: %s", id, err)
+		}
+	}
+
+	return current
+}
+
+// Return the paths of the torrent's files in DataDir, and everything else kept there for it.
+func (p *TorrentProxy) dataPaths() (paths []string) {
+	if !p.hasStarted() {
+		return
+	}
+
+	paths = append(paths, metainfoCacheFile(p.config.DataDir, p.torrent.InfoHash()), p.shortLinksFile())
+
+	if !p.hasInfo() {
+		return
+	}
+
+	for _, file := range p.torrent.Files() {
+		paths = append(paths, filepath.Join(p.dataDir(), file.Path()))
+	}
+
+	return
+}
+
+// Delete paths in dataDir, and the directories under it they leave empty.
+func removeDataPaths(dataDir string, paths []string) (err error) {
+	root := filepath.Clean(dataDir)
+
+	for _, path := range paths {
+		rmErr := os.Remove(path)
+		if rmErr != nil && !os.IsNotExist(rmErr) {
+			err = fmt.Errorf("Unable to delete %s: %s", path, rmErr)
+			continue
+		}
+
+		// fails once a directory still has something in it, which is where to stop
+		for dir := filepath.Dir(path); dir != root && len(dir) > len(root); dir = filepath.Dir(dir) {
+			if os.Remove(dir) != nil {
+				break
+			}
+		}
+	}
+
+	return
+}
+
+// Where the torrent client records which pieces are complete, in DataDir.
+const pieceCompletionFile = ".torrent.bolt.db"
+
+// Delete the piece completion database from dataDir, once the torrent client using it is closed.
+func removePieceCompletion(dataDir string) error {
+	err := os.Remove(filepath.Join(dataDir, pieceCompletionFile))
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("Unable to delete %s: %s", pieceCompletionFile, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,151 @@
+package proxy
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Retention", func() {
+	It("removes torrents once they've been complete for CompleteTTL", func() {
+		m, err := NewProxyManager(&Config{
+			TorrentListenAddr: "localhost:0",
+			DataDir:           "testdata",
+			FlatDataDir:       true,
+			CompleteTTL:       time.Hour,
+		})
+		Expect(err).To(Succeed())
+		defer m.Close()
+
+		abs, _ := filepath.Abs("testdata/sample.torrent")
+		p, err := m.addURL("file://" + filepath.ToSlash(abs))
+		Expect(err).To(Succeed())
+
+		Eventually(p.Ready(), 10*time.Second).Should(BeClosed())
+		Eventually(func() bool {
+			complete, _ := p.completion.Complete()
+			return complete
+		}, 10*time.Second).Should(BeTrue())
+
+		now := time.Now()
+		completed := m.expireCompleted(map[string]time.Time{}, now)
+		Expect(completed).To(HaveLen(1))
+		Expect(m.List()).To(HaveLen(1))
+
+		completed = m.expireCompleted(completed, now.Add(time.Hour))
+		Expect(completed).To(BeEmpty())
+		Expect(m.List()).To(BeEmpty())
+	})
+
+	It("deletes files and the directories they leave empty", func() {
+		dir, _ := ioutil.TempDir("", "evaporation-retention")
+		defer os.RemoveAll(dir)
+
+		os.MkdirAll(filepath.Join(dir, "a", "b"), 0755)
+		ioutil.WriteFile(filepath.Join(dir, "a", "b", "one"), []byte("1"), 0644)
+		ioutil.WriteFile(filepath.Join(dir, "a", "two"), []byte("2"), 0644)
+		ioutil.WriteFile(filepath.Join(dir, "other"), []byte("3"), 0644)
+
+		err := removeDataPaths(dir, []string{
+			filepath.Join(dir, "a", "b", "one"),
+			filepath.Join(dir, "a", "missing"),
+		})
+		Expect(err).To(Succeed())
+
+		_, err = os.Stat(filepath.Join(dir, "a", "b"))
+		Expect(os.IsNotExist(err)).To(BeTrue())
+		_, err = os.Stat(filepath.Join(dir, "a", "two"))
+		Expect(err).To(Succeed())
+		_, err = os.Stat(filepath.Join(dir, "other"))
+		Expect(err).To(Succeed())
+	})
+
+	It("deletes everything it kept in DataDir on Close with EphemeralData", func() {
+		dir, _ := ioutil.TempDir("", "evaporation-ephemeral")
+		defer os.RemoveAll(dir)
+
+		abs, _ := filepath.Abs("testdata/sample.torrent")
+		p, err := NewTorrentProxy(&Config{
+			TorrentURL:        "file://" + filepath.ToSlash(abs),
+			TorrentListenAddr: "localhost:0",
+			DataDir:           dir,
+			EphemeralData:     true,
+		})
+		Expect(err).To(Succeed())
+		Eventually(p.Ready(), 10*time.Second).Should(BeClosed())
+
+		path := filepath.Join(p.dataDir(), p.torrent.Files()[0].Path())
+		os.MkdirAll(filepath.Dir(path), 0755)
+		Expect(ioutil.WriteFile(path, []byte("partial"), 0644)).To(Succeed())
+
+		p.Close()
+
+		entries, err := ioutil.ReadDir(dir)
+		Expect(err).To(Succeed())
+		Expect(entries).To(BeEmpty())
+	})
+
+	It("leaves the data of a torrent alone when it's added again with EphemeralData", func() {
+		dir, _ := ioutil.TempDir("", "evaporation-ephemeral")
+		defer os.RemoveAll(dir)
+
+		m, err := NewProxyManager(&Config{
+			TorrentListenAddr: "localhost:0",
+			DataDir:           dir,
+			EphemeralData:     true,
+		})
+		Expect(err).To(Succeed())
+		defer m.Close()
+
+		abs, _ := filepath.Abs("testdata/sample.torrent")
+		p, err := m.addURL("file://" + filepath.ToSlash(abs))
+		Expect(err).To(Succeed())
+		Eventually(p.Ready(), 10*time.Second).Should(BeClosed())
+
+		path := filepath.Join(p.dataDir(), p.torrent.Files()[0].Path())
+		os.MkdirAll(filepath.Dir(path), 0755)
+		Expect(ioutil.WriteFile(path, []byte("partial"), 0644)).To(Succeed())
+
+		_, err = m.addURL("file://" + filepath.ToSlash(abs))
+		Expect(err).To(MatchError(ContainSubstring("Already added")))
+
+		Expect(path).To(BeAnExistingFile())
+		Expect(p.torrent).NotTo(BeNil())
+	})
+
+	It("removes torrents over HTTP, purging their data if asked to", func() {
+		dir, _ := ioutil.TempDir("", "evaporation-purge")
+		defer os.RemoveAll(dir)
+
+		m, err := NewProxyManager(&Config{
+			TorrentListenAddr: "localhost:0",
+			DataDir:           dir,
+		})
+		Expect(err).To(Succeed())
+		defer m.Close()
+
+		abs, _ := filepath.Abs("testdata/sample.torrent")
+		p, err := m.addURL("file://" + filepath.ToSlash(abs))
+		Expect(err).To(Succeed())
+		Eventually(p.Ready(), 10*time.Second).Should(BeClosed())
+
+		path := filepath.Join(p.dataDir(), p.torrent.Files()[0].Path())
+		os.MkdirAll(filepath.Dir(path), 0755)
+		Expect(ioutil.WriteFile(path, []byte("partial"), 0644)).To(Succeed())
+
+		req, _ := http.NewRequest("DELETE", p.URL()+"?purge=true", nil)
+		resp, err := http.DefaultClient.Do(req)
+		Expect(err).To(Succeed())
+		resp.Body.Close()
+		Expect(resp.StatusCode).To(Equal(204))
+
+		Expect(m.List()).To(BeEmpty())
+		_, err = os.Stat(path)
+		Expect(os.IsNotExist(err)).To(BeTrue())
+	})
+})
@@ -0,0 +1,160 @@
+package proxy
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/bencode"
+	"github.com/anacrolix/torrent/metainfo"
+	"github.com/anacrolix/torrent/storage"
+)
+
+// The bounds of the piece length chosen for a torrent created from SeedPath.
+const (
+	minSeedPieceLength = 16 << 10
+	maxSeedPieceLength = 16 << 20
+)
+
+// Roughly how many pieces a torrent created from SeedPath is split into, so its metainfo stays small.
+const seedPieceCount = 1500
+
+// Return a piece length for a torrent of total bytes: a power of two that gives about
+// seedPieceCount pieces, within the bounds above.
+func seedPieceLength(total int64) int64 {
+	length := int64(minSeedPieceLength)
+	for length < maxSeedPieceLength && total/length > seedPieceCount {
+		length *= 2
+	}
+
+	return length
+}
+
+// Create the storage for seeding Config.SeedPath from where it is.  Which pieces are complete
+// is kept in memory, as they're all known to be once the metainfo is built, so nothing is
+// written next to the files.
+func newSeedStorage(seedPath string) (disk storage.ClientImpl, completion storage.PieceCompletion, err error) {
+	info, err := os.Stat(seedPath)
+	if err != nil {
+		return
+	}
+	if !info.IsDir() {
+		return nil, nil, fmt.Errorf("SeedPath must be a directory: %s", seedPath)
+	}
+
+	abs, err := filepath.Abs(seedPath)
+	if err != nil {
+		return
+	}
+
+	// the torrent is named for the directory, which the storage looks for in its parent
+	completion = storage.NewMapPieceCompletion()
+	disk = storage.NewFileWithCompletion(filepath.Dir(abs), completion)
+
+	return
+}
+
+// Build a metainfo from the files under Config.SeedPath, and return a TorrentSpec for it with
+// every piece marked complete, since they were just hashed.
+//
+// Blocks until every file has been read.
+func (p *TorrentProxy) seedSpec() (spec *torrent.TorrentSpec, created time.Time, err error) {
+	root, err := filepath.Abs(p.config.SeedPath)
+	if err != nil {
+		return
+	}
+
+	var total int64
+	err = filepath.Walk(root, func(path string, fi os.FileInfo, err error) error {
+		if err == nil && fi.Mode().IsRegular() {
+			total += fi.Size()
+		}
+		return err
+	})
+	if err != nil {
+		return
+	}
+	if total == 0 {
+		return nil, created, fmt.Errorf("Nothing to seed in %s", root)
+	}
+
+	log.Printf("Hashing %d bytes in %s", total, root)
+
+	info := metainfo.Info{PieceLength: seedPieceLength(total)}
+	err = info.BuildFromFilePath(root)
+	if err != nil {
+		return
+	}
+
+	created = time.Now()
+	mi := &metainfo.MetaInfo{
+		CreatedBy:    "evaporation",
+		CreationDate: created.Unix(),
+	}
+	for _, tracker := range p.config.SeedTrackers {
+		// a tier each, so they're all announced to
+		mi.AnnounceList = append(mi.AnnounceList, []string{tracker})
+	}
+	if len(p.config.SeedTrackers) > 0 {
+		mi.Announce = p.config.SeedTrackers[0]
+	}
+
+	mi.InfoBytes, err = bencode.Marshal(info)
+	if err != nil {
+		return
+	}
+
+	spec = torrent.TorrentSpecFromMetaInfo(mi)
+	for i := 0; i < info.NumPieces(); i++ {
+		err = p.seedCompletion.Set(metainfo.PieceKey{InfoHash: spec.InfoHash, Index: i}, true)
+		if err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// Return the torrent's magnet URL, with its trackers, and the peers and web seeds it was
+// added with.
+func (p *TorrentProxy) magnet() string {
+	m := metainfo.Magnet{
+		InfoHash:    p.torrent.InfoHash(),
+		DisplayName: p.torrent.Name(),
+	}
+	for _, tier := range p.trackers {
+		for _, tracker := range tier {
+			m.Trackers = append(m.Trackers, redactTrackerURL(tracker))
+		}
+	}
+
+	magnet := m.String()
+	for _, peer := range p.hints.peers {
+		magnet += "&x.pe=" + url.QueryEscape(peer)
+	}
+	for _, webSeed := range p.hints.webSeeds {
+		magnet += "&ws=" + url.QueryEscape(webSeed)
+	}
+
+	return magnet
+}
+
+// Serve the torrent's metainfo as a .torrent file.
+func (p *TorrentProxy) serveMetainfo(w http.ResponseWriter, r *http.Request) {
+	mi := p.torrent.Metainfo()
+
+	w.Header().Set("Content-Type", "application/x-bittorrent")
+	w.Header().Set("Content-Disposition", attachmentDisposition(p.torrent.Name()+".torrent"))
+	err := mi.Write(w)
+	if err != nil {
+		p.errlog.Printf("%d %s: %s", 500, r.URL.Path, err)
+		return
+	}
+
+	log.Printf("%d %s", 200, r.URL.Path)
+}
@@ -0,0 +1,87 @@
+package proxy
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+var _ = Describe("Seeding", func() {
+	var dir string
+
+	BeforeEach(func() {
+		dir, _ = ioutil.TempDir("", "evaporation-seed")
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("picks piece lengths that keep the metainfo small", func() {
+		Expect(seedPieceLength(1)).To(Equal(int64(minSeedPieceLength)))
+		Expect(seedPieceLength(4 << 30)).To(Equal(int64(4 << 20)))
+		Expect(seedPieceLength(1 << 50)).To(Equal(int64(maxSeedPieceLength)))
+	})
+
+	It("creates a torrent from a directory, and serves it", func() {
+		content := filepath.Join(dir, "content")
+		os.MkdirAll(filepath.Join(content, "sub"), 0755)
+		ioutil.WriteFile(filepath.Join(content, "a.txt"), []byte("hello"), 0644)
+		ioutil.WriteFile(filepath.Join(content, "sub", "b.txt"), []byte("world"), 0644)
+
+		p, err := NewTorrentProxy(&Config{
+			SeedPath:          content,
+			SeedTrackers:      []string{"udp://tracker.example.com:80"},
+			TorrentListenAddr: "localhost:0",
+			DataDir:           dir,
+		})
+		Expect(err).To(Succeed())
+		defer p.Close()
+		Eventually(p.Ready(), 10*time.Second).Should(BeClosed())
+
+		complete, _ := p.completion.Complete()
+		Expect(complete).To(BeTrue())
+
+		s := p.Status()
+		Expect(s.Name).To(Equal("content"))
+		Expect(s.Metadata).To(BeNil())
+		Expect(s.Magnet).To(ContainSubstring(s.Hash))
+		Expect(s.Magnet).To(ContainSubstring("tracker.example.com"))
+
+		resp, err := http.Get(p.URL() + "/metainfo")
+		Expect(err).To(Succeed())
+		mi, err := metainfo.Load(resp.Body)
+		resp.Body.Close()
+		Expect(err).To(Succeed())
+		Expect(mi.HashInfoBytes().HexString()).To(Equal(s.Hash))
+
+		link := ""
+		for _, f := range s.Files {
+			if filepath.Base(f.Path) == "b.txt" {
+				link = f.URL
+			}
+		}
+		Expect(link).NotTo(BeEmpty())
+
+		resp, err = http.Get(p.URL() + link)
+		Expect(err).To(Succeed())
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		Expect(string(body)).To(Equal("world"))
+	})
+
+	It("won't seed a single file", func() {
+		path := filepath.Join(dir, "file")
+		ioutil.WriteFile(path, []byte("hello"), 0644)
+
+		_, err := NewTorrentProxy(&Config{SeedPath: path, TorrentListenAddr: "localhost:0"})
+		Expect(err).To(MatchError(ContainSubstring("must be a directory")))
+	})
+})
@@ -0,0 +1,169 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Traffic classes, which share Config.ServeRateLimit by Config.StreamWeight when both are busy.
+const (
+	// Media being played, which stutters if it falls behind.
+	TrafficStream = "stream"
+	// Downloads, which only take longer.
+	TrafficBulk = "bulk"
+)
+
+// The most a class can save up while it's idle, so it can't burst past the other class for long.
+const shaperBurst = 100 * time.Millisecond
+
+// The most bytes written to a shaped response at once, so the classes take turns often.
+const shaperChunk = 32 << 10
+
+// Return the traffic class of a request for the file at name.
+//
+// Clients can choose with the X-Traffic-Class header.  Otherwise downloads with ?download=1 are bulk,
+// and other requests are streams if the file is media.
+func trafficClass(r *http.Request, name string) string {
+	switch class := r.Header.Get("X-Traffic-Class"); class {
+	case TrafficStream, TrafficBulk:
+		return class
+	}
+
+	if r.URL.Query().Get("download") != "1" && isMediaFile(name) {
+		return TrafficStream
+	}
+
+	return TrafficBulk
+}
+
+// Shares a send rate between traffic classes, by weight between the classes that are busy.
+//
+// Idle classes don't hold back busy ones, so the guarantee is soft: a class gets its share when it
+// needs it, and everything otherwise.
+type trafficShaper struct {
+	lock sync.Mutex
+	// bytes per second across every class
+	rate    float64
+	weights map[string]int
+	// how many responses of each class are in progress
+	active map[string]int
+	// bytes each class may send now, which goes negative when a class is in debt
+	tokens map[string]float64
+	last   map[string]time.Time
+}
+
+// Create a shaper that sends rate bytes per second, giving streams streamWeight times the share of bulk.
+func newTrafficShaper(rate int64, streamWeight int) *trafficShaper {
+	return &trafficShaper{
+		rate:    float64(rate),
+		weights: map[string]int{TrafficStream: streamWeight, TrafficBulk: 1},
+		active:  make(map[string]int),
+		tokens:  make(map[string]float64),
+		last:    make(map[string]time.Time),
+	}
+}
+
+// Return the bytes per second class gets right now.  Call with lock held.
+func (s *trafficShaper) classRate(class string) float64 {
+	busy := 0
+	for c, n := range s.active {
+		if n > 0 {
+			busy += s.weights[c]
+		}
+	}
+
+	// not busy yet, but about to be
+	if s.active[class] == 0 {
+		busy += s.weights[class]
+	}
+
+	return s.rate * float64(s.weights[class]) / float64(busy)
+}
+
+// Count a response of class as in progress, until the returned function is called.
+func (s *trafficShaper) Begin(class string) func() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.active[class] == 0 {
+		s.tokens[class] = 0
+		s.last[class] = time.Now()
+	}
+	s.active[class]++
+
+	return func() {
+		s.lock.Lock()
+		defer s.lock.Unlock()
+
+		s.active[class]--
+	}
+}
+
+// Wait until class may send n bytes, or ctx is done.
+func (s *trafficShaper) Wait(ctx context.Context, class string, n int) error {
+	s.lock.Lock()
+
+	now := time.Now()
+	rate := s.classRate(class)
+
+	tokens := s.tokens[class] + now.Sub(s.last[class]).Seconds()*rate
+	if max := shaperBurst.Seconds() * rate; tokens > max {
+		tokens = max
+	}
+	tokens -= float64(n)
+
+	s.tokens[class] = tokens
+	s.last[class] = now
+
+	s.lock.Unlock()
+
+	if tokens >= 0 {
+		return nil
+	}
+
+	// pay off the debt at the rate we have now.  If the other class goes idle meanwhile, we
+	// wait a little longer than we needed to this once.
+	timer := time.NewTimer(time.Duration(-tokens / rate * float64(time.Second)))
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Wraps a ResponseWriter so writes are paced by a trafficShaper.
+type shapedResponseWriter struct {
+	http.ResponseWriter
+	shaper *trafficShaper
+	class  string
+	ctx    context.Context
+}
+
+func (w *shapedResponseWriter) Write(b []byte) (n int, err error) {
+	for len(b) > 0 {
+		chunk := b
+		if len(chunk) > shaperChunk {
+			chunk = chunk[:shaperChunk]
+		}
+
+		err = w.shaper.Wait(w.ctx, w.class, len(chunk))
+		if err != nil {
+			return
+		}
+
+		written, err := w.ResponseWriter.Write(chunk)
+		n += written
+		if err != nil {
+			return n, err
+		}
+
+		b = b[len(chunk):]
+	}
+
+	return
+}
@@ -0,0 +1,77 @@
+package proxy
+
+import (
+	"context"
+	"net/http/httptest"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Traffic shaping", func() {
+	Describe("Classifying requests", func() {
+		It("streams media files", func() {
+			Expect(trafficClass(httptest.NewRequest("GET", "/movie.mkv", nil), "movie.mkv")).To(Equal(TrafficStream))
+		})
+
+		It("sends everything else as bulk", func() {
+			Expect(trafficClass(httptest.NewRequest("GET", "/archive.zip", nil), "archive.zip")).To(Equal(TrafficBulk))
+			Expect(trafficClass(httptest.NewRequest("GET", "/movie.mkv?download=1", nil), "movie.mkv")).To(Equal(TrafficBulk))
+		})
+
+		It("lets clients choose", func() {
+			r := httptest.NewRequest("GET", "/archive.zip", nil)
+			r.Header.Set("X-Traffic-Class", TrafficStream)
+
+			Expect(trafficClass(r, "archive.zip")).To(Equal(TrafficStream))
+		})
+	})
+
+	Describe("Sharing the rate", func() {
+		var s *trafficShaper
+
+		BeforeEach(func() {
+			s = newTrafficShaper(1000, 4)
+		})
+
+		It("gives a class the whole rate when it's alone", func() {
+			defer s.Begin(TrafficBulk)()
+
+			Expect(s.classRate(TrafficBulk)).To(BeNumerically("==", 1000))
+		})
+
+		It("shares the rate by weight when both classes are busy", func() {
+			defer s.Begin(TrafficBulk)()
+			defer s.Begin(TrafficStream)()
+
+			Expect(s.classRate(TrafficStream)).To(BeNumerically("==", 800))
+			Expect(s.classRate(TrafficBulk)).To(BeNumerically("==", 200))
+		})
+
+		It("gives the rate back when a class goes idle", func() {
+			defer s.Begin(TrafficBulk)()
+			s.Begin(TrafficStream)()
+
+			Expect(s.classRate(TrafficBulk)).To(BeNumerically("==", 1000))
+		})
+
+		It("makes writers wait their turn", func() {
+			defer s.Begin(TrafficBulk)()
+
+			start := time.Now()
+			Expect(s.Wait(context.Background(), TrafficBulk, 200)).To(Succeed())
+
+			Expect(time.Since(start)).To(BeNumerically("~", 200*time.Millisecond, 100*time.Millisecond))
+		})
+
+		It("stops waiting when the client goes away", func() {
+			defer s.Begin(TrafficBulk)()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			Expect(s.Wait(ctx, TrafficBulk, 10000)).To(MatchError(context.Canceled))
+		})
+	})
+})
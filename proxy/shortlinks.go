@@ -0,0 +1,220 @@
+package proxy
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// The fewest hex digits of a path's hash that make up its short link ID.
+// More are used if the shorter ID is already taken.
+const shortLinkLength = 6
+
+// A short, stable link to a file in the torrent.
+type ShortLink struct {
+	// The ID the file is served at, as /f/{id}
+	ID string `json:"id"`
+	// The path of the file
+	Path string `json:"path"`
+	// The public URL of the link
+	URL string `json:"url"`
+}
+
+// Return where the short links for this torrent are kept.
+func (p *TorrentProxy) shortLinksFile() string {
+	return filepath.Join(p.config.DataDir, ".links-"+p.torrent.InfoHash().HexString()+".json")
+}
+
+// Load the short links from DataDir, if they haven't been already.  Call with shortLinksLock held.
+func (p *TorrentProxy) loadShortLinks() (err error) {
+	if p.shortLinks != nil {
+		return
+	}
+
+	links := make(map[string]string)
+
+	data, err := ioutil.ReadFile(p.shortLinksFile())
+	if os.IsNotExist(err) {
+		err = nil
+	} else if err == nil {
+		err = json.Unmarshal(data, &links)
+		if err != nil {
+			err = fmt.Errorf("Invalid short links in %s: %s", p.shortLinksFile(), err)
+		}
+	}
+	if err != nil {
+		return
+	}
+
+	p.shortLinks = links
+	return
+}
+
+// Write the short links to DataDir, replacing the old file only once the new one is complete.
+// Call with shortLinksLock held.
+func (p *TorrentProxy) saveShortLinks() (err error) {
+	data, err := json.Marshal(p.shortLinks)
+	if err != nil {
+		return
+	}
+
+	tmp := p.shortLinksFile() + ".tmp"
+	err = ioutil.WriteFile(tmp, data, 0644)
+	if err != nil {
+		return
+	}
+
+	return os.Rename(tmp, p.shortLinksFile())
+}
+
+// Return the ShortLink for id and path.
+func (p *TorrentProxy) shortLink(id string, path string) *ShortLink {
+	return &ShortLink{
+		ID:   id,
+		Path: path,
+		URL:  p.absoluteURL("/f/" + id),
+	}
+}
+
+// Return the short link for a file, minting one if it doesn't have one yet.
+//
+// Links are kept in DataDir, so they keep working across restarts.
+// Blocks until the torrent metadata is available.
+func (p *TorrentProxy) ShortLink(path string) (link *ShortLink, err error) {
+	<-p.Ready()
+
+	file, ok := p.findFile(path)
+	if !ok {
+		return nil, fmt.Errorf("File not found: %s", path)
+	}
+	path = filePath(file)
+
+	p.shortLinksLock.Lock()
+	defer p.shortLinksLock.Unlock()
+
+	err = p.loadShortLinks()
+	if err != nil {
+		return
+	}
+
+	for id, linked := range p.shortLinks {
+		if linked == path {
+			return p.shortLink(id, path), nil
+		}
+	}
+
+	// derive the ID from the path, so the same file tends to get the same ID even if the links are lost
+	sum := sha1.Sum([]byte(p.torrent.InfoHash().HexString() + "/" + path))
+	digits := hex.EncodeToString(sum[:])
+
+	id := digits[:shortLinkLength]
+	for n := shortLinkLength + 1; n <= len(digits); n++ {
+		if _, taken := p.shortLinks[id]; !taken {
+			break
+		}
+		id = digits[:n]
+	}
+
+	p.shortLinks[id] = path
+	err = p.saveShortLinks()
+	if err != nil {
+		delete(p.shortLinks, id)
+		return nil, fmt.Errorf("Unable to save short links: %s", err)
+	}
+
+	return p.shortLink(id, path), nil
+}
+
+// Return every short link, ordered by ID.
+//
+// Blocks until the torrent metadata is available.
+func (p *TorrentProxy) ShortLinks() (links []*ShortLink, err error) {
+	<-p.Ready()
+
+	p.shortLinksLock.Lock()
+	defer p.shortLinksLock.Unlock()
+
+	err = p.loadShortLinks()
+	if err != nil {
+		return
+	}
+
+	links = make([]*ShortLink, 0, len(p.shortLinks))
+	for id, path := range p.shortLinks {
+		links = append(links, p.shortLink(id, path))
+	}
+	sort.Slice(links, func(i, j int) bool { return links[i].ID < links[j].ID })
+
+	return
+}
+
+// Return the path of the file a short link ID refers to.
+func (p *TorrentProxy) shortLinkPath(id string) (path string, ok bool) {
+	p.shortLinksLock.Lock()
+	defer p.shortLinksLock.Unlock()
+
+	if err := p.loadShortLinks(); err != nil {
+		p.errlog.Printf("Unable to load short links: %s", err)
+		return
+	}
+
+	path, ok = p.shortLinks[id]
+	return
+}
+
+// Handle /f, GET to list every ShortLink, or POST a JSON object with a path to get the link for it.
+func (p *TorrentProxy) serveShortLinks(w http.ResponseWriter, r *http.Request) {
+	var (
+		result interface{}
+		err    error
+		code   = 200
+	)
+
+	switch r.Method {
+	case "GET", "HEAD":
+		result, err = p.ShortLinks()
+	case "POST":
+		var req struct {
+			Path string `json:"path"`
+		}
+		err = json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			code = 400
+			break
+		}
+
+		result, err = p.ShortLink(strings.TrimPrefix(req.Path, "/"))
+		if err != nil && strings.HasPrefix(err.Error(), "File not found") {
+			code = 404
+		}
+	default:
+		p.errlog.Printf("%d %s %s", 405, r.Method, r.URL.Path)
+
+		w.Header().Set("Allow", "GET, HEAD, POST")
+		writeError(w, r, 405, "Method Not Allowed", nil)
+		return
+	}
+
+	if err != nil {
+		if code == 200 {
+			code = 500
+		}
+		p.errlog.Printf("%d %s %s: %s", code, r.Method, r.URL.Path, err)
+
+		writeError(w, r, code, err.Error(), nil)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+
+	log.Printf("%d %s %s", 200, r.Method, r.URL.Path)
+}
@@ -0,0 +1,88 @@
+package proxy
+
+import (
+	"encoding/xml"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// Extensions of files we will generate metadata sidecars for.
+var mediaExtensions = map[string]bool{
+	".avi":  true,
+	".flac": true,
+	".m4a":  true,
+	".m4v":  true,
+	".mkv":  true,
+	".mov":  true,
+	".mp3":  true,
+	".mp4":  true,
+	".mpg":  true,
+	".ogg":  true,
+	".ts":   true,
+	".webm": true,
+	".wmv":  true,
+}
+
+// A minimal Kodi-style .nfo file.
+type nfoSidecar struct {
+	XMLName xml.Name `xml:"movie"`
+	Title   string   `xml:"title"`
+	// in minutes
+	Runtime int   `xml:"runtime,omitempty"`
+	Size    int64 `xml:"size"`
+}
+
+// Returns true if the file looks like audio or video.
+func isMediaFile(name string) bool {
+	return mediaExtensions[strings.ToLower(path.Ext(name))]
+}
+
+// Turn a release file name into something resembling a title.
+//
+// "Some.Movie_2010.mkv" becomes "Some Movie 2010"
+func titleFromPath(name string) string {
+	base := path.Base(name)
+	base = strings.TrimSuffix(base, path.Ext(base))
+
+	return strings.TrimSpace(strings.NewReplacer(".", " ", "_", " ").Replace(base))
+}
+
+// Serve a generated .nfo sidecar for the media file that shares its base name.
+//
+// Returns false if there is no such media file, so the request can be handled elsewhere.
+func (p *TorrentProxy) serveSidecar(w http.ResponseWriter, r *http.Request, nfoPath string) bool {
+	if !p.hasStarted() || !strings.HasSuffix(nfoPath, ".nfo") {
+		return false
+	}
+
+	base := strings.TrimSuffix(normalizePath(nfoPath), ".nfo")
+
+	for _, file := range p.torrent.Files() {
+		name := filePath(file)
+		if !isMediaFile(name) || strings.TrimSuffix(name, path.Ext(name)) != base {
+			continue
+		}
+
+		nfo := &nfoSidecar{
+			Title: titleFromPath(name),
+			Size:  file.Length(),
+		}
+
+		// duration is best effort, we still want a library entry without ffprobe
+		info, err := p.MediaInfo(name)
+		if err == nil && info.Duration > 0 {
+			nfo.Runtime = int(info.Duration/60 + 0.5)
+		}
+
+		w.Header().Set("Content-Type", "text/xml; charset=utf-8")
+		w.Write([]byte(xml.Header))
+		xml.NewEncoder(w).Encode(nfo)
+
+		log.Printf("%d %s", 200, r.URL.Path)
+		return true
+	}
+
+	return false
+}
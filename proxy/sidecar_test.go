@@ -0,0 +1,19 @@
+package proxy
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Sidecars", func() {
+	It("recognizes media files", func() {
+		Expect(isMediaFile("Season1/Episode.01.MKV")).To(BeTrue())
+		Expect(isMediaFile("Season1/readme.txt")).To(BeFalse())
+		Expect(isMediaFile("no-extension")).To(BeFalse())
+	})
+
+	It("derives a title from a release file name", func() {
+		Expect(titleFromPath("movies/Some.Movie_2010.mkv")).To(Equal("Some Movie 2010"))
+		Expect(titleFromPath("plain.mp4")).To(Equal("plain"))
+	})
+})
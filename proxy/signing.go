@@ -0,0 +1,125 @@
+package proxy
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// How long links in DLNA listings stay valid when URLs are signed.  Players browse again long before.
+const dlnaSignedLinkTTL = 24 * time.Hour
+
+// The most a link minted by /admin/sign is valid for, if no ttl is asked for.
+const defaultSignedLinkTTL = time.Hour
+
+// A link to a file that stops working at Expires, see Config.URLSigningKey.
+type SignedURL struct {
+	// The path of the file in the torrent
+	Path string `json:"path"`
+	// The full URL, signature included
+	URL string `json:"url"`
+	// When the URL stops working
+	Expires time.Time `json:"expires"`
+}
+
+// Return the signature of path until expires with key.
+func urlSignature(key string, path string, expires int64) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(strings.TrimPrefix(path, "/") + "\n" + strconv.FormatInt(expires, 10)))
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Return the query string that grants access to path until expires, or "" if URLs aren't signed.
+func (p *TorrentProxy) signedQuery(path string, expires time.Time) string {
+	if len(p.config.URLSigningKey) == 0 {
+		return ""
+	}
+
+	return url.Values{
+		"expires":   {strconv.FormatInt(expires.Unix(), 10)},
+		"signature": {urlSignature(p.config.URLSigningKey, path, expires.Unix())},
+	}.Encode()
+}
+
+// Return a URL for the file at path that works for ttl, with Config.URLSigningKey.
+// If URLs aren't signed, the URL works for as long as the file is there.
+func (p *TorrentProxy) SignURL(path string, ttl time.Duration) *SignedURL {
+	path = strings.TrimPrefix(path, "/")
+	expires := time.Now().Add(ttl)
+
+	u := p.absoluteURL(fileLink(path))
+	if query := p.signedQuery(path, expires); len(query) > 0 {
+		u += "?" + query
+	}
+
+	return &SignedURL{
+		Path:    path,
+		URL:     u,
+		Expires: expires.UTC().Truncate(time.Second),
+	}
+}
+
+// Returns true if the request carries a valid, unexpired signature for path, or URLs aren't signed.
+func (p *TorrentProxy) validSignature(r *http.Request, path string) bool {
+	if len(p.config.URLSigningKey) == 0 {
+		return true
+	}
+
+	query := r.URL.Query()
+	expires, err := strconv.ParseInt(query.Get("expires"), 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return false
+	}
+
+	expected := urlSignature(p.config.URLSigningKey, path, expires)
+	return hmac.Equal([]byte(query.Get("signature")), []byte(expected))
+}
+
+// Serve a 403 unless the request is signed for path.
+// Returns true if the request was handled.
+func (p *TorrentProxy) serveUnsigned(w http.ResponseWriter, r *http.Request, path string) bool {
+	if p.validSignature(r, path) {
+		return false
+	}
+
+	p.errlog.Printf("%d %s: missing, invalid or expired signature", 403, r.URL.Path)
+
+	writeError(w, r, 403, "This link is invalid or has expired", nil)
+	return true
+}
+
+// Serve a signed URL for ?path= that works for ?ttl=, a duration like 30m.
+func (p *TorrentProxy) serveSign(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Query().Get("path"), "/")
+	if len(path) == 0 {
+		p.errlog.Printf("%d %s: no path", 400, r.URL.Path)
+
+		writeError(w, r, 400, "A path is required", nil)
+		return
+	}
+
+	ttl := defaultSignedLinkTTL
+	if len(r.URL.Query().Get("ttl")) > 0 {
+		var err error
+		ttl, err = time.ParseDuration(r.URL.Query().Get("ttl"))
+		if err != nil || ttl <= 0 {
+			p.errlog.Printf("%d %s: invalid ttl", 400, r.URL.Path)
+
+			writeError(w, r, 400, "Invalid ttl, expected a duration like 30m", nil)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(p.SignURL(path, ttl))
+
+	log.Printf("%d %s", 200, r.URL.Path)
+}
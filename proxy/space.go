@@ -0,0 +1,77 @@
+package proxy
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/anacrolix/torrent"
+)
+
+// Return why the torrent mustn't be downloaded, because it's bigger than Config.MaxTorrentBytes
+// or the free space in DataDir, or nil if it can be.  The torrent must have its metadata, and
+// skipped files aren't counted, so SkipJunk must have been applied.
+func (p *TorrentProxy) checkSpace(t *torrent.Torrent) error {
+	wanted, needed := p.neededSpace(t)
+
+	if p.config.MaxTorrentBytes > 0 && wanted > p.config.MaxTorrentBytes {
+		return fmt.Errorf("Torrent is %d bytes, more than the limit of %d bytes", wanted, p.config.MaxTorrentBytes)
+	}
+
+	free, ok := freeSpace(p.config.DataDir)
+	if ok && needed > free {
+		return fmt.Errorf("Torrent needs %d more bytes, but DataDir only has %d bytes free", needed, free)
+	}
+
+	return nil
+}
+
+// Return how many bytes of the torrent's files are wanted, leaving out those that are skipped,
+// and how much more disk they need than what's already allocated to them from an earlier run.
+// Sparse files count only the blocks they've been given.
+func (p *TorrentProxy) neededSpace(t *torrent.Torrent) (wanted, needed int64) {
+	for _, file := range t.Files() {
+		if p.filePriority(filePath(file)) == PrioritySkip {
+			continue
+		}
+
+		wanted += file.Length()
+		if missing := file.Length() - p.allocated(file.Path()); missing > 0 {
+			needed += missing
+		}
+	}
+
+	return
+}
+
+// Record why the torrent won't be downloaded, see checkSpace.
+func (p *TorrentProxy) refuse(err error) {
+	log.Printf("ALERT: Not downloading %s: %s", p.torrent.InfoHash().HexString(), err)
+
+	p.refusedErr = err
+	close(p.refused)
+}
+
+// Return why the torrent won't be downloaded, or nil if it will be.
+func (p *TorrentProxy) refusal() error {
+	select {
+	case <-p.refused:
+		return p.refusedErr
+	default:
+		return nil
+	}
+}
+
+// Serve a 507 if the torrent won't be downloaded, see checkSpace.
+// Returns true if the request was handled.
+func (p *TorrentProxy) serveRefused(w http.ResponseWriter, r *http.Request) bool {
+	err := p.refusal()
+	if err == nil {
+		return false
+	}
+
+	writeError(w, r, 507, err.Error(), p.Status())
+
+	p.errlog.Printf("%d %s: %s", 507, r.URL.Path, err)
+	return true
+}
@@ -0,0 +1,61 @@
+package proxy
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Disk space", func() {
+	var (
+		dir string
+		p   *TorrentProxy
+	)
+
+	BeforeEach(func() {
+		dir, _ = ioutil.TempDir("", "evaporation-space")
+
+		abs, _ := filepath.Abs("testdata/sample.torrent")
+		var err error
+		p, err = NewTorrentProxy(&Config{
+			TorrentURL:        "file://" + filepath.ToSlash(abs),
+			TorrentListenAddr: "localhost:0",
+			DataDir:           dir,
+		})
+		Expect(err).To(Succeed())
+		Eventually(p.Ready(), 10*time.Second).Should(BeClosed())
+	})
+
+	AfterEach(func() {
+		p.Close()
+		os.RemoveAll(dir)
+	})
+
+	It("counts only the wanted files, and only what's allocated to them already", func() {
+		files := p.torrent.Files()
+		Expect(files).To(HaveLen(3))
+
+		// preallocated sparse, so it takes up nothing yet
+		sparse := filepath.Join(p.dataDir(), files[0].Path())
+		os.MkdirAll(filepath.Dir(sparse), 0755)
+		f, _ := os.Create(sparse)
+		f.Close()
+		Expect(os.Truncate(sparse, files[0].Length())).To(Succeed())
+
+		// downloaded in full
+		full := filepath.Join(p.dataDir(), files[1].Path())
+		Expect(ioutil.WriteFile(full, make([]byte, files[1].Length()), 0644)).To(Succeed())
+
+		p.priorityLock.Lock()
+		p.priorities[filePath(files[2])] = PrioritySkip
+		p.priorityLock.Unlock()
+
+		wanted, needed := p.neededSpace(p.torrent)
+		Expect(wanted).To(Equal(files[0].Length() + files[1].Length()))
+		Expect(needed).To(BeNumerically("~", files[0].Length(), 4096))
+	})
+})
@@ -0,0 +1,441 @@
+package proxy
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"sync"
+
+	"github.com/anacrolix/torrent/metainfo"
+	"github.com/anacrolix/torrent/storage"
+)
+
+// Create the disk storage for Config.DataDir, which keeps each torrent's files in a directory
+// of its own unless Config.FlatDataDir is set, and encrypts them if Config.DataKey is.
+func newDiskStorage(config *Config) (disk storage.ClientImpl, err error) {
+	var key []byte
+	if len(config.DataKey) > 0 {
+		key, err = parseDataKey(config.DataKey)
+		if err != nil {
+			return
+		}
+
+		err = checkDataKey(config.DataDir, key)
+	} else {
+		err = checkNoDataKey(config.DataDir)
+	}
+	if err != nil {
+		return
+	}
+
+	if config.FlatDataDir {
+		disk = storage.NewFile(config.DataDir)
+	} else {
+		disk = storage.NewFileByInfoHash(config.DataDir)
+	}
+
+	if key != nil {
+		disk = newEncryptedStorage(disk, key)
+	}
+
+	return
+}
+
+// Return the directory a torrent's files are kept in by newDiskStorage.
+func torrentDataDir(dataDir string, flat bool, infoHash metainfo.Hash) string {
+	if flat {
+		return dataDir
+	}
+
+	return filepath.Join(dataDir, infoHash.HexString())
+}
+
+// Return the directory the torrent's files are kept in.  The torrent client must have started.
+func (p *TorrentProxy) dataDir() string {
+	return torrentDataDir(p.config.DataDir, p.config.FlatDataDir, p.torrent.InfoHash())
+}
+
+// Wraps disk storage so that if DataDir stops accepting writes, new pieces are kept in memory
+// instead of stalling every download.
+//
+// When memory is full, the least recently used complete pieces are evicted to make room, so a
+// file larger than the limit can still be streamed.  Pieces in a window being read are never
+// evicted; if they fill the limit, writes fail until a reader moves on.
+type fallbackStorage struct {
+	storage.ClientImpl
+
+	// the most piece data we'll hold in memory
+	limit int64
+	// called once, the first time a write to disk fails
+	onDegraded func(err error)
+	// if set, returns the regions of the torrent being read, whose pieces mustn't be evicted
+	windows func() []readWindow
+
+	lock     sync.Mutex
+	used     int64
+	degraded error
+}
+
+// Create a storage that falls back to memory, up to limit bytes, when disk writes fail.
+func newFallbackStorage(disk storage.ClientImpl, limit int64, onDegraded func(err error)) *fallbackStorage {
+	return &fallbackStorage{
+		ClientImpl: disk,
+		limit:      limit,
+		onDegraded: onDegraded,
+	}
+}
+
+// Returns the write error that caused us to switch to memory, or nil if the disk is fine.
+func (s *fallbackStorage) Degraded() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.degraded
+}
+
+// Change the most piece data we'll hold in memory.
+//
+// Pieces already in memory are kept even if they exceed the new limit.
+func (s *fallbackStorage) SetLimit(limit int64) {
+	s.lock.Lock()
+	s.limit = limit
+	s.lock.Unlock()
+}
+
+// Record a disk failure, and raise the alarm the first time it happens.
+func (s *fallbackStorage) degrade(err error) {
+	s.lock.Lock()
+	first := s.degraded == nil
+	if first {
+		s.degraded = err
+	}
+	s.lock.Unlock()
+
+	if first {
+		log.Printf("ALERT: Unable to write to DataDir, storing new pieces in memory: %s", err)
+		if s.onDegraded != nil {
+			s.onDegraded(err)
+		}
+	}
+}
+
+// Reserve memory for a piece, if it fits in the budget.
+func (s *fallbackStorage) reserve(length int64) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.used+length > s.limit {
+		return fmt.Errorf("Memory limit of %d bytes reached", s.limit)
+	}
+	s.used += length
+
+	return nil
+}
+
+// Return memory that a piece held.
+func (s *fallbackStorage) release(length int64) {
+	s.lock.Lock()
+	s.used -= length
+	s.lock.Unlock()
+}
+
+func (s *fallbackStorage) OpenTorrent(info *metainfo.Info, infoHash metainfo.Hash) (storage.TorrentImpl, error) {
+	t, err := s.ClientImpl.OpenTorrent(info, infoHash)
+	if err != nil {
+		return nil, err
+	}
+
+	return &fallbackTorrent{
+		TorrentImpl: t,
+		storage:     s,
+		pieceLength: info.PieceLength,
+		pieces:      make(map[int]*memoryPiece),
+	}, nil
+}
+
+// A torrent in fallback storage, tracking which pieces have moved to memory.
+type fallbackTorrent struct {
+	storage.TorrentImpl
+	storage     *fallbackStorage
+	pieceLength int64
+
+	lock   sync.Mutex
+	pieces map[int]*memoryPiece
+	// incremented on every access to a piece in memory, to find the least recently used
+	clock uint64
+}
+
+// A piece held in memory.
+type memoryPiece struct {
+	lock sync.RWMutex
+	// nil once the piece has been evicted
+	data     []byte
+	complete bool
+	// the torrent's clock when the piece was last accessed
+	used uint64
+}
+
+// Reserve memory for a piece, evicting pieces to make room if needed.
+// Must be called with t.lock held.
+func (t *fallbackTorrent) reserve(length int64) (err error) {
+	var pinned map[int]bool
+	for {
+		err = t.storage.reserve(length)
+		if err == nil {
+			return
+		}
+
+		// only ask what's being read once we know we have to evict
+		if pinned == nil {
+			pinned = t.pinned()
+		}
+		if !t.evict(pinned) {
+			return
+		}
+	}
+}
+
+// Return the pieces in the windows being read.
+func (t *fallbackTorrent) pinned() map[int]bool {
+	pinned := make(map[int]bool)
+	if t.storage.windows == nil {
+		return pinned
+	}
+
+	for _, w := range t.storage.windows() {
+		if w.length <= 0 {
+			continue
+		}
+		for i := w.offset / t.pieceLength; i <= (w.offset+w.length-1)/t.pieceLength; i++ {
+			pinned[int(i)] = true
+		}
+	}
+
+	return pinned
+}
+
+// Drop the least recently used complete piece that isn't pinned from memory, so the torrent
+// downloads it again if it's read.  Returns false if there was nothing to evict.
+// Must be called with t.lock held.
+func (t *fallbackTorrent) evict(pinned map[int]bool) bool {
+	var victim *memoryPiece
+	for index, mp := range t.pieces {
+		if pinned[index] || (victim != nil && mp.used >= victim.used) {
+			continue
+		}
+
+		// pieces still being downloaded would only be downloaded again
+		mp.lock.RLock()
+		evictable := mp.complete && mp.data != nil
+		mp.lock.RUnlock()
+
+		if evictable {
+			victim = mp
+		}
+	}
+
+	if victim == nil {
+		return false
+	}
+
+	victim.lock.Lock()
+	t.storage.release(int64(len(victim.data)))
+	victim.data = nil
+	victim.complete = false
+	victim.lock.Unlock()
+
+	return true
+}
+
+func (t *fallbackTorrent) Piece(p metainfo.Piece) storage.PieceImpl {
+	return &fallbackPiece{
+		PieceImpl: t.TorrentImpl.Piece(p),
+		torrent:   t,
+		index:     p.Index(),
+		length:    p.Length(),
+	}
+}
+
+func (t *fallbackTorrent) Close() error {
+	t.lock.Lock()
+	for _, mp := range t.pieces {
+		t.storage.release(int64(len(mp.data)))
+	}
+	t.pieces = nil
+	t.lock.Unlock()
+
+	return t.TorrentImpl.Close()
+}
+
+// A piece that lives on disk until a write to it fails.
+type fallbackPiece struct {
+	storage.PieceImpl
+	torrent *fallbackTorrent
+	index   int
+	length  int64
+}
+
+// Return the in-memory copy of this piece, or nil if it's still on disk.
+func (p *fallbackPiece) memory() *memoryPiece {
+	p.torrent.lock.Lock()
+	defer p.torrent.lock.Unlock()
+
+	mp := p.torrent.pieces[p.index]
+	if mp != nil {
+		p.torrent.clock++
+		mp.used = p.torrent.clock
+	}
+
+	return mp
+}
+
+// Move this piece into memory, keeping whatever we can read of it from disk.
+func (p *fallbackPiece) toMemory(cause error) (mp *memoryPiece, err error) {
+	p.torrent.lock.Lock()
+	defer p.torrent.lock.Unlock()
+
+	if mp = p.torrent.pieces[p.index]; mp != nil {
+		return
+	}
+
+	err = p.torrent.reserve(p.length)
+	if err != nil {
+		return
+	}
+
+	p.torrent.clock++
+	mp = &memoryPiece{data: make([]byte, p.length), used: p.torrent.clock}
+
+	// a read-only disk can usually still be read, so don't lose what was already written
+	p.PieceImpl.ReadAt(mp.data, 0)
+
+	p.torrent.pieces[p.index] = mp
+	p.torrent.storage.degrade(cause)
+
+	return
+}
+
+// Make room for an evicted piece again, since it's being downloaded again.
+func (p *fallbackPiece) reload(mp *memoryPiece) (err error) {
+	p.torrent.lock.Lock()
+	defer p.torrent.lock.Unlock()
+
+	mp.lock.RLock()
+	evicted := mp.data == nil
+	mp.lock.RUnlock()
+
+	if !evicted {
+		return
+	}
+
+	err = p.torrent.reserve(p.length)
+	if err != nil {
+		return
+	}
+
+	mp.lock.Lock()
+	mp.data = make([]byte, p.length)
+	mp.lock.Unlock()
+
+	return
+}
+
+func (p *fallbackPiece) ReadAt(b []byte, off int64) (n int, err error) {
+	mp := p.memory()
+	if mp == nil {
+		return p.PieceImpl.ReadAt(b, off)
+	}
+
+	mp.lock.RLock()
+	defer mp.lock.RUnlock()
+
+	// the torrent notices the piece is incomplete when the read fails, and downloads it again
+	if mp.data == nil {
+		return 0, fmt.Errorf("Piece %d was evicted from memory", p.index)
+	}
+
+	if off >= int64(len(mp.data)) {
+		return 0, fmt.Errorf("Read past end of piece %d", p.index)
+	}
+
+	return copy(b, mp.data[off:]), nil
+}
+
+func (p *fallbackPiece) WriteAt(b []byte, off int64) (n int, err error) {
+	mp := p.memory()
+	if mp == nil {
+		n, err = p.PieceImpl.WriteAt(b, off)
+		if err == nil {
+			return
+		}
+
+		mp, err = p.toMemory(err)
+		if err != nil {
+			return
+		}
+	}
+
+	if err = p.reload(mp); err != nil {
+		return
+	}
+
+	mp.lock.Lock()
+	defer mp.lock.Unlock()
+
+	if off >= int64(len(mp.data)) {
+		return 0, fmt.Errorf("Write past end of piece %d", p.index)
+	}
+
+	return copy(mp.data[off:], b), nil
+}
+
+func (p *fallbackPiece) MarkComplete() error {
+	mp := p.memory()
+	if mp == nil {
+		err := p.PieceImpl.MarkComplete()
+		if err == nil {
+			return nil
+		}
+
+		// the completion db is on the same disk, so remember the completion in memory instead
+		mp, err = p.toMemory(err)
+		if err != nil {
+			return err
+		}
+	}
+
+	mp.lock.Lock()
+	defer mp.lock.Unlock()
+
+	if mp.data == nil {
+		return fmt.Errorf("Piece %d was evicted from memory", p.index)
+	}
+	mp.complete = true
+
+	return nil
+}
+
+func (p *fallbackPiece) MarkNotComplete() error {
+	mp := p.memory()
+	if mp == nil {
+		return p.PieceImpl.MarkNotComplete()
+	}
+
+	mp.lock.Lock()
+	mp.complete = false
+	mp.lock.Unlock()
+
+	return nil
+}
+
+func (p *fallbackPiece) Completion() storage.Completion {
+	mp := p.memory()
+	if mp == nil {
+		return p.PieceImpl.Completion()
+	}
+
+	mp.lock.RLock()
+	defer mp.lock.RUnlock()
+
+	return storage.Completion{Complete: mp.complete, Ok: true}
+}
@@ -0,0 +1,218 @@
+package proxy
+
+import (
+	"bytes"
+	"errors"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/anacrolix/torrent/metainfo"
+	"github.com/anacrolix/torrent/storage"
+)
+
+// A disk that can be made read-only.
+type fakeDisk struct {
+	readOnly bool
+	data     map[int][]byte
+}
+
+type fakeDiskTorrent struct {
+	disk *fakeDisk
+}
+
+type fakeDiskPiece struct {
+	disk     *fakeDisk
+	index    int
+	complete bool
+}
+
+var errReadOnly = errors.New("read-only file system")
+
+func (d *fakeDisk) OpenTorrent(info *metainfo.Info, infoHash metainfo.Hash) (storage.TorrentImpl, error) {
+	return &fakeDiskTorrent{disk: d}, nil
+}
+
+func (d *fakeDisk) Close() error { return nil }
+
+func (t *fakeDiskTorrent) Piece(p metainfo.Piece) storage.PieceImpl {
+	if t.disk.data[p.Index()] == nil {
+		t.disk.data[p.Index()] = make([]byte, p.Length())
+	}
+	return &fakeDiskPiece{disk: t.disk, index: p.Index()}
+}
+
+func (t *fakeDiskTorrent) Close() error { return nil }
+
+func (p *fakeDiskPiece) ReadAt(b []byte, off int64) (int, error) {
+	return copy(b, p.disk.data[p.index][off:]), nil
+}
+
+func (p *fakeDiskPiece) WriteAt(b []byte, off int64) (int, error) {
+	if p.disk.readOnly {
+		return 0, errReadOnly
+	}
+	return copy(p.disk.data[p.index][off:], b), nil
+}
+
+func (p *fakeDiskPiece) MarkComplete() error {
+	if p.disk.readOnly {
+		return errReadOnly
+	}
+	p.complete = true
+	return nil
+}
+
+func (p *fakeDiskPiece) MarkNotComplete() error {
+	p.complete = false
+	return nil
+}
+
+func (p *fakeDiskPiece) Completion() storage.Completion {
+	return storage.Completion{Complete: p.complete, Ok: true}
+}
+
+var _ = Describe("fallbackStorage", func() {
+	var (
+		disk     *fakeDisk
+		s        *fallbackStorage
+		t        storage.TorrentImpl
+		info     *metainfo.Info
+		degraded []error
+	)
+
+	BeforeEach(func() {
+		disk = &fakeDisk{data: make(map[int][]byte)}
+		degraded = nil
+		s = newFallbackStorage(disk, 32, func(err error) {
+			degraded = append(degraded, err)
+		})
+
+		info = &metainfo.Info{
+			PieceLength: 16,
+			Pieces:      make([]byte, 20*4),
+			Length:      64,
+		}
+
+		var err error
+		t, err = s.OpenTorrent(info, metainfo.Hash{})
+		Expect(err).To(Succeed())
+	})
+
+	It("writes to disk while it is writable", func() {
+		_, err := t.Piece(info.Piece(0)).WriteAt([]byte("hello"), 0)
+
+		Expect(err).To(Succeed())
+		Expect(string(disk.data[0][:5])).To(Equal("hello"))
+		Expect(s.Degraded()).To(BeNil())
+	})
+
+	It("falls back to memory when the disk becomes read-only", func() {
+		piece := t.Piece(info.Piece(0))
+		piece.WriteAt([]byte("hello"), 0)
+
+		disk.readOnly = true
+
+		n, err := piece.WriteAt([]byte("world"), 5)
+		Expect(err).To(Succeed())
+		Expect(n).To(Equal(5))
+		Expect(piece.MarkComplete()).To(Succeed())
+
+		// a fresh handle to the same piece sees the memory copy, including what was on disk
+		buf := make([]byte, 10)
+		t.Piece(info.Piece(0)).ReadAt(buf, 0)
+		Expect(string(buf)).To(Equal("helloworld"))
+		Expect(t.Piece(info.Piece(0)).Completion().Complete).To(BeTrue())
+
+		Expect(s.Degraded()).To(MatchError(errReadOnly))
+		Expect(degraded).To(HaveLen(1))
+	})
+
+	It("stops accepting writes once the memory limit is reached by pieces being read", func() {
+		s.windows = func() []readWindow {
+			return []readWindow{{offset: 0, length: 32}}
+		}
+		disk.readOnly = true
+
+		_, err := t.Piece(info.Piece(0)).WriteAt([]byte("a"), 0)
+		Expect(err).To(Succeed())
+		Expect(t.Piece(info.Piece(0)).MarkComplete()).To(Succeed())
+		_, err = t.Piece(info.Piece(1)).WriteAt([]byte("b"), 0)
+		Expect(err).To(Succeed())
+		Expect(t.Piece(info.Piece(1)).MarkComplete()).To(Succeed())
+		_, err = t.Piece(info.Piece(2)).WriteAt([]byte("c"), 0)
+		Expect(err).To(HaveOccurred())
+
+		Expect(degraded).To(HaveLen(1))
+	})
+
+	It("doesn't evict pieces that are still being downloaded", func() {
+		disk.readOnly = true
+
+		t.Piece(info.Piece(0)).WriteAt([]byte("a"), 0)
+		t.Piece(info.Piece(1)).WriteAt([]byte("b"), 0)
+		_, err := t.Piece(info.Piece(2)).WriteAt([]byte("c"), 0)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("evicts the least recently used complete piece to make room", func() {
+		disk.readOnly = true
+
+		for _, i := range []int{0, 1} {
+			t.Piece(info.Piece(i)).WriteAt([]byte("a"), 0)
+			t.Piece(info.Piece(i)).MarkComplete()
+		}
+		// piece 0 was read more recently than piece 1
+		t.Piece(info.Piece(0)).ReadAt(make([]byte, 1), 0)
+
+		_, err := t.Piece(info.Piece(2)).WriteAt([]byte("c"), 0)
+		Expect(err).To(Succeed())
+
+		Expect(t.Piece(info.Piece(0)).Completion().Complete).To(BeTrue())
+		Expect(t.Piece(info.Piece(1)).Completion().Complete).To(BeFalse())
+		_, err = t.Piece(info.Piece(1)).ReadAt(make([]byte, 1), 0)
+		Expect(err).To(MatchError(ContainSubstring("evicted")))
+		Expect(t.Piece(info.Piece(1)).MarkComplete()).NotTo(Succeed())
+	})
+
+	It("streams a file ten times larger than the memory limit", func() {
+		info = &metainfo.Info{
+			PieceLength: 16,
+			Pieces:      make([]byte, 20*20),
+			Length:      320,
+		}
+		t, _ = s.OpenTorrent(info, metainfo.Hash{})
+		disk.readOnly = true
+
+		// a reader moving through the file, one piece at a time
+		var pos int64
+		s.windows = func() []readWindow {
+			return []readWindow{{offset: pos, length: 16}}
+		}
+
+		for i := 0; i < info.NumPieces(); i++ {
+			pos = int64(i) * info.PieceLength
+			piece := t.Piece(info.Piece(i))
+
+			data := bytes.Repeat([]byte{byte(i)}, int(info.PieceLength))
+			n, err := piece.WriteAt(data, 0)
+			Expect(err).To(Succeed())
+			Expect(n).To(Equal(len(data)))
+			Expect(piece.MarkComplete()).To(Succeed())
+
+			buf := make([]byte, info.PieceLength)
+			_, err = piece.ReadAt(buf, 0)
+			Expect(err).To(Succeed())
+			Expect(buf).To(Equal(data))
+
+			Expect(s.used).To(BeNumerically("<=", s.limit))
+		}
+
+		// what was read long ago is downloaded again if it's read again
+		Expect(t.Piece(info.Piece(0)).Completion().Complete).To(BeFalse())
+
+		pos = 0
+		_, err := t.Piece(info.Piece(0)).WriteAt([]byte("again"), 0)
+		Expect(err).To(Succeed())
+	})
+})
@@ -0,0 +1,25 @@
+package proxy
+
+import (
+	"github.com/anacrolix/torrent"
+)
+
+// Prioritize the first and last pieces of a file.
+//
+// Media players read the head and tail of a file, for headers and indexes like MP4 moov atoms
+// and MKV cues, before they start playing from the beginning.
+func (p *TorrentProxy) prioritizeEnds(file torrent.File, pieces int) {
+	if pieces <= 0 {
+		return
+	}
+
+	size := int64(pieces) * p.torrent.Info().PieceLength
+
+	if file.Length() <= 2*size {
+		file.PrioritizeRegion(0, file.Length())
+		return
+	}
+
+	file.PrioritizeRegion(0, size)
+	file.PrioritizeRegion(file.Length()-size, size)
+}
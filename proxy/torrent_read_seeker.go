@@ -1,20 +1,63 @@
 package proxy
 
 import (
-	"errors"
+	"context"
 	"github.com/anacrolix/torrent"
 	"io"
+	"sync/atomic"
+	"time"
 )
 
 // Impelment the ReadSeeker interface for a given file in the torrent.
 type torrentReadSeeker struct {
+	// the torrent offset of the latest read, accessed atomically so it comes first for alignment
+	pos int64
+
 	Reader *torrent.Reader
 	File   *torrent.File
+	// how many bytes past its position Reader asks the swarm for
+	Readahead int64
+
+	// If set, prioritization is coalesced with other requests from the same session.
+	Coalescer *rangeCoalescer
+	Session   string
+
+	// If set, reads are abandoned when this is done, e.g. when the HTTP client goes away.
+	Context context.Context
+
+	// If set, a single read that can't get its pieces within this long fails.
+	Timeout time.Duration
+	// Set if a read failed because of Timeout.
+	TimedOut bool
+
+	// If set, each read gets a span, a child of any span in Context.
+	Tracer Tracer
+
+	// If set, identical reads by other readers at the same time share one read of the torrent.
+	Reads *readGroup
+}
+
+// Create a ReadSeeker for a file with its own reader.
+//
+// readahead is how many bytes past the read position the reader asks the swarm for.
+// The reader is responsive, so a seek into an undownloaded region returns data as soon as
+// the first piece arrives rather than waiting for the whole readahead window.
+func newTorrentReadSeeker(t *torrent.Torrent, file *torrent.File, readahead int64) *torrentReadSeeker {
+	reader := t.NewReader()
+	reader.SetReadahead(readahead)
+	reader.SetResponsive()
+
+	return &torrentReadSeeker{
+		Reader:    reader,
+		File:      file,
+		Readahead: readahead,
+	}
 }
 
 // Read the requested data from a file in the torrent.
 //
-// This will block until the requested data has been downloaded from the swarm.
+// This will block until the requested data has been downloaded from the swarm, Context is done,
+// or Timeout passes.
 func (trs *torrentReadSeeker) Read(p []byte) (n int, err error) {
 	// if there was no seek before the call to us
 	// make sure we are at byte 0 of the file
@@ -22,6 +65,8 @@ func (trs *torrentReadSeeker) Read(p []byte) (n int, err error) {
 		trs.Seek(0, io.SeekStart)
 	}
 
+	atomic.StoreInt64(&trs.pos, trs.Reader.CurrentPos())
+
 	bufsize := int64(len(p))
 
 	eof := trs.File.Offset() + trs.File.Length()
@@ -29,16 +74,93 @@ func (trs *torrentReadSeeker) Read(p []byte) (n int, err error) {
 		bufsize = eof - trs.Reader.CurrentPos()
 	}
 
-	if bufsize == 0 {
-		return 0, errors.New("EOF")
+	if bufsize <= 0 {
+		return 0, io.EOF
+	}
+
+	pos := trs.Reader.CurrentPos()
+
+	ctx := trs.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	if trs.Tracer != nil {
+		var span Span
+		ctx, span = trs.Tracer.Start(ctx, "torrent.read")
+		span.SetAttribute("file.path", filePath(*trs.File))
+		span.SetAttribute("file.offset", pos-trs.File.Offset())
+		span.SetAttribute("file.length", bufsize)
+		defer func() {
+			span.SetAttribute("torrent.read_bytes", n)
+			if err == io.EOF {
+				span.End(nil)
+			} else {
+				span.End(err)
+			}
+		}()
+	}
+
+	if trs.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, trs.Timeout)
+		defer cancel()
 	}
 
-	buf := make([]byte, bufsize)
+	// the reader may return less than we asked for, and we don't want to hide its errors
+	read := func() ([]byte, error) {
+		trs.prioritize(pos, bufsize)
+		n, err := trs.Reader.ReadContext(ctx, p[:bufsize])
+		return p[:n], err
+	}
+
+	if trs.Reads != nil {
+		n, err = trs.sharedRead(ctx, pos, bufsize, p, read)
+	} else {
+		var data []byte
+		data, err = read()
+		n = len(data)
+	}
+
+	if err != nil && ctx.Err() == context.DeadlineExceeded {
+		trs.TimedOut = true
+	}
+
+	return
+}
+
+// Prioritize the region of the torrent about to be read.
+func (trs *torrentReadSeeker) prioritize(pos int64, length int64) {
+	if trs.Coalescer != nil {
+		trs.Coalescer.Prioritize(trs.Session, pos-trs.File.Offset(), length, trs.File.Length(), trs.File.PrioritizeRegion)
+	} else {
+		trs.File.PrioritizeRegion(pos-trs.File.Offset(), length)
+	}
+}
 
-	trs.File.PrioritizeRegion(trs.Reader.CurrentPos()-trs.File.Offset(), int64(bufsize))
+// Read length bytes at pos into p with read, or share the result of an identical read that's
+// already in flight, see readGroup.
+func (trs *torrentReadSeeker) sharedRead(ctx context.Context, pos int64, length int64, p []byte, read func() ([]byte, error)) (n int, err error) {
+	data, shared, err := trs.Reads.Do(ctx, readKey{offset: pos, length: length}, read)
+	if !shared {
+		return len(data), err
+	}
 
-	trs.Reader.Read(buf)
-	return copy(p, buf), err
+	// the other read came up empty, maybe because its client went away, so try for ourselves
+	if len(data) == 0 {
+		if ctx.Err() != nil {
+			return 0, ctx.Err()
+		}
+
+		data, err = read()
+		return len(data), err
+	}
+
+	// move our reader past the data as if we'd read it ourselves
+	n = copy(p, data)
+	_, err = trs.Reader.Seek(int64(n), io.SeekCurrent)
+
+	return
 }
 
 // Adjust seek requests to deal with the offset for multi-file torrents.
@@ -73,3 +195,8 @@ func (trs *torrentReadSeeker) Seek(offset int64, whence int) (int64, error) {
 	return pos, err
 
 }
+
+// Close the underlying reader, releasing the pieces it was prioritizing.
+func (trs *torrentReadSeeker) Close() error {
+	return trs.Reader.Close()
+}
@@ -1,7 +1,9 @@
 package proxy
 
 import (
+	"io/ioutil"
 	"os"
+	"sync"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -150,4 +152,32 @@ var _ = Describe("TorrentReadSeeker", func() {
 			Expect(trsBuf).To(Equal(midbuf))
 		})
 	})
+
+	Context("With independent readers", func() {
+		It("reads two files concurrently without interfering", func() {
+			// the fixture only has the first two files downloaded
+			files := t.Files()[:2]
+			results := make([][]byte, len(files))
+
+			var wg sync.WaitGroup
+			for i := range files {
+				wg.Add(1)
+				go func(i int) {
+					defer GinkgoRecover()
+					defer wg.Done()
+
+					trs := newTorrentReadSeeker(t, &files[i], 1<<20)
+					defer trs.Close()
+
+					results[i], _ = ioutil.ReadAll(trs)
+				}(i)
+			}
+			wg.Wait()
+
+			for i, file := range files {
+				source, _ := ioutil.ReadFile("testdata/" + file.Path())
+				Expect(results[i]).To(Equal(source))
+			}
+		})
+	})
 })
@@ -0,0 +1,129 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// Traces requests and torrent operations, see Config.Tracer.
+//
+// It's deliberately small, so an OpenTelemetry tracer, exporting wherever it's configured to,
+// can be adapted to it in a few lines.
+type Tracer interface {
+	// Start a span named name as a child of the span in ctx, if any, and return a context holding it.
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// An operation being traced.
+type Span interface {
+	// Tag the span, e.g. with "torrent.infohash".
+	SetAttribute(key string, value interface{})
+	// Finish the span, recording err if the operation failed.
+	End(err error)
+}
+
+// The span used when there's no Tracer.
+type noopSpan struct{}
+
+func (noopSpan) SetAttribute(key string, value interface{}) {}
+func (noopSpan) End(err error)                              {}
+
+// Start a span with the configured Tracer, tagged with the torrent's infohash once it's known.
+func (p *TorrentProxy) startSpan(ctx context.Context, name string) (context.Context, Span) {
+	if p.config.Tracer == nil {
+		return ctx, noopSpan{}
+	}
+
+	ctx, span := p.config.Tracer.Start(ctx, name)
+	if p.hasStarted() {
+		span.SetAttribute("torrent.infohash", p.torrent.InfoHash().HexString())
+	}
+
+	return ctx, span
+}
+
+// Describe where a torrent URL points without its path or query, which may hold a passkey.
+func torrentSource(input string) string {
+	u, err := url.Parse(input)
+	if err != nil || len(u.Scheme) == 0 {
+		return "unknown"
+	}
+
+	if len(u.Host) == 0 {
+		return u.Scheme
+	}
+
+	return u.Scheme + "://" + u.Host
+}
+
+// Records the status code and size of a response for its span.
+type statusWriter struct {
+	http.ResponseWriter
+	code    int
+	written int64
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if w.code == 0 {
+		w.code = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(b []byte) (n int, err error) {
+	if w.code == 0 {
+		w.code = 200
+	}
+	n, err = w.ResponseWriter.Write(b)
+	w.written += int64(n)
+	return
+}
+
+// The span of an HTTP request.
+type requestSpan struct {
+	Span
+	// nil if there's no Tracer
+	sw *statusWriter
+}
+
+// Finish the span with the status code and size of the response.  5xx responses are errors.
+func (s *requestSpan) finish() {
+	if s.sw == nil {
+		return
+	}
+
+	code := s.sw.code
+	if code == 0 {
+		code = 200
+	}
+	s.SetAttribute("http.status_code", code)
+	s.SetAttribute("http.response_size", s.sw.written)
+
+	var err error
+	if code >= 500 {
+		err = fmt.Errorf("%d %s", code, http.StatusText(code))
+	}
+	s.End(err)
+}
+
+// Start the span of an HTTP request, returning the writer and request to serve it with.
+//
+// The span is a child of any span already in the request's context, e.g. from OpenTelemetry's
+// HTTP middleware, and the spans of everything the request reads are children of it.
+func (p *TorrentProxy) traceRequest(w http.ResponseWriter, r *http.Request) (http.ResponseWriter, *http.Request, *requestSpan) {
+	if p.config.Tracer == nil {
+		return w, r, &requestSpan{Span: noopSpan{}}
+	}
+
+	ctx, span := p.startSpan(r.Context(), "http.request")
+	span.SetAttribute("http.method", r.Method)
+	span.SetAttribute("http.target", r.URL.Path)
+	if rng := r.Header.Get("Range"); len(rng) > 0 {
+		span.SetAttribute("http.range", rng)
+	}
+
+	sw := &statusWriter{ResponseWriter: w}
+	return sw, r.WithContext(ctx), &requestSpan{Span: span, sw: sw}
+}
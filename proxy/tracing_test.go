@@ -0,0 +1,77 @@
+package proxy
+
+import (
+	"context"
+	"sync"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// A span kept by recordingTracer.
+type recordedSpan struct {
+	name       string
+	parent     *recordedSpan
+	attributes map[string]interface{}
+	err        error
+	ended      bool
+}
+
+func (s *recordedSpan) SetAttribute(key string, value interface{}) {
+	s.attributes[key] = value
+}
+
+func (s *recordedSpan) End(err error) {
+	s.err = err
+	s.ended = true
+}
+
+type spanKey struct{}
+
+// Keeps every span it starts, for tests to look at.
+type recordingTracer struct {
+	lock  sync.Mutex
+	spans []*recordedSpan
+}
+
+func (t *recordingTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	parent, _ := ctx.Value(spanKey{}).(*recordedSpan)
+	span := &recordedSpan{name: name, parent: parent, attributes: make(map[string]interface{})}
+	t.spans = append(t.spans, span)
+
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+// Return the spans named name.
+func (t *recordingTracer) named(name string) (spans []*recordedSpan) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	for _, span := range t.spans {
+		if span.name == name {
+			spans = append(spans, span)
+		}
+	}
+	return
+}
+
+var _ = Describe("Tracing", func() {
+	It("describes torrent URLs without their secrets", func() {
+		Expect(torrentSource("https://tracker.example.com/download/1?passkey=secret")).To(Equal("https://tracker.example.com"))
+		Expect(torrentSource("magnet:?xt=urn:btih:adecafcafeadecafcafeadecafcafeadecafcafe")).To(Equal("magnet"))
+		Expect(torrentSource("::")).To(Equal("unknown"))
+	})
+
+	It("doesn't trace without a Tracer", func() {
+		p := &TorrentProxy{config: &Config{}}
+
+		ctx := context.Background()
+		spanCtx, span := p.startSpan(ctx, "test")
+
+		Expect(spanCtx).To(Equal(ctx))
+		Expect(span).To(Equal(noopSpan{}))
+	})
+})
@@ -0,0 +1,318 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anacrolix/torrent/bencode"
+)
+
+// How long a tracker's scrape is reused before asking it again.  Trackers ask not to be
+// scraped more often than they're announced to, which is usually every half hour or so.
+const trackerScrapeInterval = 5 * time.Minute
+
+// The most a scrape can take, so /trackers can't hang on a dead tracker.
+const trackerScrapeTimeout = 10 * time.Second
+
+// What a tracker last said about the swarm, see GET /trackers.
+type TrackerStatus struct {
+	// The tracker's announce URL, redacted as in TorrentStatus.Trackers
+	URL string `json:"url"`
+	// The tier it's in, trackers in lower tiers are tried first
+	Tier int `json:"tier"`
+	// When the tracker was last scraped, if it has been
+	LastScrape *time.Time `json:"lastScrape,omitempty"`
+	// Peers with the whole torrent
+	Seeders int `json:"seeders"`
+	// Peers still downloading
+	Leechers int `json:"leechers"`
+	// How many times the torrent has been downloaded in full
+	Completed int `json:"completed"`
+	// Why the last scrape failed, if it did
+	Error string `json:"error,omitempty"`
+}
+
+// Return what each of the torrent's trackers says about the swarm, scraping those that
+// haven't been for trackerScrapeInterval.
+//
+// Blocks until the scrapes are done, or trackerScrapeTimeout.
+func (p *TorrentProxy) Trackers() []*TrackerStatus {
+	t := p.currentTorrent()
+	if t == nil {
+		return make([]*TrackerStatus, 0)
+	}
+
+	p.trackerLock.Lock()
+	if p.trackerStatus == nil {
+		p.trackerStatus = make([]*TrackerStatus, 0)
+		for tier, urls := range p.trackers {
+			for _, u := range urls {
+				p.trackerStatus = append(p.trackerStatus, &TrackerStatus{URL: u, Tier: tier})
+			}
+		}
+	}
+
+	var stale []*TrackerStatus
+	for _, status := range p.trackerStatus {
+		if status.LastScrape == nil || time.Since(*status.LastScrape) > trackerScrapeInterval {
+			stale = append(stale, status)
+		}
+	}
+	p.trackerLock.Unlock()
+
+	infoHash := t.InfoHash()
+	var wg sync.WaitGroup
+	for _, status := range stale {
+		wg.Add(1)
+		go func(status *TrackerStatus) {
+			defer wg.Done()
+
+			seeders, leechers, completed, err := scrapeTracker(status.URL, infoHash, p.config.DNSResolver)
+			scraped := time.Now().UTC()
+
+			p.trackerLock.Lock()
+			defer p.trackerLock.Unlock()
+
+			status.LastScrape = &scraped
+			status.Error = ""
+			if err != nil {
+				log.Printf("Unable to scrape %s: %s", trackerHost(status.URL), err)
+				status.Error = err.Error()
+				return
+			}
+			status.Seeders, status.Leechers, status.Completed = seeders, leechers, completed
+		}(status)
+	}
+	wg.Wait()
+
+	p.trackerLock.Lock()
+	defer p.trackerLock.Unlock()
+
+	// the URLs go to anyone with a read token, so not their passkeys
+	statuses := make([]*TrackerStatus, 0, len(p.trackerStatus))
+	for _, status := range p.trackerStatus {
+		copied := *status
+		copied.URL = redactTrackerURL(status.URL)
+		statuses = append(statuses, &copied)
+	}
+
+	return statuses
+}
+
+// Return the host of a tracker URL, for logging without a passkey in its path.
+func trackerHost(tracker string) string {
+	u, err := url.Parse(tracker)
+	if err != nil {
+		return "tracker"
+	}
+
+	return u.Host
+}
+
+// Return a tracker URL with the query and userinfo, where private trackers put passkeys, replaced
+// by redactedValue.  One that can't be parsed is replaced entirely.
+func redactTrackerURL(tracker string) string {
+	u, err := url.Parse(tracker)
+	if err != nil {
+		return redactedValue
+	}
+
+	if u.User != nil {
+		u.User = url.User(redactedValue)
+	}
+	if len(u.RawQuery) > 0 {
+		u.RawQuery = redactedValue
+	}
+
+	return u.String()
+}
+
+// Return err without the URL a *url.Error quotes, which holds the tracker's passkey, if it has one.
+func scrapeURLError(err error) error {
+	if uerr, ok := err.(*url.Error); ok {
+		return fmt.Errorf("%s: %s", uerr.Op, uerr.Err)
+	}
+
+	return err
+}
+
+// Ask a tracker how many peers it knows of for infoHash, with the scrape of BEP 48 or BEP 15.
+func scrapeTracker(tracker string, infoHash [20]byte, resolver *net.Resolver) (seeders, leechers, completed int, err error) {
+	u, err := url.Parse(tracker)
+	if err != nil {
+		return
+	}
+
+	switch u.Scheme {
+	case "http", "https":
+		return scrapeHTTPTracker(u, infoHash, newHTTPClient(resolver))
+	case "udp":
+		var addr *net.UDPAddr
+		if resolver == nil {
+			addr, err = net.ResolveUDPAddr("udp", u.Host)
+		} else {
+			addr, err = resolveUDPAddr(resolver, u.Host)
+		}
+		if err != nil {
+			return
+		}
+		return scrapeUDPTracker(addr, infoHash)
+	default:
+		return 0, 0, 0, fmt.Errorf("Unsupported tracker scheme: %s", u.Scheme)
+	}
+}
+
+// Scrape an HTTP tracker, by the convention of replacing "announce" in its path with "scrape".
+func scrapeHTTPTracker(u *url.URL, infoHash [20]byte, client *http.Client) (seeders, leechers, completed int, err error) {
+	dir, file := path.Split(u.Path)
+	if !strings.HasPrefix(file, "announce") {
+		return 0, 0, 0, fmt.Errorf("Tracker doesn't support scrape")
+	}
+
+	scrape := *u
+	scrape.Path = dir + "scrape" + strings.TrimPrefix(file, "announce")
+	query := scrape.Query()
+	query.Set("info_hash", string(infoHash[:]))
+	scrape.RawQuery = query.Encode()
+
+	ctx, cancel := context.WithTimeout(context.Background(), trackerScrapeTimeout)
+	defer cancel()
+
+	req, err := http.NewRequest("GET", scrape.String(), nil)
+	if err != nil {
+		return 0, 0, 0, scrapeURLError(err)
+	}
+
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return 0, 0, 0, scrapeURLError(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return 0, 0, 0, fmt.Errorf("Tracker responded %d", resp.StatusCode)
+	}
+
+	var response struct {
+		Files map[string]struct {
+			Complete   int `bencode:"complete"`
+			Incomplete int `bencode:"incomplete"`
+			Downloaded int `bencode:"downloaded"`
+		} `bencode:"files"`
+		FailureReason string `bencode:"failure reason"`
+	}
+	err = bencode.NewDecoder(resp.Body).Decode(&response)
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("Invalid scrape response: %s", err)
+	}
+
+	if len(response.FailureReason) > 0 {
+		return 0, 0, 0, fmt.Errorf("Tracker failed: %s", response.FailureReason)
+	}
+
+	file, ok := response.Files[string(infoHash[:])]
+	if !ok {
+		return 0, 0, 0, fmt.Errorf("Tracker doesn't know the torrent")
+	}
+
+	return file.Complete, file.Incomplete, file.Downloaded, nil
+}
+
+// The actions of the UDP tracker protocol.
+const (
+	udpTrackerConnect = 0
+	udpTrackerScrape  = 2
+	udpTrackerError   = 3
+)
+
+// Scrape a UDP tracker, connecting first as BEP 15 requires.
+func scrapeUDPTracker(addr *net.UDPAddr, infoHash [20]byte) (seeders, leechers, completed int, err error) {
+	conn, err := net.DialUDP("udp", nil, addr)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(trackerScrapeTimeout))
+
+	// the magic number that says we're speaking the protocol
+	var connectionID uint64 = 0x41727101980
+	reply, err := udpTrackerRequest(conn, connectionID, udpTrackerConnect, nil)
+	if err != nil {
+		return
+	}
+	if len(reply) < 8 {
+		return 0, 0, 0, fmt.Errorf("Short connect response")
+	}
+	connectionID = binary.BigEndian.Uint64(reply)
+
+	reply, err = udpTrackerRequest(conn, connectionID, udpTrackerScrape, infoHash[:])
+	if err != nil {
+		return
+	}
+	if len(reply) < 12 {
+		return 0, 0, 0, fmt.Errorf("Short scrape response")
+	}
+
+	seeders = int(binary.BigEndian.Uint32(reply[0:]))
+	completed = int(binary.BigEndian.Uint32(reply[4:]))
+	leechers = int(binary.BigEndian.Uint32(reply[8:]))
+	return
+}
+
+// Send a request to a UDP tracker and return the body of its response, after the action and transaction ID.
+func udpTrackerRequest(conn *net.UDPConn, connectionID uint64, action uint32, body []byte) (reply []byte, err error) {
+	transactionID := rand.Uint32()
+
+	var req bytes.Buffer
+	binary.Write(&req, binary.BigEndian, connectionID)
+	binary.Write(&req, binary.BigEndian, action)
+	binary.Write(&req, binary.BigEndian, transactionID)
+	req.Write(body)
+
+	_, err = conn.Write(req.Bytes())
+	if err != nil {
+		return
+	}
+
+	buf := make([]byte, 1024)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		if n < 8 || binary.BigEndian.Uint32(buf[4:]) != transactionID {
+			// a late response to something else
+			continue
+		}
+
+		switch binary.BigEndian.Uint32(buf) {
+		case action:
+			return buf[8:n], nil
+		case udpTrackerError:
+			return nil, fmt.Errorf("Tracker failed: %s", buf[8:n])
+		default:
+			return nil, fmt.Errorf("Unexpected tracker response")
+		}
+	}
+}
+
+// Serve the TrackerStatus of each tracker as JSON.
+func (p *TorrentProxy) serveTrackers(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(p.Trackers())
+
+	log.Printf("%d %s", 200, r.URL.Path)
+}
@@ -0,0 +1,127 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+// Add a torrent from the metainfo in a torrent file, as for TorrentURLs.
+//
+// The metainfo is kept in DataDir like that of a magnet, and the torrent is added by its
+// magnet URL, so it's found again on restart without the file.
+func (m *ProxyManager) addMetainfo(data []byte) (p *TorrentProxy, err error) {
+	mi, err := metainfo.Load(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("Not a valid torrent file: %s", err)
+	}
+
+	info, err := mi.UnmarshalInfo()
+	if err != nil {
+		return nil, fmt.Errorf("Not a valid torrent file: %s", err)
+	}
+
+	// nothing may have been downloaded yet to create it
+	err = os.MkdirAll(filepath.Dir(metainfoCacheFile(m.config.DataDir, mi.HashInfoBytes())), 0755)
+	if err == nil {
+		err = saveCachedMetainfo(*mi, m.config.DataDir)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Unable to keep torrent file: %s", err)
+	}
+
+	magnet := mi.Magnet(info.Name, mi.HashInfoBytes())
+	return m.addURL(magnet.String())
+}
+
+// Return the torrent file in the body of a POST /torrents, either as it is or as the first
+// file of a multipart form.  Reads at most maxSize bytes.
+func readUploadedTorrent(r *http.Request, maxSize int64) (data []byte, err error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+	var body io.Reader = r.Body
+	if mediaType == "multipart/form-data" {
+		reader, err := r.MultipartReader()
+		if err != nil {
+			return nil, err
+		}
+
+		for {
+			part, err := reader.NextPart()
+			if err == io.EOF {
+				return nil, fmt.Errorf("No torrent file in the form")
+			}
+			if err != nil {
+				return nil, err
+			}
+
+			if len(part.FileName()) > 0 || part.FormName() == "torrent" {
+				body = part
+				break
+			}
+		}
+	}
+
+	// one more than allowed, to tell a file that's exactly the limit from one that's over it
+	data, err = ioutil.ReadAll(io.LimitReader(body, maxSize+1))
+	if err != nil {
+		return
+	}
+	if int64(len(data)) > maxSize {
+		return nil, fmt.Errorf("Torrent file is larger than %d bytes", maxSize)
+	}
+
+	return
+}
+
+// Add the torrent file in the body of a POST, and serve its TorrentStatus as JSON.
+func (m *ProxyManager) serveUpload(w http.ResponseWriter, r *http.Request) {
+	if serveUnauthorized(w, r, roleAdmin, m.config.ReadToken, m.config.AdminToken, log.Printf) {
+		return
+	}
+
+	if r.Method != "POST" {
+		log.Printf("%d %s %s", 405, r.Method, r.URL.Path)
+
+		w.Header().Set("Allow", "POST")
+		writeError(w, r, 405, "Method Not Allowed", nil)
+		return
+	}
+
+	data, err := readUploadedTorrent(r, m.config.MaxTorrentFileSize)
+	if err != nil {
+		log.Printf("%d %s %s: %s", 400, r.Method, r.URL.Path, err)
+
+		writeError(w, r, 400, err.Error(), nil)
+		return
+	}
+
+	p, err := m.addMetainfo(data)
+	if err != nil {
+		code := 400
+		if strings.HasPrefix(err.Error(), "Already added") {
+			code = 409
+		}
+		log.Printf("%d %s %s: %s", code, r.Method, r.URL.Path, err)
+
+		writeError(w, r, code, err.Error(), nil)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", p.URL()+"/")
+	w.WriteHeader(201)
+	json.NewEncoder(w).Encode(p.Status())
+
+	log.Printf("%d %s %s", 201, r.Method, r.URL.Path)
+}
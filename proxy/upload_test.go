@@ -0,0 +1,97 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"os"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+var _ = Describe("Uploading torrent files", func() {
+	var (
+		dir  string
+		m    *ProxyManager
+		data []byte
+		hash string
+	)
+
+	BeforeEach(func() {
+		dir, _ = ioutil.TempDir("", "evaporation-upload")
+
+		var err error
+		m, err = NewProxyManager(&Config{
+			TorrentListenAddr: "localhost:0",
+			DataDir:           dir,
+		})
+		Expect(err).To(Succeed())
+
+		data, _ = ioutil.ReadFile("testdata/sample.torrent")
+		mi, _ := metainfo.LoadFromFile("testdata/sample.torrent")
+		hash = mi.HashInfoBytes().HexString()
+	})
+
+	AfterEach(func() {
+		m.Close()
+		os.RemoveAll(dir)
+	})
+
+	It("adds a torrent file posted as it is", func() {
+		resp, err := http.Post(m.URL()+"/torrents", "application/x-bittorrent", bytes.NewReader(data))
+		Expect(err).To(Succeed())
+		defer resp.Body.Close()
+		Expect(resp.StatusCode).To(Equal(201))
+		Expect(resp.Header.Get("Location")).To(Equal(m.URL() + "/" + hash + "/"))
+
+		var s TorrentStatus
+		json.NewDecoder(resp.Body).Decode(&s)
+		Expect(s.Hash).To(Equal(hash))
+
+		p, ok := m.Get(hash)
+		Expect(ok).To(BeTrue())
+		Expect(p.torrent.Info()).NotTo(BeNil())
+		Expect(metainfoCacheFile(dir, p.torrent.InfoHash())).To(BeAnExistingFile())
+
+		resp, err = http.Post(m.URL()+"/torrents", "application/x-bittorrent", bytes.NewReader(data))
+		Expect(err).To(Succeed())
+		resp.Body.Close()
+		Expect(resp.StatusCode).To(Equal(409))
+	})
+
+	It("adds a torrent file posted in a form", func() {
+		var body bytes.Buffer
+		form := multipart.NewWriter(&body)
+		form.WriteField("comment", "not the torrent")
+		part, _ := form.CreateFormFile("file", "sample.torrent")
+		part.Write(data)
+		form.Close()
+
+		resp, err := http.Post(m.URL()+"/torrents", form.FormDataContentType(), &body)
+		Expect(err).To(Succeed())
+		resp.Body.Close()
+		Expect(resp.StatusCode).To(Equal(201))
+
+		_, ok := m.Get(hash)
+		Expect(ok).To(BeTrue())
+	})
+
+	It("rejects what isn't a torrent file", func() {
+		resp, err := http.Post(m.URL()+"/torrents", "application/x-bittorrent", bytes.NewReader([]byte("nope")))
+		Expect(err).To(Succeed())
+		resp.Body.Close()
+		Expect(resp.StatusCode).To(Equal(400))
+		Expect(m.List()).To(BeEmpty())
+
+		resp, err = http.Get(m.URL() + "/torrents")
+		Expect(err).To(Succeed())
+		resp.Body.Close()
+		Expect(resp.StatusCode).To(Equal(405))
+		Expect(resp.Header.Get("Allow")).To(Equal("POST"))
+	})
+})
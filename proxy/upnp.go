@@ -0,0 +1,333 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The router services that can forward ports, in the order we prefer them.
+var igdServices = []string{
+	"urn:schemas-upnp-org:service:WANIPConnection:1",
+	"urn:schemas-upnp-org:service:WANPPPConnection:1",
+}
+
+// How long we ask the router to keep a port mapping.  We renew it at half this, so a mapping
+// left behind by a crash goes away on its own.
+const portMappingLease = time.Hour
+
+// How long to wait for routers to answer a search, and for each request to them.
+const upnpTimeout = 3 * time.Second
+
+// A router's WAN connection service, which port mappings are added through.
+type igdClient struct {
+	controlURL string
+	service    string
+	// our address on the router's network, where it forwards ports to
+	localIP string
+	client  *http.Client
+}
+
+// A device in a UPnP device description, and the devices inside it.
+type upnpDevice struct {
+	Services []struct {
+		ServiceType string `xml:"serviceType"`
+		ControlURL  string `xml:"controlURL"`
+	} `xml:"serviceList>service"`
+	Devices []upnpDevice `xml:"deviceList>device"`
+}
+
+// Return the control URL of the first of services found in the device or those inside it.
+func (d *upnpDevice) find(service string) (controlURL string, ok bool) {
+	for _, s := range d.Services {
+		if s.ServiceType == service {
+			return s.ControlURL, true
+		}
+	}
+
+	for i := range d.Devices {
+		if controlURL, ok = d.Devices[i].find(service); ok {
+			return
+		}
+	}
+
+	return "", false
+}
+
+// Search the LAN for a router that can forward ports.
+func discoverIGD() (*igdClient, error) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	group, _ := net.ResolveUDPAddr("udp4", ssdpAddr)
+	for _, service := range igdServices {
+		search := fmt.Sprintf("M-SEARCH * HTTP/1.1\r\nHOST: %s\r\nST: %s\r\nMAN: \"ssdp:discover\"\r\nMX: %d\r\n\r\n",
+			ssdpAddr, service, int(upnpTimeout.Seconds()))
+		_, err = conn.WriteToUDP([]byte(search), group)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	conn.SetReadDeadline(time.Now().Add(upnpTimeout))
+	buf := make([]byte, 2048)
+	for {
+		n, _, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			return nil, fmt.Errorf("No UPnP router found on the LAN")
+		}
+
+		resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(buf[:n])), nil)
+		if err != nil {
+			continue
+		}
+
+		location := resp.Header.Get("Location")
+		if len(location) == 0 {
+			continue
+		}
+
+		igd, err := newIGDClient(location)
+		if err == nil {
+			return igd, nil
+		}
+	}
+}
+
+// Read the device description at location and find the service to forward ports with.
+func newIGDClient(location string) (*igdClient, error) {
+	base, err := url.Parse(location)
+	if err != nil {
+		return nil, err
+	}
+
+	// the router forwards to whichever of our addresses it can reach us on
+	conn, err := net.Dial("udp4", base.Host)
+	if err != nil {
+		return nil, err
+	}
+	localIP, _, _ := net.SplitHostPort(conn.LocalAddr().String())
+	conn.Close()
+
+	client := &http.Client{Timeout: upnpTimeout}
+	resp, err := client.Get(location)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("Unable to read UPnP device description from %s: %s", location, resp.Status)
+	}
+
+	var root struct {
+		URLBase string     `xml:"URLBase"`
+		Device  upnpDevice `xml:"device"`
+	}
+	err = xml.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&root)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid UPnP device description from %s: %s", location, err)
+	}
+
+	if len(root.URLBase) > 0 {
+		if u, err := url.Parse(root.URLBase); err == nil {
+			base = u
+		}
+	}
+
+	for _, service := range igdServices {
+		controlURL, ok := root.Device.find(service)
+		if !ok {
+			continue
+		}
+
+		u, err := base.Parse(controlURL)
+		if err != nil {
+			return nil, err
+		}
+
+		return &igdClient{
+			controlURL: u.String(),
+			service:    service,
+			localIP:    localIP,
+			client:     client,
+		}, nil
+	}
+
+	return nil, fmt.Errorf("UPnP device at %s can't forward ports", location)
+}
+
+// Call action on the router with args, given as name, value pairs, returning the response body.
+func (g *igdClient) call(action string, args ...string) ([]byte, error) {
+	var body bytes.Buffer
+	for i := 0; i+1 < len(args); i += 2 {
+		body.WriteString("<" + args[i] + ">")
+		xml.EscapeText(&body, []byte(args[i+1]))
+		body.WriteString("</" + args[i] + ">")
+	}
+
+	envelope := fmt.Sprintf(`<?xml version="1.0" encoding="utf-8"?>`+
+		`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">`+
+		`<s:Body><u:%s xmlns:u="%s">%s</u:%s></s:Body></s:Envelope>`, action, g.service, body.String(), action)
+
+	req, err := http.NewRequest("POST", g.controlURL, strings.NewReader(envelope))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", `text/xml; charset="utf-8"`)
+	req.Header.Set("SOAPAction", fmt.Sprintf(`"%s#%s"`, g.service, action))
+
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("Router refused %s: %s", action, resp.Status)
+	}
+
+	return data, nil
+}
+
+// Ask the router to forward port on its WAN address to the same port here, for lease.
+func (g *igdClient) AddPortMapping(protocol string, port int, lease time.Duration) error {
+	_, err := g.call("AddPortMapping",
+		"NewRemoteHost", "",
+		"NewExternalPort", strconv.Itoa(port),
+		"NewProtocol", protocol,
+		"NewInternalPort", strconv.Itoa(port),
+		"NewInternalClient", g.localIP,
+		"NewEnabled", "1",
+		"NewPortMappingDescription", "evaporation",
+		"NewLeaseDuration", strconv.Itoa(int(lease.Seconds())))
+
+	return err
+}
+
+// Stop forwarding port.
+func (g *igdClient) DeletePortMapping(protocol string, port int) error {
+	_, err := g.call("DeletePortMapping",
+		"NewRemoteHost", "",
+		"NewExternalPort", strconv.Itoa(port),
+		"NewProtocol", protocol)
+
+	return err
+}
+
+// Return the router's address on the internet.
+func (g *igdClient) ExternalIP() (string, error) {
+	data, err := g.call("GetExternalIPAddress")
+	if err != nil {
+		return "", err
+	}
+
+	var envelope struct {
+		Response struct {
+			IP string `xml:"NewExternalIPAddress"`
+		} `xml:"Body>GetExternalIPAddressResponse"`
+	}
+	err = xml.Unmarshal(data, &envelope)
+	if err != nil {
+		return "", err
+	}
+
+	if net.ParseIP(envelope.Response.IP) == nil {
+		return "", fmt.Errorf("Router returned an invalid external address: %q", envelope.Response.IP)
+	}
+
+	return envelope.Response.IP, nil
+}
+
+// Forward the torrent client's port on the router until the proxy is closed, renewing the
+// mappings before they lapse, and removing them on the way out.
+func (p *TorrentProxy) runPortForwarding(port int) {
+	var igd *igdClient
+	defer func() {
+		if igd != nil {
+			igd.DeletePortMapping("TCP", port)
+			igd.DeletePortMapping("UDP", port)
+		}
+	}()
+
+	for {
+		retry := portMappingLease / 2
+
+		err := p.forwardPort(&igd, port)
+		if err != nil {
+			p.errlog.Printf("Unable to forward port %d with UPnP: %s", port, err)
+			p.setExternalAddr("")
+			igd = nil
+			retry = time.Minute
+		}
+
+		select {
+		case <-time.After(retry):
+		case <-p.closed:
+			return
+		}
+	}
+}
+
+// Add or renew the mappings for port, finding the router first if *igd is nil.
+func (p *TorrentProxy) forwardPort(igd **igdClient, port int) (err error) {
+	if *igd == nil {
+		*igd, err = discoverIGD()
+		if err != nil {
+			return
+		}
+	}
+
+	// peers connect over TCP, and uTP and the DHT share the UDP port
+	for _, protocol := range []string{"TCP", "UDP"} {
+		err = (*igd).AddPortMapping(protocol, port, portMappingLease)
+		if err != nil {
+			return
+		}
+	}
+
+	ip, err := (*igd).ExternalIP()
+	if err != nil {
+		return
+	}
+
+	addr := net.JoinHostPort(ip, strconv.Itoa(port))
+	if addr != p.getExternalAddr() {
+		log.Printf("Forwarding %s to port %d with UPnP", addr, port)
+	}
+	p.setExternalAddr(addr)
+
+	return
+}
+
+func (p *TorrentProxy) getExternalAddr() string {
+	p.externalLock.Lock()
+	defer p.externalLock.Unlock()
+
+	return p.externalAddr
+}
+
+func (p *TorrentProxy) setExternalAddr(addr string) {
+	p.externalLock.Lock()
+	defer p.externalLock.Unlock()
+
+	p.externalAddr = addr
+}
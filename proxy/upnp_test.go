@@ -0,0 +1,91 @@
+package proxy
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("UPnP port forwarding", func() {
+	var (
+		server  *httptest.Server
+		actions []string
+		bodies  []string
+	)
+
+	BeforeEach(func() {
+		actions, bodies = nil, nil
+
+		mux := http.NewServeMux()
+		mux.HandleFunc("/desc.xml", func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`<?xml version="1.0"?><root xmlns="urn:schemas-upnp-org:device-1-0"><device>` +
+				`<deviceType>urn:schemas-upnp-org:device:InternetGatewayDevice:1</deviceType><deviceList><device>` +
+				`<deviceType>urn:schemas-upnp-org:device:WANDevice:1</deviceType><deviceList><device>` +
+				`<deviceType>urn:schemas-upnp-org:device:WANConnectionDevice:1</deviceType><serviceList><service>` +
+				`<serviceType>urn:schemas-upnp-org:service:WANIPConnection:1</serviceType>` +
+				`<controlURL>/ctl/IPConn</controlURL></service></serviceList>` +
+				`</device></deviceList></device></deviceList></device></root>`))
+		})
+		mux.HandleFunc("/ctl/IPConn", func(w http.ResponseWriter, r *http.Request) {
+			body, _ := ioutil.ReadAll(r.Body)
+			actions = append(actions, r.Header.Get("SOAPAction"))
+			bodies = append(bodies, string(body))
+
+			if strings.Contains(r.Header.Get("SOAPAction"), "GetExternalIPAddress") {
+				w.Write([]byte(`<?xml version="1.0"?><s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body>` +
+					`<u:GetExternalIPAddressResponse xmlns:u="urn:schemas-upnp-org:service:WANIPConnection:1">` +
+					`<NewExternalIPAddress>203.0.113.7</NewExternalIPAddress></u:GetExternalIPAddressResponse></s:Body></s:Envelope>`))
+			}
+		})
+		server = httptest.NewServer(mux)
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("finds the WAN connection service in nested devices", func() {
+		igd, err := newIGDClient(server.URL + "/desc.xml")
+		Expect(err).To(Succeed())
+
+		Expect(igd.controlURL).To(Equal(server.URL + "/ctl/IPConn"))
+		Expect(igd.service).To(Equal("urn:schemas-upnp-org:service:WANIPConnection:1"))
+		Expect(igd.localIP).To(Equal("127.0.0.1"))
+	})
+
+	It("rejects devices that can't forward ports", func() {
+		_, err := newIGDClient(server.URL + "/ctl/IPConn")
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("adds and removes mappings to our address", func() {
+		igd, err := newIGDClient(server.URL + "/desc.xml")
+		Expect(err).To(Succeed())
+
+		Expect(igd.AddPortMapping("TCP", 6881, time.Hour)).To(Succeed())
+		Expect(igd.DeletePortMapping("TCP", 6881)).To(Succeed())
+
+		Expect(actions).To(Equal([]string{
+			`"urn:schemas-upnp-org:service:WANIPConnection:1#AddPortMapping"`,
+			`"urn:schemas-upnp-org:service:WANIPConnection:1#DeletePortMapping"`,
+		}))
+		Expect(bodies[0]).To(ContainSubstring("<NewExternalPort>6881</NewExternalPort>"))
+		Expect(bodies[0]).To(ContainSubstring("<NewInternalClient>127.0.0.1</NewInternalClient>"))
+		Expect(bodies[0]).To(ContainSubstring("<NewLeaseDuration>3600</NewLeaseDuration>"))
+	})
+
+	It("reports the external address", func() {
+		igd, err := newIGDClient(server.URL + "/desc.xml")
+		Expect(err).To(Succeed())
+
+		p := &TorrentProxy{}
+		Expect(p.forwardPort(&igd, 6881)).To(Succeed())
+		Expect(p.getExternalAddr()).To(Equal("203.0.113.7:6881"))
+		Expect(actions).To(HaveLen(3))
+	})
+})
@@ -0,0 +1,266 @@
+package proxy
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/anacrolix/torrent"
+)
+
+// A problem with one field of a Config, see Config.Validate.
+type FieldError struct {
+	// The name of the field, with the index for an element of a list, like "DHTNodes[1]"
+	Field string
+	// What's wrong with it
+	Err error
+}
+
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("Invalid %s: %s", e.Field, e.Err)
+}
+
+// Every problem Config.Validate found, in the order of the fields in Config.  Each reads
+// "Invalid Field: problem", and they're joined with "; ".
+type ConfigErrors []*FieldError
+
+func (e ConfigErrors) Error() string {
+	problems := make([]string, len(e))
+	for i, err := range e {
+		problems[i] = err.Error()
+	}
+
+	return strings.Join(problems, "; ")
+}
+
+// Check the config for every problem that can be found without starting anything: addresses
+// and ports that can't be listened on, URLs that can't be used, directories that can't be
+// written to, and values that aren't allowed.  Returns ConfigErrors, or nil if there are none.
+//
+// Unspecified fields are checked as their defaults.  A config with TorrentURLs, WatchDir or
+// Feeds is checked as for NewProxyManager, which doesn't need TorrentURL.  Nothing is fetched
+// or listened on, so a config that passes may still fail to start, e.g. if a port is taken.
+func (config *Config) Validate() error {
+	c := *config
+	applyConfigDefaults(&c)
+
+	var errs ConfigErrors
+	check := func(field string, err error) {
+		if err != nil {
+			errs = append(errs, &FieldError{Field: field, Err: err})
+		}
+	}
+
+	manager := len(c.TorrentURLs) > 0 || len(c.WatchDir) > 0 || len(c.Feeds) > 0
+	if len(c.TorrentURL) > 0 {
+		check("TorrentURL", checkTorrentURL(c.TorrentURL, c.MaxTorrentFileSize))
+	} else if len(c.BundlePath) == 0 && len(c.SeedPath) == 0 && !manager {
+		check("TorrentURL", fmt.Errorf("one of TorrentURL, BundlePath or SeedPath must be specified"))
+	}
+	for i, u := range c.TorrentURLs {
+		check(fmt.Sprintf("TorrentURLs[%d]", i), checkTorrentURL(u, c.MaxTorrentFileSize))
+	}
+	if len(c.WatchDir) > 0 {
+		check("WatchDir", checkDir(c.WatchDir))
+	}
+	for i, feed := range c.Feeds {
+		check(fmt.Sprintf("Feeds[%d]", i), checkHTTPURL(feed))
+	}
+	if len(c.FeedInclude) > 0 {
+		_, err := regexp.Compile(c.FeedInclude)
+		check("FeedInclude", err)
+	}
+	if len(c.FeedExclude) > 0 {
+		_, err := regexp.Compile(c.FeedExclude)
+		check("FeedExclude", err)
+	}
+	if len(c.TorrentURLProxy) > 0 {
+		check("TorrentURLProxy", checkHTTPURL(c.TorrentURLProxy))
+	}
+
+	for i, node := range c.DHTNodes {
+		check(fmt.Sprintf("DHTNodes[%d]", i), checkHostPort(node))
+	}
+	if !c.DisableHTTP {
+		check("HTTPListenAddr", checkListenAddr(c.HTTPListenAddr))
+	}
+	check("TorrentListenAddr", checkHostPort(c.TorrentListenAddr))
+	check("PeerTransport", checkOneOf(c.PeerTransport, TransportBoth, TransportTCP, TransportUTP))
+	check("Encryption", checkOneOf(c.Encryption, EncryptionDisabled, EncryptionPreferred, EncryptionRequired))
+	if len(c.PeerInterface) > 0 {
+		_, err := net.InterfaceByName(c.PeerInterface)
+		check("PeerInterface", err)
+	}
+	check("PeerIPVersion", checkOneOf(c.PeerIPVersion, IPBoth, IPv4Only, IPv6Only))
+	if len(c.DHTListenAddr) > 0 {
+		check("DHTListenAddr", checkHostPort(c.DHTListenAddr))
+	}
+
+	check("DataDir", checkWritableDir(c.DataDir))
+	if len(c.DataKey) > 0 {
+		_, err := parseDataKey(c.DataKey)
+		if err != nil {
+			err = fmt.Errorf("expected 64 hex digits")
+		}
+		check("DataKey", err)
+	}
+	check("Preallocate", checkOneOf(c.Preallocate, PreallocateSparse, PreallocateFull))
+	if len(c.BundlePath) > 0 {
+		_, err := os.Stat(c.BundlePath)
+		check("BundlePath", err)
+	}
+	if len(c.SeedPath) > 0 {
+		err := checkDir(c.SeedPath)
+		if err == nil && (len(c.DataKey) > 0 || c.EphemeralData) {
+			err = fmt.Errorf("can't be used with DataKey or EphemeralData")
+		}
+		check("SeedPath", err)
+	}
+
+	if c.MemoryLimit < c.Readahead {
+		check("MemoryLimit", fmt.Errorf("must be at least Readahead, %d bytes", c.Readahead))
+	}
+	if len(c.AdminListenAddr) > 0 {
+		check("AdminListenAddr", checkListenAddr(c.AdminListenAddr))
+	}
+	if len(c.PublicURL) > 0 {
+		check("PublicURL", checkHTTPURL(c.PublicURL))
+	} else if !c.DisableHTTP && isUnixSocket(c.HTTPListenAddr) {
+		check("PublicURL", fmt.Errorf("must be specified when HTTPListenAddr is a unix socket"))
+	}
+	if c.DLNA && !c.DisableHTTP && isUnixSocket(c.HTTPListenAddr) {
+		check("DLNA", fmt.Errorf("can't be used when HTTPListenAddr is a unix socket"))
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+
+	return nil
+}
+
+// Returns an error if a torrent URL can't be used, without fetching it.
+func checkTorrentURL(input string, maxSize int64) (err error) {
+	u, err := url.Parse(input)
+	if err != nil {
+		return
+	}
+
+	switch u.Scheme {
+	case "magnet":
+		_, err = torrent.TorrentSpecFromMagnetURI(input)
+	case "http", "https":
+		err = checkHTTPURL(input)
+	case "file":
+		_, err = os.Stat(u.Path)
+	case "data":
+		_, err = decodeDataURL(input, maxSize)
+	case "":
+		err = fmt.Errorf("no URL scheme in %s", input)
+	default:
+		err = fmt.Errorf("unknown URL scheme %s", u.Scheme)
+	}
+
+	return
+}
+
+// Returns an error if input isn't an absolute http(s) URL.
+func checkHTTPURL(input string) error {
+	u, err := url.Parse(input)
+	if err != nil {
+		return err
+	}
+
+	if (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+		return fmt.Errorf("not an http(s) URL: %s", input)
+	}
+
+	return nil
+}
+
+// Returns an error if addr isn't a host:port, or unix:// socket path, that could be listened on.
+func checkListenAddr(addr string) error {
+	if isUnixSocket(addr) {
+		if len(strings.TrimPrefix(addr, unixSocketPrefix)) == 0 {
+			return fmt.Errorf("no path for the unix socket")
+		}
+		return nil
+	}
+
+	return checkHostPort(addr)
+}
+
+// Returns an error if addr isn't a host:port, with a port number or name.
+func checkHostPort(addr string) error {
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+
+	_, err = net.LookupPort("tcp", port)
+	return err
+}
+
+// Returns an error if value isn't one of allowed.
+func checkOneOf(value string, allowed ...string) error {
+	for _, a := range allowed {
+		if value == a {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%q, expected one of %s", value, strings.Join(allowed, ", "))
+}
+
+// Returns an error if dir isn't a directory.
+func checkDir(dir string) error {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("not a directory: %s", dir)
+	}
+
+	return nil
+}
+
+// Returns an error if dir can't be written to.  If it doesn't exist yet, the nearest directory
+// above it that does must be writable, so it can be created.
+func checkWritableDir(dir string) error {
+	if len(dir) == 0 {
+		dir = "."
+	}
+
+	for {
+		_, err := os.Stat(dir)
+		if err == nil {
+			break
+		}
+
+		parent := filepath.Dir(dir)
+		if !os.IsNotExist(err) || parent == dir {
+			return err
+		}
+		dir = parent
+	}
+
+	err := checkDir(dir)
+	if err != nil {
+		return err
+	}
+
+	// permission bits don't tell the whole story, e.g. for read only mounts
+	f, err := ioutil.TempFile(dir, ".evaporation-check-")
+	if err != nil {
+		return fmt.Errorf("can't write to %s: %s", dir, err)
+	}
+	f.Close()
+
+	return os.Remove(f.Name())
+}
@@ -0,0 +1,98 @@
+package proxy
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Validating a Config", func() {
+	const magnet = "magnet:?xt=urn:btih:adecafcafeadecafcafeadecafcafeadecafcafe"
+
+	var dir string
+
+	BeforeEach(func() {
+		dir, _ = ioutil.TempDir("", "evaporation-validate")
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	fields := func(err error) (names []string) {
+		for _, e := range err.(ConfigErrors) {
+			names = append(names, e.Field)
+		}
+		return
+	}
+
+	It("passes a good config, checking unspecified fields as their defaults", func() {
+		Expect((&Config{TorrentURL: magnet, DataDir: dir}).Validate()).To(Succeed())
+		Expect((&Config{TorrentURLs: []string{magnet}, DataDir: filepath.Join(dir, "not", "yet")}).Validate()).To(Succeed())
+		Expect((&Config{SeedPath: dir, HTTPListenAddr: "unix://" + filepath.Join(dir, "sock"), PublicURL: "https://example.com"}).Validate()).To(Succeed())
+	})
+
+	It("reports every problem at once, by field", func() {
+		err := (&Config{
+			TorrentURL:        "unknown://protocol/here",
+			DHTNodes:          []string{"router.example.com:6881", "no-port"},
+			HTTPListenAddr:    "localhost:99999",
+			TorrentListenAddr: "localhost",
+			PeerTransport:     "carrier-pigeon",
+			DataKey:           "secret",
+			MemoryLimit:       1 << 20,
+			Readahead:         2 << 20,
+			PublicURL:         "ftp://example.com",
+			DataDir:           dir,
+		}).Validate()
+
+		Expect(err).To(HaveOccurred())
+		Expect(fields(err)).To(Equal([]string{
+			"TorrentURL", "DHTNodes[1]", "HTTPListenAddr", "TorrentListenAddr", "PeerTransport",
+			"DataKey", "MemoryLimit", "PublicURL",
+		}))
+		Expect(err).To(MatchError(ContainSubstring("Invalid PeerTransport: \"carrier-pigeon\", expected one of")))
+		Expect(err).To(MatchError(ContainSubstring("invalid port")))
+	})
+
+	It("needs a PublicURL, and no DLNA, to listen on a unix socket", func() {
+		err := (&Config{TorrentURL: magnet, DataDir: dir, HTTPListenAddr: "unix://" + filepath.Join(dir, "sock"), DLNA: true}).Validate()
+		Expect(fields(err)).To(Equal([]string{"PublicURL", "DLNA"}))
+	})
+
+	It("needs something to serve", func() {
+		err := (&Config{DataDir: dir}).Validate()
+		Expect(fields(err)).To(Equal([]string{"TorrentURL"}))
+	})
+
+	It("needs directories that exist, or can be written to", func() {
+		file := filepath.Join(dir, "file")
+		ioutil.WriteFile(file, []byte("hello"), 0644)
+
+		err := (&Config{TorrentURL: magnet, WatchDir: filepath.Join(dir, "missing"), SeedPath: file, DataDir: file}).Validate()
+		Expect(fields(err)).To(Equal([]string{"WatchDir", "DataDir", "SeedPath"}))
+	})
+
+	It("creates a proxy with options, validating them first", func() {
+		_, err := NewTorrentProxyWithOptions(magnet, WithTorrentListenAddr("localhost:0"), WithDataDir(dir), WithMemory(1<<20, 2<<20))
+		Expect(err).To(MatchError(ContainSubstring("Invalid MemoryLimit")))
+
+		p, err := NewTorrentProxyWithOptions(magnet,
+			WithTorrentListenAddr("localhost:0"),
+			WithDataDir(dir),
+			WithSkipJunk(),
+			WithConfig(func(config *Config) {
+				config.MaxPeers = 10
+			}),
+		)
+		Expect(err).To(Succeed())
+		defer p.Close()
+
+		Expect(p.config.DataDir).To(Equal(dir))
+		Expect(p.config.SkipJunk).To(BeTrue())
+		Expect(p.config.MaxPeers).To(Equal(10))
+	})
+})
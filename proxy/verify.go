@@ -0,0 +1,141 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Progress of checking the torrent's data against its piece hashes, see Verify.
+type VerifyStatus struct {
+	// "running" until every piece has been checked, then "done"
+	State string `json:"state"`
+	// How many pieces have been checked so far
+	Checked int `json:"checked"`
+	// How many pieces there are to check
+	Total int `json:"total"`
+	// Pieces that were complete, but failed the check and will be downloaded again
+	Failed int `json:"failed"`
+	// When the check started
+	Started time.Time `json:"started"`
+	// When the check finished, once it has
+	Finished *time.Time `json:"finished,omitempty"`
+}
+
+// Check the data of every piece against its hash, in the background, for after disk problems or
+// copying files into DataDir by hand.  Pieces that fail are marked incomplete, and downloaded again
+// when they're next needed.
+//
+// Returns the progress of the check, which is the one already running if there is one.
+func (p *TorrentProxy) Verify() (status *VerifyStatus, err error) {
+	if !p.hasInfo() {
+		return nil, fmt.Errorf("Torrent metadata is pending")
+	}
+
+	p.verifyLock.Lock()
+	defer p.verifyLock.Unlock()
+
+	if p.verification != nil && p.verification.State == "running" {
+		copied := *p.verification
+		return &copied, nil
+	}
+
+	p.verification = &VerifyStatus{
+		State:   "running",
+		Total:   p.torrent.NumPieces(),
+		Started: time.Now().UTC(),
+	}
+	copied := *p.verification
+
+	log.Printf("Verifying %d pieces", copied.Total)
+	go p.runVerify()
+
+	return &copied, nil
+}
+
+// Check each piece in turn, recording progress in p.verification.
+func (p *TorrentProxy) runVerify() {
+	t := p.torrent
+
+	for i := 0; i < t.NumPieces(); i++ {
+		select {
+		case <-p.closed:
+			return
+		default:
+		}
+
+		complete := t.PieceState(i).Complete
+		t.Piece(i).VerifyData()
+		failed := complete && !t.PieceState(i).Complete
+
+		p.verifyLock.Lock()
+		p.verification.Checked++
+		if failed {
+			p.verification.Failed++
+		}
+		p.verifyLock.Unlock()
+	}
+
+	p.verifyLock.Lock()
+	defer p.verifyLock.Unlock()
+
+	finished := time.Now().UTC()
+	p.verification.State = "done"
+	p.verification.Finished = &finished
+
+	log.Printf("Verified %d pieces, %d failed", p.verification.Checked, p.verification.Failed)
+}
+
+// Return the progress of the last check started with Verify, or nil if there hasn't been one.
+func (p *TorrentProxy) verifyStatus() *VerifyStatus {
+	p.verifyLock.Lock()
+	defer p.verifyLock.Unlock()
+
+	if p.verification == nil {
+		return nil
+	}
+
+	copied := *p.verification
+	return &copied
+}
+
+// Serve the progress of the last check as JSON on GET, or start one on POST.
+func (p *TorrentProxy) serveVerify(w http.ResponseWriter, r *http.Request) {
+	var status *VerifyStatus
+	code := 200
+
+	switch r.Method {
+	case "GET", "HEAD":
+		status = p.verifyStatus()
+		if status == nil {
+			p.errlog.Printf("%d %s %s", 404, r.Method, r.URL.Path)
+
+			writeError(w, r, 404, "No verification has been started", nil)
+			return
+		}
+	case "POST":
+		var err error
+		status, err = p.Verify()
+		if err != nil {
+			p.errlog.Printf("%d %s %s: %s", 503, r.Method, r.URL.Path, err)
+
+			writeError(w, r, 503, err.Error(), nil)
+			return
+		}
+		code = 202
+	default:
+		p.errlog.Printf("%d %s %s", 405, r.Method, r.URL.Path)
+
+		w.Header().Set("Allow", "GET, HEAD, POST")
+		writeError(w, r, 405, "Method Not Allowed", nil)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(status)
+
+	log.Printf("%d %s %s", code, r.Method, r.URL.Path)
+}
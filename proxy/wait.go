@@ -0,0 +1,97 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// How long GET /?wait= blocks for, if no timeout is asked for.
+const defaultWaitTimeout = 30 * time.Second
+
+// The most GET /?wait= can be asked to block for.
+const maxWaitTimeout = 10 * time.Minute
+
+// Block until the torrent reaches state, "ready" once it has its metadata or "complete" once
+// every piece is downloaded, the timeout passes, or done is closed.
+//
+// Returns true if the torrent reached the state.  Gives up at once if the torrent won't be downloaded.
+func (p *TorrentProxy) waitFor(state string, timeout time.Duration, done <-chan struct{}) bool {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-p.ready:
+	case <-p.refused:
+		return false
+	case <-timer.C:
+		return false
+	case <-done:
+		return false
+	case <-p.closed:
+		return false
+	}
+
+	if state == "ready" {
+		return true
+	}
+
+	for {
+		complete, changed := p.completion.Complete()
+		if complete {
+			return true
+		}
+
+		select {
+		case <-changed:
+		case <-timer.C:
+			return false
+		case <-done:
+			return false
+		case <-p.closed:
+			return false
+		}
+	}
+}
+
+// Block until the torrent reaches the state in ?wait=, for up to ?timeout=, a duration like 30s.
+// Returns true if the request was handled, because the request was invalid or timed out.
+func (p *TorrentProxy) serveWait(w http.ResponseWriter, r *http.Request) bool {
+	state := r.URL.Query().Get("wait")
+	if len(state) == 0 {
+		return false
+	}
+
+	if state != "ready" && state != "complete" {
+		p.errlog.Printf("%d %s: invalid wait", 400, r.URL.Path)
+
+		writeError(w, r, 400, "Invalid wait, expected ready or complete", nil)
+		return true
+	}
+
+	timeout := defaultWaitTimeout
+	if len(r.URL.Query().Get("timeout")) > 0 {
+		var err error
+		timeout, err = time.ParseDuration(r.URL.Query().Get("timeout"))
+		if err != nil || timeout <= 0 || timeout > maxWaitTimeout {
+			p.errlog.Printf("%d %s: invalid timeout", 400, r.URL.Path)
+
+			writeError(w, r, 400, fmt.Sprintf("Invalid timeout, expected a duration up to %s", maxWaitTimeout), nil)
+			return true
+		}
+	}
+
+	if p.waitFor(state, timeout, r.Context().Done()) {
+		return false
+	}
+
+	if p.serveRefused(w, r) {
+		return true
+	}
+
+	w.Header().Set("Retry-After", "0")
+	writeError(w, r, 503, fmt.Sprintf("Torrent is not %s yet", state), p.Status())
+
+	p.errlog.Printf("%d %s: not %s after %s", 503, r.URL.Path, state, timeout)
+	return true
+}
@@ -0,0 +1,122 @@
+package proxy
+
+import (
+	"io/ioutil"
+	"log"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// How often WatchDir is checked for new and deleted files.  Files modified more recently than
+// this are left until the next check, as they may still be being written.
+const watchInterval = 2 * time.Second
+
+// A file in WatchDir that's been seen.
+type watchedFile struct {
+	modTime time.Time
+	// the infohash of the torrent it added, or "" if it couldn't be added
+	id string
+}
+
+// Add and remove torrents as files appear in and disappear from dir, until the manager is closed.
+func (m *ProxyManager) runWatchDir(dir string) {
+	ticker := time.NewTicker(watchInterval)
+	defer ticker.Stop()
+
+	watched := make(map[string]*watchedFile)
+	for {
+		m.scanWatchDir(dir, watched)
+
+		select {
+		case <-ticker.C:
+		case <-m.closed:
+			return
+		}
+	}
+}
+
+// Add torrents for the files in dir that are new or changed since the last scan, and remove
+// those whose files have been deleted.
+func (m *ProxyManager) scanWatchDir(dir string, watched map[string]*watchedFile) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		log.Printf("Unable to read WatchDir: %s", err)
+		return
+	}
+
+	seen := make(map[string]bool)
+	for _, info := range infos {
+		name := info.Name()
+		ext := strings.ToLower(filepath.Ext(name))
+		if info.IsDir() || (ext != ".torrent" && ext != ".magnet") {
+			continue
+		}
+		seen[name] = true
+
+		old, ok := watched[name]
+		if ok && old.modTime.Equal(info.ModTime()) {
+			continue
+		}
+		if time.Since(info.ModTime()) < watchInterval {
+			continue
+		}
+
+		// a file that's been replaced may be for another torrent
+		if ok && len(old.id) > 0 {
+			m.Remove(old.id)
+		}
+
+		file := &watchedFile{modTime: info.ModTime()}
+		watched[name] = file
+
+		torrentURL, err := watchedFileURL(filepath.Join(dir, name), ext)
+		if err != nil {
+			log.Printf("Unable to read %s from WatchDir: %s", name, err)
+			continue
+		}
+
+		p, err := m.addURL(torrentURL)
+		if err != nil {
+			log.Printf("Unable to add %s from WatchDir: %s", name, err)
+			continue
+		}
+
+		file.id = p.infoHash()
+		log.Printf("Added %s from WatchDir: %s", name, file.id)
+	}
+
+	for name, file := range watched {
+		if seen[name] {
+			continue
+		}
+		delete(watched, name)
+
+		if len(file.id) > 0 {
+			log.Printf("Removing %s, %s was deleted from WatchDir", file.id, name)
+			m.Remove(file.id)
+		}
+	}
+}
+
+// Return the torrent URL for a file in WatchDir: the magnet URL a .magnet file holds, or a
+// file URL for a .torrent file.
+func watchedFileURL(path string, ext string) (torrentURL string, err error) {
+	if ext == ".torrent" {
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return "", err
+		}
+
+		u := url.URL{Scheme: "file", Path: filepath.ToSlash(abs)}
+		return u.String(), nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return
+	}
+
+	return strings.TrimSpace(string(data)), nil
+}
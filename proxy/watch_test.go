@@ -0,0 +1,101 @@
+package proxy
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("WatchDir", func() {
+	const hash = "adecafcafeadecafcafeadecafcafeadecafcafe"
+
+	var (
+		dir string
+		m   *ProxyManager
+	)
+
+	// write a file that looks like it was finished a while ago
+	write := func(name string, data []byte) {
+		path := filepath.Join(dir, name)
+		Expect(ioutil.WriteFile(path, data, 0644)).To(Succeed())
+
+		old := time.Now().Add(-time.Minute)
+		Expect(os.Chtimes(path, old, old)).To(Succeed())
+	}
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "evaporation-watch")
+		Expect(err).To(Succeed())
+
+		m, err = NewProxyManager(&Config{
+			TorrentListenAddr: "localhost:0",
+			DataDir:           "testdata",
+		})
+		Expect(err).To(Succeed())
+	})
+
+	AfterEach(func() {
+		m.Close()
+		os.RemoveAll(dir)
+	})
+
+	It("adds magnet and torrent files, and removes them when they're deleted", func() {
+		watched := make(map[string]*watchedFile)
+
+		sample, err := ioutil.ReadFile("testdata/sample.torrent")
+		Expect(err).To(Succeed())
+
+		write("a.magnet", []byte("magnet:?xt=urn:btih:"+hash+"\n"))
+		write("b.torrent", sample)
+		write("notes.txt", []byte("ignored"))
+
+		m.scanWatchDir(dir, watched)
+		Expect(m.List()).To(HaveLen(2))
+		_, ok := m.Get(hash)
+		Expect(ok).To(BeTrue())
+
+		Expect(os.Remove(filepath.Join(dir, "a.magnet"))).To(Succeed())
+
+		m.scanWatchDir(dir, watched)
+		Expect(m.List()).To(HaveLen(1))
+		_, ok = m.Get(hash)
+		Expect(ok).To(BeFalse())
+	})
+
+	It("leaves files that are still being written", func() {
+		watched := make(map[string]*watchedFile)
+
+		Expect(ioutil.WriteFile(filepath.Join(dir, "a.magnet"), []byte("magnet:?xt=urn:btih:"+hash), 0644)).To(Succeed())
+
+		m.scanWatchDir(dir, watched)
+		Expect(m.List()).To(BeEmpty())
+	})
+
+	It("doesn't retry files that can't be added until they change", func() {
+		watched := make(map[string]*watchedFile)
+
+		write("a.magnet", []byte("not a magnet"))
+		m.scanWatchDir(dir, watched)
+		Expect(watched).To(HaveKey("a.magnet"))
+		Expect(watched["a.magnet"].id).To(BeEmpty())
+
+		write("a.magnet", []byte("magnet:?xt=urn:btih:"+hash))
+		os.Chtimes(filepath.Join(dir, "a.magnet"), time.Now().Add(-30*time.Second), time.Now().Add(-30*time.Second))
+
+		m.scanWatchDir(dir, watched)
+		Expect(watched["a.magnet"].id).To(Equal(hash))
+	})
+
+	It("must be a directory", func() {
+		_, err := NewProxyManager(&Config{
+			TorrentListenAddr: "localhost:0",
+			WatchDir:          filepath.Join(dir, "missing"),
+		})
+		Expect(err).To(MatchError(ContainSubstring("Invalid WatchDir")))
+	})
+})
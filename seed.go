@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/cnelson/evaporation/proxy"
+
+	"github.com/anacrolix/dht"
+)
+
+func init() {
+	commands["seed"] = seed
+}
+
+// Create a torrent from a directory, seed it and serve its files, and block until it exits.
+func seed(args []string) {
+	var dhtNodes, trackers multiValue
+
+	flags := flag.NewFlagSet("seed", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Printf("Usage: %s seed [OPTIONS] dir\n", os.Args[0])
+		fmt.Println("   dir - A directory of files to create a torrent from. The torrent is named for it.")
+		fmt.Println("         Its .torrent file is served at /metainfo, and its magnet url is in the status.")
+
+		fmt.Println("OPTIONS:")
+		flags.PrintDefaults()
+	}
+	flags.Var(&dhtNodes, "dht", "host:port to seed DHT. Can be specified more than once.")
+	flags.Var(&trackers, "tracker", "Announce url of a tracker to add to the torrent. Can be specified more than once.")
+
+	var httpaddr = flags.String("http", "localhost:0", `host:port for the HTTP server to listen on. Use ":port" to listen on all interfaces.`)
+	var peeraddr = flags.String("peer-addr", ":0", "host:port for the torrent client to accept peer connections on.")
+	var dhtaddr = flags.String("dht-addr", "", "host:port for DHT traffic. Defaults to sharing the UDP port of -peer-addr.")
+	var upnp = flags.Bool("upnp", false, "Forward the -peer-addr port on the router with UPnP, so more peers can connect.")
+	var datadir = flags.String("datadir", ".", "Directory in which the DHT nodes are saved between runs.")
+	var drainTimeout = flags.Duration("drain-timeout", 10*time.Second, "How long to wait for active requests to finish when shutting down.")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(1)
+	}
+
+	if len(dhtNodes) == 0 {
+		nodes, _ := dht.GlobalBootstrapAddrs()
+		for _, node := range nodes {
+			dhtNodes = append(dhtNodes, node.String())
+		}
+	}
+
+	p, err := proxy.NewTorrentProxy(&proxy.Config{
+		DHTNodes:          dhtNodes,
+		SeedPath:          flags.Arg(0),
+		SeedTrackers:      trackers,
+		HTTPListenAddr:    *httpaddr,
+		TorrentListenAddr: *peeraddr,
+		DHTListenAddr:     *dhtaddr,
+		PortForwarding:    *upnp,
+		DataDir:           *datadir,
+	})
+	if err != nil {
+		log.Fatalf("Unable to start proxy: %s", err)
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		log.Printf("Received %s, shutting down", sig)
+
+		ctx, cancel := context.WithTimeout(context.Background(), *drainTimeout)
+		defer cancel()
+
+		err := p.Shutdown(ctx)
+		if err != nil {
+			log.Printf("Unclean shutdown: %s", err)
+			os.Exit(1)
+		}
+
+		os.Exit(0)
+	}()
+
+	log.Printf("Seeding %s", p.Status().Magnet)
+	log.Printf("Proxy up at: %s", p.URL())
+	p.Run()
+}
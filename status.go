@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/cnelson/evaporation/proxy"
+)
+
+// Format a byte count for humans.
+func humanBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	div, exp := int64(unit), 0
+	for i := n / unit; i >= unit; i /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// Fetch the status of a running proxy and print it.
+func status(args []string) {
+	flags := flag.NewFlagSet("status", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Printf("Usage: %s status [OPTIONS] url\n", os.Args[0])
+		fmt.Println("   url - The URL of a running proxy, e.g. http://localhost:8080")
+
+		fmt.Println("OPTIONS:")
+		flags.PrintDefaults()
+	}
+	// either token can read, so whichever the proxy was started with will do
+	defaultToken := os.Getenv("EVAPORATION_READ_TOKEN")
+	if len(defaultToken) == 0 {
+		defaultToken = os.Getenv("EVAPORATION_ADMIN_TOKEN")
+	}
+	var token = flags.String("token", defaultToken, "Bearer token to read the proxy's status with. Defaults to $EVAPORATION_READ_TOKEN, or $EVAPORATION_ADMIN_TOKEN.")
+	flags.Parse(args)
+
+	if flags.NArg() < 1 {
+		flags.Usage()
+		os.Exit(1)
+	}
+
+	req, err := http.NewRequest("GET", strings.TrimSuffix(flags.Arg(0), "/")+"/", nil)
+	if err != nil {
+		log.Fatalf("Invalid url: %s", err)
+	}
+	if len(*token) > 0 {
+		req.Header.Set("Authorization", "Bearer "+*token)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		log.Fatalf("Unable to reach proxy: %s", err)
+	}
+	defer resp.Body.Close()
+
+	// a pending torrent answers file requests with 503, but status is always 200
+	if resp.StatusCode != 200 {
+		log.Fatalf("Unexpected response from proxy: %s", resp.Status)
+	}
+
+	var s proxy.TorrentStatus
+	err = json.NewDecoder(resp.Body).Decode(&s)
+	if err != nil {
+		log.Fatalf("Unable to decode status: %s", err)
+	}
+
+	fmt.Printf("Name:   %s\n", s.Name)
+	fmt.Printf("ID:     %s\n", s.Hash)
+	fmt.Printf("Status: %s\n", s.Status)
+
+	if len(s.Files) > 0 {
+		fmt.Println()
+	}
+
+	for _, f := range s.Files {
+		fmt.Printf("%6.1f%% %10s  %s\n", f.Complete*100, humanBytes(f.Length), f.Path)
+	}
+}